@@ -0,0 +1,86 @@
+package discord
+
+import (
+	"aika/discord/discordai"
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/sirupsen/logrus"
+)
+
+type Conversations struct {
+	Session *discordgo.Session
+	Archive *discordai.Archive
+}
+
+func (c *Conversations) GetFunction_SearchPastConversations() discordai.Function {
+	return discordai.NewFunction(
+		"SearchPastConversations",
+		"Search earlier conversations in this server (or this DM) for what was said or decided. Results include the date & a link to the original message - share the link when you use a result.",
+		c.handler_SearchPastConversations,
+	).WithStatus("🗂️ searching old conversations")
+}
+
+type args_SearchPastConversations struct {
+	Query string `json:"query" description:"what to look for, written as a question or statement"`
+	Days  int    `json:"days,omitempty" description:"only search the last number of days"`
+	Limit int    `json:"limit,omitempty" description:"number of results to return (default 5)"`
+	discordai.Sender
+}
+
+// handler for SearchPastConversations
+func (c *Conversations) handler_SearchPastConversations(ctx context.Context, args args_SearchPastConversations) (string, error) {
+	since := time.Time{}
+	if args.Days > 0 {
+		since = time.Now().AddDate(0, 0, -args.Days)
+	}
+
+	results, err := c.Archive.Search(
+		ctx,
+		discordai.ArchiveScope(args.GuildID, args.ChannelID),
+		args.Query,
+		since,
+		args.Limit,
+		c.visibleTo(args.AuthorID, args.GuildID),
+	)
+	if err != nil {
+		return "", err
+	}
+	if len(results) == 0 {
+		return "no past conversations found.", nil
+	}
+
+	data, err := json.Marshal(results)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+// only channels the sender can see are searched - otherwise anyone
+// could read admin channels (& voice transcripts) through aika.
+// DMs only hold the sender's own conversations.
+func (c *Conversations) visibleTo(userID string, guildID string) func(channelID string) bool {
+	if guildID == "" {
+		return nil
+	}
+
+	// each channel is checked once per search
+	checked := make(map[string]bool)
+	return func(channelID string) bool {
+		if visible, ok := checked[channelID]; ok {
+			return visible
+		}
+		permissions, err := c.Session.State.UserChannelPermissions(userID, channelID)
+		if err != nil {
+			// deleted channels (& anything not cached) fail closed
+			logrus.WithError(err).WithField("channel", channelID).Debugln("failed to get permissions for archived channel")
+		}
+		visible := err == nil && permissions&discordgo.PermissionViewChannel != 0
+		checked[channelID] = visible
+		return visible
+	}
+}
@@ -2,11 +2,11 @@ package discord
 
 import (
 	"aika/discord/discordai"
+	"context"
 	"encoding/json"
+	"fmt"
 
 	"github.com/bwmarrin/discordgo"
-	"github.com/sashabaranov/go-openai"
-	"github.com/sashabaranov/go-openai/jsonschema"
 	"github.com/sirupsen/logrus"
 )
 
@@ -15,25 +15,15 @@ type Guilds struct {
 }
 
 func (g *Guilds) GetFunction_ListGuilds() discordai.Function {
-	return discordai.Function{
-		Definition: definition_listGuilds,
-		Handler:    g.handler_listGuilds,
-	}
-}
-
-var definition_listGuilds = openai.FunctionDefinition{
-	Name:        "listGuilds",
-	Description: "Retrieve a list of all guilds aika is in.",
-
-	Parameters: jsonschema.Definition{
-		Type:       jsonschema.Object,
-		Properties: map[string]jsonschema.Definition{},
-		Required:   []string{},
-	},
+	return discordai.NewFunction(
+		"listGuilds",
+		"Retrieve a list of all guilds aika is in.",
+		g.handler_listGuilds,
+	).WithTier(discordai.TierAdmin).WithStatus("📋 listing servers")
 }
 
-// handler for getRandomNumber
-func (g *Guilds) handler_listGuilds(msgMap map[string]interface{}) (string, error) {
+// handler for listGuilds
+func (g *Guilds) handler_listGuilds(_ context.Context, _ struct{}) (string, error) {
 
 	obj, err := g.action_listGuilds()
 	if err != nil {
@@ -80,3 +70,12 @@ func (g *Guilds) action_listGuilds() (guildResponse, error) {
 	}
 	return res, nil
 }
+
+// guild admins can manage the server
+func isGuildAdmin(s *discordgo.Session, userID string, channelID string) (bool, error) {
+	permissions, err := s.UserChannelPermissions(userID, channelID)
+	if err != nil {
+		return false, fmt.Errorf("failed to get permissions; %w", err)
+	}
+	return permissions&(discordgo.PermissionAdministrator|discordgo.PermissionManageServer) != 0, nil
+}
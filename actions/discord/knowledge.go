@@ -0,0 +1,183 @@
+package discord
+
+import (
+	"aika/discord/discordai"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	// plenty for docs - anything bigger is probably not a text file
+	maxDocumentSize = 2 << 20
+	maxSourceLength = 100
+)
+
+type Knowledge struct {
+	Session *discordgo.Session
+	Base    *discordai.KnowledgeBase
+}
+
+func (k *Knowledge) GetFunction_SearchKnowledgeBase() discordai.Function {
+	return discordai.NewFunction(
+		"SearchKnowledgeBase",
+		"Search the documents (FAQs, rules, guides) this server's admins uploaded. Use this before searching the web for questions about this server or its projects. Mention the source of any passage you use.",
+		k.handler_SearchKnowledgeBase,
+	).WithScopes(discordai.ScopeGuild | discordai.ScopeVoice).WithStatus("📚 searching the docs")
+}
+
+func (k *Knowledge) GetFunction_AddDocument() discordai.Function {
+	return discordai.NewFunction(
+		"addDocument",
+		"Add a text or markdown file (URL of an attachment) to this server's knowledge base. A document with the same name is replaced. Only server admins may do this.",
+		k.handler_addDocument,
+	).WithScopes(discordai.ScopeGuild).WithStatus("📚 reading the document").WithTimeout(2 * time.Minute)
+}
+
+func (k *Knowledge) GetFunction_ListDocuments() discordai.Function {
+	return discordai.NewFunction(
+		"listDocuments",
+		"List the documents in this server's knowledge base.",
+		k.handler_listDocuments,
+	).WithScopes(discordai.ScopeGuild).WithStatus("📚 checking the docs")
+}
+
+func (k *Knowledge) GetFunction_RemoveDocument() discordai.Function {
+	return discordai.NewFunction(
+		"removeDocument",
+		"Remove a document from this server's knowledge base. Only server admins may do this.",
+		k.handler_removeDocument,
+	).WithScopes(discordai.ScopeGuild).WithStatus("📚 removing the document")
+}
+
+type args_SearchKnowledgeBase struct {
+	Query string `json:"query" description:"what to look for, written as a question or statement"`
+	Limit int    `json:"limit,omitempty" description:"number of passages to return (default 4)"`
+	discordai.Sender
+}
+
+type args_addDocument struct {
+	URL  string `json:"url" description:"URL of the text or markdown file"`
+	Name string `json:"name,omitempty" description:"name of the document - defaults to the file name"`
+	discordai.Sender
+}
+
+type args_listDocuments struct {
+	discordai.Sender
+}
+
+type args_removeDocument struct {
+	Name string `json:"name" description:"name of the document to remove"`
+	discordai.Sender
+}
+
+// handler for SearchKnowledgeBase
+func (k *Knowledge) handler_SearchKnowledgeBase(ctx context.Context, args args_SearchKnowledgeBase) (string, error) {
+	passages, err := k.Base.Search(ctx, args.GuildID, args.Query, args.Limit)
+	if err != nil {
+		return "", err
+	}
+	if len(passages) == 0 {
+		return "this server has no documents in its knowledge base.", nil
+	}
+
+	data, err := json.Marshal(passages)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+// handler for addDocument
+func (k *Knowledge) handler_addDocument(ctx context.Context, args args_addDocument) (string, error) {
+	admin, err := isGuildAdmin(k.Session, args.AuthorID, args.ChannelID)
+	if err != nil {
+		return "", err
+	}
+	if !admin {
+		return "only server admins can add documents.", nil
+	}
+
+	name := args.Name
+	if name == "" {
+		name = documentName(args.URL)
+	}
+	if name == "" || len(name) > maxSourceLength {
+		return "give the document a short name.", nil
+	}
+
+	text, err := download(ctx, args.URL, maxDocumentSize)
+	if err != nil {
+		return "", err
+	}
+
+	document, err := k.Base.Add(ctx, args.GuildID, name, string(text), args.AuthorID)
+	switch {
+	case errors.Is(err, discordai.ErrDocumentNotText):
+		return "that isn't a text or markdown file.", nil
+	case errors.Is(err, discordai.ErrDocumentEmpty):
+		return "that document has no text.", nil
+	case errors.Is(err, discordai.ErrTooMuchKnowledge):
+		return "this server's knowledge base is full. remove an old document first.", nil
+	case err != nil:
+		return "", err
+	}
+
+	return fmt.Sprintf("added %s (%d passages) to the knowledge base", document.Source, document.Chunks), nil
+}
+
+// handler for listDocuments
+func (k *Knowledge) handler_listDocuments(_ context.Context, args args_listDocuments) (string, error) {
+	documents, err := k.Base.Documents(args.GuildID)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(documents)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+// handler for removeDocument
+func (k *Knowledge) handler_removeDocument(_ context.Context, args args_removeDocument) (string, error) {
+	admin, err := isGuildAdmin(k.Session, args.AuthorID, args.ChannelID)
+	if err != nil {
+		return "", err
+	}
+	if !admin {
+		return "only server admins can remove documents.", nil
+	}
+
+	err = k.Base.Remove(args.GuildID, args.Name)
+	if errors.Is(err, discordai.ErrDocumentNotFound) {
+		return fmt.Sprintf("no document called '%s' exists. use listDocuments to find document names.", args.Name), nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return "document removed", nil
+}
+
+// file name from a URL - discord attachment URLs end with the file name
+func documentName(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	name := path.Base(parsed.Path)
+	if name == "." || name == "/" {
+		return ""
+	}
+	return name
+}
@@ -0,0 +1,115 @@
+package discord
+
+import (
+	"aika/discord/discordai"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+type Lore struct {
+	Session  *discordgo.Session
+	Lorebook *discordai.Lorebook
+}
+
+func (l *Lore) GetFunction_AddLore() discordai.Function {
+	return discordai.NewFunction(
+		"addLore",
+		"Add server lore or an in-joke to the lorebook. It's shown to you whenever one of its keywords is mentioned. Only server admins may do this.",
+		l.handler_addLore,
+	).WithScopes(discordai.ScopeGuild).WithStatus("📜 writing lore")
+}
+
+func (l *Lore) GetFunction_ListLore() discordai.Function {
+	return discordai.NewFunction(
+		"listLore",
+		"List every lorebook entry for this server, including entry IDs.",
+		l.handler_listLore,
+	).WithScopes(discordai.ScopeGuild).WithStatus("📜 reading lore")
+}
+
+func (l *Lore) GetFunction_RemoveLore() discordai.Function {
+	return discordai.NewFunction(
+		"removeLore",
+		"Remove a lorebook entry from this server. Use listLore to find the entry ID. Only server admins may do this.",
+		l.handler_removeLore,
+	).WithScopes(discordai.ScopeGuild).WithStatus("📜 erasing lore")
+}
+
+type args_addLore struct {
+	Keywords []string `json:"keywords" description:"words or names which bring up this lore"`
+	Content  string   `json:"content" description:"the lore itself, written as a short note"`
+	Constant bool     `json:"constant,omitempty" description:"always show this lore, even when no keyword is mentioned"`
+	Priority int      `json:"priority,omitempty" description:"higher priority lore is shown first when there is too much"`
+	discordai.Sender
+}
+
+type args_listLore struct {
+	discordai.Sender
+}
+
+type args_removeLore struct {
+	ID string `json:"id" description:"ID of the lore entry to remove"`
+	discordai.Sender
+}
+
+// handler for addLore
+func (l *Lore) handler_addLore(_ context.Context, args args_addLore) (string, error) {
+	admin, err := isGuildAdmin(l.Session, args.AuthorID, args.ChannelID)
+	if err != nil {
+		return "", err
+	}
+	if !admin {
+		return "only server admins can add lore.", nil
+	}
+
+	entry, err := l.Lorebook.Add(args.GuildID, args.Keywords, args.Content, args.Constant, args.Priority)
+	switch {
+	case errors.Is(err, discordai.ErrLoreEmpty):
+		return "no lore provided.", nil
+	case errors.Is(err, discordai.ErrLoreNoKeywords):
+		return "lore needs at least one keyword unless it's constant.", nil
+	case errors.Is(err, discordai.ErrLoreTooLong):
+		return fmt.Sprintf("lore is too long. keep it under %d characters.", discordai.MaxLoreLength), nil
+	case errors.Is(err, discordai.ErrTooMuchLore):
+		return "this server has too much lore. remove an old entry first.", nil
+	case err != nil:
+		return "", err
+	}
+
+	return fmt.Sprintf("added lore %s", entry.ID), nil
+}
+
+// handler for listLore
+func (l *Lore) handler_listLore(_ context.Context, args args_listLore) (string, error) {
+	data, err := json.Marshal(l.Lorebook.Entries(args.GuildID))
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+// handler for removeLore
+func (l *Lore) handler_removeLore(_ context.Context, args args_removeLore) (string, error) {
+	admin, err := isGuildAdmin(l.Session, args.AuthorID, args.ChannelID)
+	if err != nil {
+		return "", err
+	}
+	if !admin {
+		return "only server admins can remove lore.", nil
+	}
+
+	err = l.Lorebook.Remove(args.GuildID, args.ID)
+	if errors.Is(err, discordai.ErrLoreNotFound) {
+		return "no lore with that ID exists in this server.", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return "lore removed", nil
+}
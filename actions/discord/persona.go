@@ -0,0 +1,155 @@
+package discord
+
+import (
+	"aika/discord/discordai"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// character cards with embedded art can be large PNGs
+const maxCardSize = 8 << 20
+
+type Personas struct {
+	Session *discordgo.Session
+	Library *discordai.Personas
+}
+
+func (p *Personas) GetFunction_ListPersonas() discordai.Function {
+	return discordai.NewFunction(
+		"listPersonas",
+		"List the personas aika can play and which one is used in this channel.",
+		p.handler_listPersonas,
+	).WithStatus("🎭 checking personas")
+}
+
+func (p *Personas) GetFunction_SetPersona() discordai.Function {
+	return discordai.NewFunction(
+		"setPersona",
+		"Change the persona aika plays in this server or channel. Only server admins may do this. Use listPersonas to find persona IDs.",
+		p.handler_setPersona,
+	).WithScopes(discordai.ScopeGuild | discordai.ScopeDM).WithStatus("🎭 changing persona")
+}
+
+func (p *Personas) GetFunction_InstallPersona() discordai.Function {
+	return discordai.NewFunction(
+		"installPersona",
+		"Install a SillyTavern or TavernAI character card (a .json or .png file URL) as a new persona.",
+		p.handler_installPersona,
+	).WithTier(discordai.TierAdmin).WithStatus("🎭 installing persona")
+}
+
+type args_listPersonas struct {
+	discordai.Sender
+}
+
+type args_setPersona struct {
+	Persona     string `json:"persona" description:"ID of the persona to use - empty with channel_only goes back to the server's persona"`
+	ChannelOnly bool   `json:"channel_only,omitempty" description:"only change the persona in this channel"`
+	discordai.Sender
+}
+
+type args_installPersona struct {
+	URL string `json:"url" description:"URL of the character card"`
+	ID  string `json:"id,omitempty" description:"persona ID to install as - defaults to the character's name"`
+}
+
+type personaResponse struct {
+	Current  string              `json:"current"`
+	Personas []discordai.Persona `json:"personas"`
+}
+
+// handler for listPersonas
+func (p *Personas) handler_listPersonas(_ context.Context, args args_listPersonas) (string, error) {
+	data, err := json.Marshal(personaResponse{
+		Current:  p.Library.For(args.GuildID, args.ChannelID).ID,
+		Personas: p.Library.List(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+// handler for setPersona
+func (p *Personas) handler_setPersona(_ context.Context, args args_setPersona) (string, error) {
+	// DMs belong to the sender
+	if args.GuildID != "" {
+		admin, err := isGuildAdmin(p.Session, args.AuthorID, args.ChannelID)
+		if err != nil {
+			return "", err
+		}
+		if !admin {
+			return "only server admins can change the persona.", nil
+		}
+	}
+
+	var err error
+	if args.ChannelOnly || args.GuildID == "" {
+		err = p.Library.SetChannel(args.ChannelID, args.Persona)
+	} else {
+		err = p.Library.SetGuild(args.GuildID, args.Persona)
+	}
+	if errors.Is(err, discordai.ErrUnknownPersona) {
+		return fmt.Sprintf("no persona '%s' exists. use listPersonas to find persona IDs.", args.Persona), nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	persona := p.Library.For(args.GuildID, args.ChannelID)
+	return fmt.Sprintf("now playing %s (%s) - takes effect from the next message", persona.Name, persona.ID), nil
+}
+
+// handler for installPersona
+func (p *Personas) handler_installPersona(ctx context.Context, args args_installPersona) (string, error) {
+	card, err := download(ctx, args.URL, maxCardSize)
+	if err != nil {
+		return "", err
+	}
+
+	persona, err := p.Library.Install(args.ID, card)
+	if errors.Is(err, discordai.ErrInvalidCard) {
+		return fmt.Sprintf("that isn't a valid character card; %s", err), nil
+	}
+	if errors.Is(err, discordai.ErrPersonaExists) {
+		return fmt.Sprintf("a persona called '%s' already exists. pick a different ID.", persona.ID), nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("installed %s as '%s'. use setPersona to start using it.", persona.Name, persona.ID), nil
+}
+
+// download a file with a size limit
+func download(ctx context.Context, url string, limit int64) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url; %w", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download; %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download; status %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to download; %w", err)
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("file is larger than %d bytes", limit)
+	}
+	return data, nil
+}
@@ -0,0 +1,42 @@
+package discord
+
+import (
+	"aika/discord/discordai"
+	"aika/usage"
+	"context"
+	"encoding/json"
+)
+
+// days reported when the AI doesn't ask for a period
+const defaultReportDays = 30
+
+type Usage struct {
+	Ledger *usage.Ledger
+}
+
+type args_getUsageReport struct {
+	Days int `json:"days,omitempty" description:"number of days to report on including today - defaults to 30, at most 31"`
+}
+
+func (u *Usage) GetFunction_GetUsageReport() discordai.Function {
+	return discordai.NewFunction(
+		"getUsageReport",
+		"Retrieve how much aika has cost to run - spend per day & guild, the most expensive users and cost by model.",
+		u.handler_getUsageReport,
+	).WithTier(discordai.TierAdmin).WithStatus("📊 tallying costs")
+}
+
+// handler for getUsageReport
+func (u *Usage) handler_getUsageReport(_ context.Context, args args_getUsageReport) (string, error) {
+	days := args.Days
+	if days <= 0 {
+		days = defaultReportDays
+	}
+
+	data, err := json.Marshal(u.Ledger.Report(days))
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
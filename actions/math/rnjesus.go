@@ -2,51 +2,30 @@ package math
 
 import (
 	"aika/discord/discordai"
+	"context"
 	"fmt"
 	"math"
 	"math/rand"
 
-	"github.com/sashabaranov/go-openai"
-	"github.com/sashabaranov/go-openai/jsonschema"
 	"github.com/sirupsen/logrus"
 )
 
 var (
-	Function_GenRandomNumber = discordai.Function{
-		Definition: definition_getRandomNumber,
-		Handler:    handler_GetRandomNumber,
-	}
+	Function_GenRandomNumber = discordai.NewFunction(
+		"getRandomNumber",
+		"generate a random number with decimals.",
+		handler_GetRandomNumber,
+	).WithStatus("🎲 rolling the dice")
 )
 
-var definition_getRandomNumber = openai.FunctionDefinition{
-	Name:        "getRandomNumber",
-	Description: "generate a random number with decimals.",
-
-	Parameters: jsonschema.Definition{
-		Type: jsonschema.Object,
-		Properties: map[string]jsonschema.Definition{
-			"min": {
-				Type:        jsonschema.Number,
-				Description: "inclusive minimum random value",
-				Properties:  map[string]jsonschema.Definition{},
-			},
-			"max": {
-				Type:        jsonschema.Number,
-				Description: "exclusive maximum random value",
-				Properties:  map[string]jsonschema.Definition{},
-			},
-			"round": {
-				Type:        jsonschema.Boolean,
-				Description: "true to round the random number to the nearest whole number.",
-				Properties:  map[string]jsonschema.Definition{},
-			},
-		},
-		Required: []string{"min", "max", "round"},
-	},
+type args_getRandomNumber struct {
+	Min   float64 `json:"min" description:"inclusive minimum random value"`
+	Max   float64 `json:"max" description:"exclusive maximum random value"`
+	Round bool    `json:"round" description:"true to round the random number to the nearest whole number."`
 }
 
-func handler_GetRandomNumber(msgMap map[string]interface{}) (string, error) {
-	value := action_GetRandomNumber(msgMap["min"].(float64), msgMap["max"].(float64), msgMap["round"].(bool))
+func handler_GetRandomNumber(_ context.Context, args args_getRandomNumber) (string, error) {
+	value := action_GetRandomNumber(args.Min, args.Max, args.Round)
 	return fmt.Sprintf("%f", value), nil
 }
 
@@ -3,13 +3,14 @@ package actions
 import (
 	"aika/discord/discordai"
 	"aika/storage"
+	"aika/usage"
 	"context"
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/sashabaranov/go-openai"
-	"github.com/sashabaranov/go-openai/jsonschema"
 	"github.com/sirupsen/logrus"
 )
 
@@ -22,34 +23,25 @@ type DallE struct {
 }
 
 func (ai *DallE) GetFunction_DallE() discordai.Function {
-	return discordai.Function{
-		Definition: definition_DallE,
-		Handler:    ai.handler_DallE,
-	}
+	return discordai.NewFunction(
+		"GenerateImage",
+		"Generate an image using DallE, an AI image generator.",
+		ai.handler_DallE,
+	).
+		WithTimeout(90*time.Second). // image generation is slow
+		WithFlags("image_generation", discordai.FlagExpensive).
+		WithStatus("🎨 generating image")
 }
 
 // === DALL-E
 
-var definition_DallE = openai.FunctionDefinition{
-	Name:        "GenerateImage",
-	Description: "Generate an image using DallE, an AI image generator.",
-
-	Parameters: jsonschema.Definition{
-		Type: jsonschema.Object,
-		Properties: map[string]jsonschema.Definition{
-			"prompt": {
-				Type:        jsonschema.String,
-				Description: "AI image generation prompt",
-				Properties:  map[string]jsonschema.Definition{},
-			},
-		},
-		Required: []string{"prompt"},
-	},
+type args_DallE struct {
+	Prompt string `json:"prompt" description:"AI image generation prompt"`
 }
 
-func (ai *DallE) handler_DallE(msgMap map[string]interface{}) (string, error) {
+func (ai *DallE) handler_DallE(ctx context.Context, args args_DallE) (string, error) {
 	// call function
-	url, err := ai.action_DallE(msgMap["prompt"].(string))
+	url, err := ai.action_DallE(ctx, args.Prompt)
 	if err != nil {
 		return "", err
 	}
@@ -68,7 +60,7 @@ func (ai *DallE) handler_DallE(msgMap map[string]interface{}) (string, error) {
 	return string(data), nil
 }
 
-func (ai *DallE) action_DallE(prompt string) (string, error) {
+func (ai *DallE) action_DallE(ctx context.Context, prompt string) (string, error) {
 	reqUrl := openai.ImageRequest{
 		Prompt:         prompt,
 		Size:           openai.CreateImageSize1024x1024,
@@ -79,10 +71,15 @@ func (ai *DallE) action_DallE(prompt string) (string, error) {
 		//Style:  openai.CreateImageStyleVivid,
 	}
 
-	respUrl, err := ai.Client.CreateImage(context.Background(), reqUrl)
+	respUrl, err := ai.Client.CreateImage(ctx, reqUrl)
 	if err != nil {
 		return "", fmt.Errorf("failed to create image; %w", err)
 	}
+	usage.Record(ctx, usage.Usage{
+		Kind:   usage.KindImage,
+		Model:  reqUrl.Model,
+		Images: reqUrl.N,
+	})
 
 	oaiURL := respUrl.Data[0].URL
 	if ai.S3 == nil {
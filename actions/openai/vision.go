@@ -8,53 +8,38 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/sashabaranov/go-openai"
-	"github.com/sashabaranov/go-openai/jsonschema"
 )
 
 // because OpenAI actions require a client,
 // the must be acquired via "get" functions.
 
 type Vision struct {
-	Client *openai.Client
+	Provider ai.Provider
+	Model    ai.VisionModel
 }
 
 func (vis *Vision) GetFunction_DescribeImage() discordai.Function {
-	return discordai.Function{
-		Definition: definition_DescribeImage,
-		Handler:    vis.handler_DescribeImage,
-	}
-}
-
-var definition_DescribeImage = openai.FunctionDefinition{
-	Name: "DescribeImage",
-	Description: `Answer a question about an image.
+	return discordai.NewFunction(
+		"DescribeImage",
+		`Answer a question about an image.
 Can be used to get a description or to answer specific questions.
 Returns a short answer to the query.
 Supports: PNG (.png), JPEG (.jpeg and .jpg), WEBP (.webp), and non-animated GIF (.gif).`,
+		vis.handler_DescribeImage,
+	).WithTimeout(time.Minute).WithStatus("👀 looking at the image")
+}
 
-	Parameters: jsonschema.Definition{
-		Type: jsonschema.Object,
-		Properties: map[string]jsonschema.Definition{
-			"image": {
-				Type:        jsonschema.String,
-				Description: "Image URL. Format: https://example.com/image.jpg",
-				Properties:  map[string]jsonschema.Definition{},
-			},
-			"query": {
-				Type:        jsonschema.String,
-				Description: "Query. Question or task for inspecting the image.",
-				Properties:  map[string]jsonschema.Definition{},
-			},
-		},
-		Required: []string{"image", "query"},
-	},
+type args_DescribeImage struct {
+	Image string `json:"image" description:"Image URL. Format: https://example.com/image.jpg"`
+	Query string `json:"query" description:"Query. Question or task for inspecting the image."`
 }
 
-func (vis *Vision) handler_DescribeImage(msgMap map[string]interface{}) (string, error) {
+func (vis *Vision) handler_DescribeImage(ctx context.Context, args args_DescribeImage) (string, error) {
 	// call function
-	answer, err := vis.action_DescribeImage(msgMap["image"].(string), msgMap["query"].(string))
+	answer, err := vis.action_DescribeImage(ctx, args.Image, args.Query)
 	if err != nil {
 		return "", err
 	}
@@ -73,7 +58,7 @@ func (vis *Vision) handler_DescribeImage(msgMap map[string]interface{}) (string,
 	return string(data), nil
 }
 
-func (vis *Vision) action_DescribeImage(image string, query string) (string, error) {
+func (vis *Vision) action_DescribeImage(ctx context.Context, image string, query string) (string, error) {
 	// correct tenor URLs so aika can process them
 	if strings.Index(image, "https://tenor.com/view") == 0 {
 		newUrl, err := web.TenorToGif(image)
@@ -85,7 +70,7 @@ func (vis *Vision) action_DescribeImage(image string, query string) (string, err
 
 	// send image to OAI
 	req := &ai.VisionRequest{
-		Client: vis.Client,
+		Provider: vis.Provider,
 		System: openai.ChatCompletionMessage{
 			Role: openai.ChatMessageRoleSystem,
 			Content: `You are an image inspection utility.
@@ -95,9 +80,9 @@ Keep your reply condensed and avoid lists.`,
 		},
 		Message:  query,
 		ImageURL: image,
-		Model:    ai.VisionModel_GPT4o,
+		Model:    vis.Model,
 	}
-	response, err := req.Send(context.Background())
+	response, err := req.Send(ctx)
 	if err != nil {
 		return "", fmt.Errorf("failed to query image; %w", err)
 	}
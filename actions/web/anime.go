@@ -2,20 +2,19 @@ package web
 
 import (
 	"aika/discord/discordai"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/url"
 	"time"
-
-	"github.com/sashabaranov/go-openai"
-	"github.com/sashabaranov/go-openai/jsonschema"
 )
 
 var (
-	Function_GetAnime = discordai.Function{
-		Definition: definition_GetAnime,
-		Handler:    handler_FindAnime,
-	}
+	Function_GetAnime = discordai.NewFunction(
+		"GetAnime",
+		"Search for information on an anime.",
+		handler_FindAnime,
+	).WithStatus("📺 looking up anime")
 )
 
 type SearchResult struct {
@@ -145,24 +144,12 @@ type AnimeResult struct {
 	Animes []AnimeInfo `json:"animes"`
 }
 
-var definition_GetAnime = openai.FunctionDefinition{
-	Name:        "GetAnime",
-	Description: "Search for information on an anime.",
-	Parameters: jsonschema.Definition{
-		Type: jsonschema.Object,
-		Properties: map[string]jsonschema.Definition{
-			"query": {
-				Type:        jsonschema.String,
-				Description: "Anime search query. Best kept short and concise, such as the title of the anime.",
-				Properties:  map[string]jsonschema.Definition{},
-			},
-		},
-		Required: []string{"query"},
-	},
+type args_GetAnime struct {
+	Query string `json:"query" description:"Anime search query. Best kept short and concise, such as the title of the anime."`
 }
 
-func handler_FindAnime(msgMap map[string]interface{}) (string, error) {
-	tags, err := action_FindAnime(msgMap["query"].(string))
+func handler_FindAnime(ctx context.Context, args args_GetAnime) (string, error) {
+	tags, err := action_FindAnime(ctx, args.Query)
 	if err != nil {
 		return "", err
 	}
@@ -175,8 +162,8 @@ func handler_FindAnime(msgMap map[string]interface{}) (string, error) {
 	return string(data), err
 }
 
-func action_FindAnime(query string) (AnimeResult, error) {
-	data, err := fetch("https://api.jikan.moe/v4/anime?q=" + url.QueryEscape(query) + "&sfw&limit=3")
+func action_FindAnime(ctx context.Context, query string) (AnimeResult, error) {
+	data, err := fetch(ctx, "https://api.jikan.moe/v4/anime?q="+url.QueryEscape(query)+"&sfw&limit=3")
 	if err != nil {
 		return AnimeResult{}, fmt.Errorf("fetch anime failed; %w", err)
 	}
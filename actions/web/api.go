@@ -1,12 +1,18 @@
 package web
 
 import (
+	"context"
 	"io/ioutil"
 	"net/http"
 )
 
-func fetch(url string) ([]byte, error) {
-	resp, err := http.Get(url)
+func fetch(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
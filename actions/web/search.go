@@ -2,23 +2,24 @@ package web
 
 import (
 	"aika/discord/discordai"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/gocolly/colly"
-	"github.com/sashabaranov/go-openai"
-	"github.com/sashabaranov/go-openai/jsonschema"
 )
 
 const maxSearchResults = 5
 
 var (
-	Function_SearchWeb = discordai.Function{
-		Definition: definition_SearchWeb,
-		Handler:    handler_SearchWeb,
-	}
+	Function_SearchWeb = discordai.NewFunction(
+		"SearchWeb",
+		"Search the internet. Returns the top 5 results for the search query.",
+		handler_SearchWeb,
+	).WithStatus("🔍 searching the web")
 )
 
 type webResult struct {
@@ -30,24 +31,12 @@ type webResults struct {
 	Results []webResult `json:"results"`
 }
 
-var definition_SearchWeb = openai.FunctionDefinition{
-	Name:        "SearchWeb",
-	Description: "Search the internet. Returns the top 5 results for the search query.",
-	Parameters: jsonschema.Definition{
-		Type: jsonschema.Object,
-		Properties: map[string]jsonschema.Definition{
-			"query": {
-				Type:        jsonschema.String,
-				Description: "Search query.",
-				Properties:  map[string]jsonschema.Definition{},
-			},
-		},
-		Required: []string{"query"},
-	},
+type args_SearchWeb struct {
+	Query string `json:"query" description:"Search query."`
 }
 
-func handler_SearchWeb(msgMap map[string]interface{}) (string, error) {
-	results, err := action_SearchWeb(msgMap["query"].(string))
+func handler_SearchWeb(ctx context.Context, args args_SearchWeb) (string, error) {
+	results, err := action_SearchWeb(ctx, args.Query)
 	if err != nil {
 		return "", err
 	}
@@ -60,10 +49,14 @@ func handler_SearchWeb(msgMap map[string]interface{}) (string, error) {
 	return string(data), err
 }
 
-func action_SearchWeb(input string) (webResults, error) {
+func action_SearchWeb(ctx context.Context, input string) (webResults, error) {
 	c := colly.NewCollector(
 		colly.UserAgent("Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/111.0.0.0 Safari/537.36"),
 	)
+	// colly has no context support - stop at the deadline instead
+	if deadline, ok := ctx.Deadline(); ok {
+		c.SetRequestTimeout(time.Until(deadline))
+	}
 	searchResults := webResults{}
 	c.OnHTML("body > form > div > table:nth-of-type(3) > tbody", func(e *colly.HTMLElement) {
 		resultTitle := ""
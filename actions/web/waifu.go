@@ -2,69 +2,41 @@ package web
 
 import (
 	"aika/discord/discordai"
+	"context"
 	"encoding/json"
 	"strings"
-
-	"github.com/sashabaranov/go-openai"
-	"github.com/sashabaranov/go-openai/jsonschema"
 )
 
 var (
-	Function_GetWaifuCateogires = discordai.Function{
-		Definition: definition_GetWaifuCategories,
-		Handler:    handler_GetWaifuCategories,
-	}
-	Function_GetWaifuSfw = discordai.Function{
-		Definition: definition_GetWaifuSfw,
-		Handler:    handler_GetWaifuSfw,
-	}
-	Function_GetWaifuNsfw = discordai.Function{
-		Definition: definition_GetWaifuNsfw,
-		Handler:    handler_GetWaifuNsfw,
-	}
+	Function_GetWaifuCateogires = discordai.NewFunction(
+		"GetWaifuCategories",
+		"Returns available categories from waifu.pics, an anime image API.",
+		handler_GetWaifuCategories,
+	).WithStatus("🖼️ finding an image")
+	Function_GetWaifuSfw = discordai.NewFunction(
+		"GetWaifuSfw",
+		"Returns an anime waifu image from waifu.pics, an anime image API.",
+		handler_GetWaifuSfw,
+	).WithStatus("🖼️ finding an image")
+	Function_GetWaifuNsfw = discordai.NewFunction(
+		"GetWaifuOther",
+		"Returns an 'Other' anime waifu image from waifu.pics, an anime image API. Use GetWaifuCategories to get available categories.",
+		handler_GetWaifuNsfw,
+	).WithFlags("nsfw").WithStatus("🖼️ finding an image")
 )
 
-var definition_GetWaifuCategories = openai.FunctionDefinition{
-	Name:        "GetWaifuCategories",
-	Description: "Returns available categories from waifu.pics, an anime image API.",
-	Parameters: jsonschema.Definition{
-		Type:       jsonschema.Object,
-		Properties: map[string]jsonschema.Definition{},
-		Required:   []string{},
-	},
-}
+type waifuCategorySfw string
+type waifuCategoryNsfw string
+
+func (waifuCategorySfw) Enum() []string  { return waifu_categories_sfw }
+func (waifuCategoryNsfw) Enum() []string { return waifu_categories_nsfw }
 
-var definition_GetWaifuSfw = openai.FunctionDefinition{
-	Name:        "GetWaifuSfw",
-	Description: "Returns an anime waifu image from waifu.pics, an anime image API.",
-	Parameters: jsonschema.Definition{
-		Type: jsonschema.Object,
-		Properties: map[string]jsonschema.Definition{
-			"category": {
-				Type:        jsonschema.String,
-				Enum:        waifu_categories_sfw,
-				Description: "Category of anime image to return. Use GetWaifuCategories to get available categories.",
-				Properties:  map[string]jsonschema.Definition{},
-			},
-		},
-		Required: []string{"category"},
-	},
+type args_GetWaifuSfw struct {
+	Category waifuCategorySfw `json:"category" description:"Category of anime image to return. Use GetWaifuCategories to get available categories."`
 }
-var definition_GetWaifuNsfw = openai.FunctionDefinition{
-	Name:        "GetWaifuOther",
-	Description: "Returns an 'Other' anime waifu image from waifu.pics, an anime image API. Use GetWaifuCategories to get available categories.",
-	Parameters: jsonschema.Definition{
-		Type: jsonschema.Object,
-		Properties: map[string]jsonschema.Definition{
-			"category": {
-				Type:        jsonschema.String,
-				Enum:        waifu_categories_nsfw,
-				Description: "Category of 'Other' anime image to return.",
-				Properties:  map[string]jsonschema.Definition{},
-			},
-		},
-		Required: []string{"category"},
-	},
+
+type args_GetWaifuNsfw struct {
+	Category waifuCategoryNsfw `json:"category" description:"Category of 'Other' anime image to return."`
 }
 
 var waifu_categories_sfw = []string{
@@ -111,11 +83,11 @@ var waifu_categories_nsfw = []string{
 }
 
 type waifuResponse struct {
-	URL *string `json:"url",omitempty`
+	URL *string `json:"url,omitempty"`
 }
 
-func handler_GetWaifuSfw(msgMap map[string]interface{}) (string, error) {
-	tags, err := action_GetWaifu("sfw", msgMap["category"].(string))
+func handler_GetWaifuSfw(ctx context.Context, args args_GetWaifuSfw) (string, error) {
+	tags, err := action_GetWaifu(ctx, "sfw", string(args.Category))
 	if err != nil {
 		return "", err
 	}
@@ -127,8 +99,8 @@ func handler_GetWaifuSfw(msgMap map[string]interface{}) (string, error) {
 
 	return string(data), err
 }
-func handler_GetWaifuNsfw(msgMap map[string]interface{}) (string, error) {
-	tags, err := action_GetWaifu("nsfw", msgMap["category"].(string))
+func handler_GetWaifuNsfw(ctx context.Context, args args_GetWaifuNsfw) (string, error) {
+	tags, err := action_GetWaifu(ctx, "nsfw", string(args.Category))
 	if err != nil {
 		return "", err
 	}
@@ -140,8 +112,8 @@ func handler_GetWaifuNsfw(msgMap map[string]interface{}) (string, error) {
 
 	return string(data), err
 }
-func action_GetWaifu(waifu_type string, category string) (waifuResponse, error) {
-	data, err := fetch("https://api.waifu.pics/" + waifu_type + "/" + strings.ReplaceAll(category, "*", "o"))
+func action_GetWaifu(ctx context.Context, waifu_type string, category string) (waifuResponse, error) {
+	data, err := fetch(ctx, "https://api.waifu.pics/"+waifu_type+"/"+strings.ReplaceAll(category, "*", "o"))
 	if err != nil {
 		return waifuResponse{}, err
 	}
@@ -155,7 +127,7 @@ type waifuCategories struct {
 	Nsfw []string `json:"other_categories"`
 }
 
-func handler_GetWaifuCategories(_ map[string]interface{}) (string, error) {
+func handler_GetWaifuCategories(_ context.Context, _ struct{}) (string, error) {
 	categories := waifuCategories{
 		Sfw:  waifu_categories_sfw,
 		Nsfw: waifu_categories_nsfw,
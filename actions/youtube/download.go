@@ -3,12 +3,11 @@ package youtube
 import (
 	"aika/discord/discordai"
 	"aika/storage"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-
-	"github.com/sashabaranov/go-openai"
-	"github.com/sashabaranov/go-openai/jsonschema"
+	"time"
 
 	yt "github.com/kkdai/youtube/v2"
 )
@@ -18,30 +17,22 @@ type Downloader struct {
 }
 
 func (downloader *Downloader) GetFunction_SaveYoutube() discordai.Function {
-	return discordai.Function{
-		Definition: definition_SaveYoutube,
-		Handler:    downloader.handler_SaveYoutube,
-	}
+	return discordai.NewFunction(
+		"SaveYoutube",
+		"Save a youtube video.",
+		downloader.handler_SaveYoutube,
+	).
+		WithTimeout(5 * time.Minute). // the whole video is uploaded to s3
+		WithFlags(discordai.FlagExpensive).
+		WithStatus("📥 downloading video")
 }
 
-var definition_SaveYoutube = openai.FunctionDefinition{
-	Name:        "SaveYoutube",
-	Description: "Save a youtube video.",
-	Parameters: jsonschema.Definition{
-		Type: jsonschema.Object,
-		Properties: map[string]jsonschema.Definition{
-			"url": {
-				Type:        jsonschema.String,
-				Description: "Full Video URL.",
-				Properties:  map[string]jsonschema.Definition{},
-			},
-		},
-		Required: []string{"url"},
-	},
+type args_SaveYoutube struct {
+	URL string `json:"url" description:"Full Video URL."`
 }
 
-func (downloader *Downloader) handler_SaveYoutube(msgMap map[string]interface{}) (string, error) {
-	results, err := downloader.action_SaveYoutube(msgMap["url"].(string))
+func (downloader *Downloader) handler_SaveYoutube(ctx context.Context, args args_SaveYoutube) (string, error) {
+	results, err := downloader.action_SaveYoutube(ctx, args.URL)
 	if err != nil {
 		return "", err
 	}
@@ -54,9 +45,9 @@ func (downloader *Downloader) handler_SaveYoutube(msgMap map[string]interface{})
 	return string(data), err
 }
 
-func (downloader *Downloader) action_SaveYoutube(url string) (string, error) {
+func (downloader *Downloader) action_SaveYoutube(ctx context.Context, url string) (string, error) {
 	c := yt.Client{}
-	vid, err := c.GetVideo(url)
+	vid, err := c.GetVideoContext(ctx, url)
 	if err != nil {
 		return "", fmt.Errorf("failed to find youtube video; %w", err)
 	}
@@ -78,7 +69,7 @@ func (downloader *Downloader) action_SaveYoutube(url string) (string, error) {
 	// to save me money lmfao
 	target := formats[0] // largest format
 
-	stream, _, err := c.GetStream(vid, &target)
+	stream, _, err := c.GetStreamContext(ctx, vid, &target)
 	if err != nil {
 		return "", fmt.Errorf("failed to get stream; %w", err)
 	}
@@ -3,13 +3,12 @@ package youtube
 import (
 	"aika/discord/discordai"
 	"aika/voice/transcoding"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 
 	yt "github.com/kkdai/youtube/v2"
-	"github.com/sashabaranov/go-openai"
-	"github.com/sashabaranov/go-openai/jsonschema"
 	"github.com/sirupsen/logrus"
 )
 
@@ -25,30 +24,19 @@ type Player struct {
 }
 
 func (player *Player) GetFunction_PlayAudio() discordai.Function {
-	return discordai.Function{
-		Definition: definition_PlayAudio,
-		Handler:    player.handler_PlayAudio,
-	}
+	return discordai.NewFunction(
+		"PlayAudio",
+		"Play the audio or music of a youtube video over voice chat.",
+		player.handler_PlayAudio,
+	).WithFlags("voice").WithStatus("🎵 loading audio")
 }
 
-var definition_PlayAudio = openai.FunctionDefinition{
-	Name:        "PlayAudio",
-	Description: "Play the audio or music of a youtube video over voice chat.",
-	Parameters: jsonschema.Definition{
-		Type: jsonschema.Object,
-		Properties: map[string]jsonschema.Definition{
-			"url": {
-				Type:        jsonschema.String,
-				Description: "Full Video URL.",
-				Properties:  map[string]jsonschema.Definition{},
-			},
-		},
-		Required: []string{"url"},
-	},
+type args_PlayAudio struct {
+	URL string `json:"url" description:"Full Video URL."`
 }
 
-func (player *Player) handler_PlayAudio(msgMap map[string]interface{}) (string, error) {
-	err := player.action_PlayAudio(msgMap["url"].(string))
+func (player *Player) handler_PlayAudio(ctx context.Context, args args_PlayAudio) (string, error) {
+	err := player.action_PlayAudio(ctx, args.URL)
 	if err != nil {
 		return "", err
 	}
@@ -56,13 +44,13 @@ func (player *Player) handler_PlayAudio(msgMap map[string]interface{}) (string,
 	return "sound now playing", err
 }
 
-func (player *Player) action_PlayAudio(url string) error {
+func (player *Player) action_PlayAudio(ctx context.Context, url string) error {
 	if player.Mixer == nil {
 		return errors.New("mixer not found")
 	}
 
 	c := yt.Client{}
-	vid, err := c.GetVideo(url)
+	vid, err := c.GetVideoContext(ctx, url)
 	if err != nil {
 		return fmt.Errorf("failed to find youtube video; %w", err)
 	}
@@ -72,6 +60,7 @@ func (player *Player) action_PlayAudio(url string) error {
 	// this selects the _smallest_ format or so
 	target := formats[len(formats)-1]
 
+	// playback outlives the function call so the stream must not use ctx
 	stream, size, err := c.GetStream(vid, &target)
 	if err != nil {
 		return fmt.Errorf("failed to get stream; %w", err)
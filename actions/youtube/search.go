@@ -2,6 +2,7 @@ package youtube
 
 import (
 	"aika/discord/discordai"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -11,18 +12,16 @@ import (
 	"strings"
 
 	"github.com/buger/jsonparser"
-
-	"github.com/sashabaranov/go-openai"
-	"github.com/sashabaranov/go-openai/jsonschema"
 )
 
 const maxSearchResults = 5
 
 var (
-	Function_SearchYoutube = discordai.Function{
-		Definition: definition_SearchYoutube,
-		Handler:    handler_SearchYoutube,
-	}
+	Function_SearchYoutube = discordai.NewFunction(
+		"SearchYoutube",
+		"Search youtube for a video. Returns the top 5 results for the search query.",
+		handler_SearchYoutube,
+	).WithStatus("🔍 searching youtube")
 )
 
 type youtubeResult struct {
@@ -33,24 +32,12 @@ type youtubeResults struct {
 	Results []youtubeResult `json:"results"`
 }
 
-var definition_SearchYoutube = openai.FunctionDefinition{
-	Name:        "SearchYoutube",
-	Description: "Search youtube for a video. Returns the top 5 results for the search query.",
-	Parameters: jsonschema.Definition{
-		Type: jsonschema.Object,
-		Properties: map[string]jsonschema.Definition{
-			"query": {
-				Type:        jsonschema.String,
-				Description: "Search query.",
-				Properties:  map[string]jsonschema.Definition{},
-			},
-		},
-		Required: []string{"query"},
-	},
+type args_SearchYoutube struct {
+	Query string `json:"query" description:"Search query."`
 }
 
-func handler_SearchYoutube(msgMap map[string]interface{}) (string, error) {
-	results, err := action_SearchYoutube(msgMap["query"].(string))
+func handler_SearchYoutube(ctx context.Context, args args_SearchYoutube) (string, error) {
+	results, err := action_SearchYoutube(ctx, args.Query)
 	if err != nil {
 		return "", err
 	}
@@ -63,10 +50,10 @@ func handler_SearchYoutube(msgMap map[string]interface{}) (string, error) {
 	return string(data), err
 }
 
-func action_SearchYoutube(input string) (youtubeResults, error) {
+func action_SearchYoutube(ctx context.Context, input string) (youtubeResults, error) {
 	searchResults := youtubeResults{}
 
-	results, err := search(input, 5)
+	results, err := search(ctx, input, 5)
 	if err != nil {
 		return searchResults, fmt.Errorf("failed to search youtube; %w", err)
 	}
@@ -105,11 +92,11 @@ func getContent(data []byte, index int) []byte {
 
 var httpClient = &http.Client{}
 
-func search(searchTerm string, limit int) ([]*SearchResult, error) {
+func search(ctx context.Context, searchTerm string, limit int) ([]*SearchResult, error) {
 	results := []*SearchResult{}
 	url := fmt.Sprintf("https://www.youtube.com/results?search_query=%s", url.QueryEscape(searchTerm))
 
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to build GET request; %w", err)
 	}
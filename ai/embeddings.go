@@ -0,0 +1,72 @@
+package ai
+
+import (
+	"aika/usage"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// inputs sent per embedding request
+const embeddingBatchSize = 64
+
+// Embed converts each text to a vector & records the usage.
+// Vectors are returned in the same order as the texts.
+func Embed(ctx context.Context, provider Provider, model string, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, 0, len(texts))
+
+	for start := 0; start < len(texts); start += embeddingBatchSize {
+		batch := texts[start:min(start+embeddingBatchSize, len(texts))]
+
+		resp, err := provider.CreateEmbeddings(ctx, openai.EmbeddingRequest{
+			Input: batch,
+			Model: openai.EmbeddingModel(model),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create embeddings; %w", err)
+		}
+		if len(resp.Data) != len(batch) {
+			return nil, fmt.Errorf("expected %d embeddings but got %d", len(batch), len(resp.Data))
+		}
+
+		usage.Record(ctx, usage.Usage{
+			Kind:         usage.KindEmbedding,
+			Model:        model,
+			PromptTokens: resp.Usage.PromptTokens,
+		})
+
+		// the API doesn't promise to keep the input order
+		ordered := make([][]float32, len(batch))
+		for _, embedding := range resp.Data {
+			if embedding.Index < 0 || embedding.Index >= len(batch) {
+				return nil, errors.New("embedding index out of range")
+			}
+			ordered[embedding.Index] = embedding.Embedding
+		}
+		vectors = append(vectors, ordered...)
+	}
+
+	return vectors, nil
+}
+
+// Similarity is the cosine similarity of two vectors (-1 to 1).
+// Vectors of different lengths (from different models) aren't similar at all.
+func Similarity(a []float32, b []float32) float32 {
+	if len(a) != len(b) || len(a) == 0 {
+		return -1
+	}
+
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return float32(dot / (math.Sqrt(normA) * math.Sqrt(normB)))
+}
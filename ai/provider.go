@@ -0,0 +1,85 @@
+package ai
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// Provider is an LLM backend.
+// Requests and responses use the OpenAI wire format since
+// nearly every LLM server speaks it (Ollama, vLLM, LM Studio...).
+type Provider interface {
+	// CreateChatCompletion sends a chat request and waits for the full response.
+	// Vision requests are chat requests with image parts.
+	CreateChatCompletion(ctx context.Context, request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error)
+	// CreateChatCompletionStream sends a chat request and streams the response.
+	CreateChatCompletionStream(ctx context.Context, request openai.ChatCompletionRequest) (ChatStream, error)
+	// CreateTranscription converts an audio file to text.
+	CreateTranscription(ctx context.Context, request openai.AudioRequest) (openai.AudioResponse, error)
+	// CreateEmbeddings converts text to vectors for semantic search.
+	CreateEmbeddings(ctx context.Context, request openai.EmbeddingRequest) (openai.EmbeddingResponse, error)
+}
+
+// ChatStream is a streamed chat response.
+// Recv returns io.EOF once the response is complete.
+type ChatStream interface {
+	Recv() (openai.ChatCompletionStreamResponse, error)
+	Close() error
+}
+
+// OpenAICompatible is a Provider for any API which implements the OpenAI spec.
+type OpenAICompatible struct {
+	Client *openai.Client
+}
+
+var _ Provider = &OpenAICompatible{}
+
+// NewOpenAICompatible creates a provider for the API at baseURL.
+// An empty baseURL uses https://api.openai.com/v1
+func NewOpenAICompatible(baseURL string, apiKey string) *OpenAICompatible {
+	config := openai.DefaultConfig(apiKey)
+	if baseURL != "" {
+		config.BaseURL = baseURL
+	}
+	// lets retries honor Retry-After
+	config.HTTPClient = &http.Client{
+		Transport: &retryAfterTransport{base: http.DefaultTransport},
+	}
+	return &OpenAICompatible{
+		Client: openai.NewClientWithConfig(config),
+	}
+}
+
+func (p *OpenAICompatible) CreateChatCompletion(
+	ctx context.Context,
+	request openai.ChatCompletionRequest,
+) (openai.ChatCompletionResponse, error) {
+	return p.Client.CreateChatCompletion(ctx, request)
+}
+
+func (p *OpenAICompatible) CreateChatCompletionStream(
+	ctx context.Context,
+	request openai.ChatCompletionRequest,
+) (ChatStream, error) {
+	stream, err := p.Client.CreateChatCompletionStream(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+func (p *OpenAICompatible) CreateTranscription(
+	ctx context.Context,
+	request openai.AudioRequest,
+) (openai.AudioResponse, error) {
+	return p.Client.CreateTranscription(ctx, request)
+}
+
+func (p *OpenAICompatible) CreateEmbeddings(
+	ctx context.Context,
+	request openai.EmbeddingRequest,
+) (openai.EmbeddingResponse, error) {
+	return p.Client.CreateEmbeddings(ctx, request)
+}
@@ -1,13 +1,18 @@
 package ai
 
 import (
+	"aika/usage"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 
 	"github.com/sashabaranov/go-openai"
 )
 
+// returned when the provider answers without any choices
+var ErrNoChoices = errors.New("provider returned no choices")
+
 type LanguageModel string
 type VisionModel string
 
@@ -23,78 +28,185 @@ const (
 	VisionModel_GPT4  VisionModel = "gpt-4-vision-preview"
 )
 
+// Models are the model names used for each job.
+// These are configurable so any provider's models can be used.
+type Models struct {
+	Default       LanguageModel // text chats
+	Premium       LanguageModel // text chats for subscribers & admins
+	Voice         LanguageModel // voice chats - should be fast
+	Summary       LanguageModel // history summaries - should be cheap
+	Vision        VisionModel   // image inspection
+	Transcription string        // speech to text
+	Embedding     string        // semantic search
+
+	// chat models to try, in order, when a chat model keeps failing
+	Fallbacks []LanguageModel
+}
+
+// DefaultModels are the OpenAI models aika has always used
+func DefaultModels() Models {
+	return Models{
+		Default:       LanguageModel_GPT35,
+		Premium:       LanguageModel_GPT4o,
+		Voice:         LanguageModel_GPT4o,
+		Summary:       LanguageModel_GPT35,
+		Vision:        VisionModel_GPT4o,
+		Transcription: openai.Whisper1,
+		Embedding:     string(openai.SmallEmbedding3),
+	}
+}
+
 type ChatRequest struct {
-	Client *openai.Client
+	Provider Provider
 
 	System  openai.ChatCompletionMessage   // ai brain
+	Context []openai.ChatCompletionMessage // ai brain - extra system context sent after System
 	History []openai.ChatCompletionMessage // chat
 	Message openai.ChatCompletionMessage   // requst
 
-	Functions []openai.FunctionDefinition // chat - all definitions exist in AIBrain
+	Tools []openai.Tool // chat - all definitions exist in AIBrain
+	// forbid tool calls - tools are still sent so tool history stays valid
+	NoToolCalls bool
 
 	Model LanguageModel // chat
+	// models to try, in order, if Model keeps failing
+	Fallbacks []LanguageModel
 }
 
-// Send a request to OpenAI and return the response
-func (request *ChatRequest) Send(ctx context.Context) (openai.ChatCompletionMessage, error) {
+// tool_choice for this request
+func (request *ChatRequest) toolChoice() any {
+	if request.NoToolCalls && len(request.Tools) > 0 {
+		return "none"
+	}
+	return nil
+}
 
+// build the full message list for this request
+func (request *ChatRequest) messages() []openai.ChatCompletionMessage {
 	messages := []openai.ChatCompletionMessage{request.System}
+	messages = append(messages, request.Context...)
 	messages = append(messages, request.History...)
 	messages = append(messages, request.Message)
+	return messages
+}
+
+// Send a request to the provider and return the response
+// failed requests are retried, then sent to each fallback model
+func (request *ChatRequest) Send(ctx context.Context) (openai.ChatCompletionMessage, error) {
+	var message openai.ChatCompletionMessage
+	err := request.withFallbacks(ctx, func(ctx context.Context, model LanguageModel) error {
+		var err error
+		message, err = request.send(ctx, model)
+		return err
+	})
+	return message, err
+}
 
-	resp, err := request.Client.CreateChatCompletion(
+func (request *ChatRequest) send(ctx context.Context, model LanguageModel) (openai.ChatCompletionMessage, error) {
+
+	messages := request.messages()
+
+	resp, err := request.Provider.CreateChatCompletion(
 		ctx,
 		openai.ChatCompletionRequest{
-			Model:     string(request.Model),
-			Messages:  messages,
-			Functions: request.Functions,
+			Model:      string(model),
+			Messages:   messages,
+			Tools:      request.Tools,
+			ToolChoice: request.toolChoice(),
 		},
 	)
 	if err != nil {
-		return openai.ChatCompletionMessage{}, fmt.Errorf("failed to query openai; %w", err)
+		return openai.ChatCompletionMessage{}, fmt.Errorf("failed to query provider; %w", err)
+	}
+	if len(resp.Choices) == 0 {
+		return openai.ChatCompletionMessage{}, ErrNoChoices
 	}
 
+	request.recordUsage(ctx, model, resp.Usage, resp.Choices[0].Message)
 	return resp.Choices[0].Message, nil
 }
 
+// Stream a request to the provider
+// content is written to the writer as it arrives
+// failed requests are retried like Send, but only until content has been
+// written - retrying after that would repeat text the user already has
 func (request *ChatRequest) Stream(ctx context.Context, writer io.Writer) (openai.ChatCompletionMessage, error) {
-	messages := []openai.ChatCompletionMessage{request.System}
-	messages = append(messages, request.History...)
-	messages = append(messages, request.Message)
+	tracked := &trackingWriter{Writer: writer}
+
+	var message openai.ChatCompletionMessage
+	err := request.withFallbacks(ctx, func(ctx context.Context, model LanguageModel) error {
+		var err error
+		message, err = request.stream(ctx, model, tracked)
+		if err != nil && tracked.written {
+			return &permanentError{err}
+		}
+		return err
+	})
+	return message, err
+}
+
+func (request *ChatRequest) stream(ctx context.Context, model LanguageModel, writer io.Writer) (openai.ChatCompletionMessage, error) {
+	messages := request.messages()
 
-	stream, err := request.Client.CreateChatCompletionStream(
+	stream, err := request.Provider.CreateChatCompletionStream(
 		ctx,
 		openai.ChatCompletionRequest{
-			Model:     string(request.Model),
-			Messages:  messages,
-			Functions: request.Functions,
+			Model:      string(model),
+			Messages:   messages,
+			Tools:      request.Tools,
+			ToolChoice: request.toolChoice(),
+			// the last chunk reports the tokens used
+			StreamOptions: &openai.StreamOptions{IncludeUsage: true},
 		},
 	)
 	if err != nil {
-		return openai.ChatCompletionMessage{}, fmt.Errorf("failed to query openai; %w", err)
+		return openai.ChatCompletionMessage{}, fmt.Errorf("failed to query provider; %w", err)
 	}
+	defer stream.Close()
 
 	var message openai.ChatCompletionMessage
+	// providers without stream usage leave this empty & it's estimated
+	var reported openai.Usage
 
 	for {
 		chunk, err := stream.Recv()
 		if err == io.EOF {
+			request.recordUsage(ctx, model, reported, message)
 			return message, nil
 		}
 		if err != nil {
-			return message, fmt.Errorf("failed receiving openai chunks; %w", err)
+			return message, fmt.Errorf("failed receiving provider chunks; %w", err)
+		}
+		if chunk.Usage != nil {
+			reported = *chunk.Usage
+		}
+		// the usage chunk has no choices
+		if len(chunk.Choices) == 0 {
+			continue
 		}
 
 		delta := chunk.Choices[0].Delta
 
-		if delta.FunctionCall != nil {
-			// in case it's sent in chunks we'll do it this way :)
-			if message.FunctionCall != nil {
-				message.FunctionCall.Arguments += delta.FunctionCall.Arguments
-				message.FunctionCall.Name += delta.FunctionCall.Name
-			} else {
-				message.FunctionCall = delta.FunctionCall
+		// tool calls are streamed in fragments keyed by index
+		// the first fragment carries the ID & name, the rest carry arguments
+		for _, call := range delta.ToolCalls {
+			index := len(message.ToolCalls)
+			if call.Index != nil {
+				index = *call.Index
+			}
+			for len(message.ToolCalls) <= index {
+				message.ToolCalls = append(message.ToolCalls, openai.ToolCall{})
+			}
+
+			existing := &message.ToolCalls[index]
+			if call.ID != "" {
+				existing.ID = call.ID
+			}
+			if call.Type != "" {
+				existing.Type = call.Type
 			}
+			existing.Function.Name += call.Function.Name
+			existing.Function.Arguments += call.Function.Arguments
 		}
 		// chunked role ?
 		if delta.Role != "" {
@@ -111,6 +223,41 @@ func (request *ChatRequest) Stream(ctx context.Context, writer io.Writer) (opena
 	}
 }
 
+// record the tokens used by a successful request
+// tokens are estimated when the provider doesn't report them
+func (request *ChatRequest) recordUsage(
+	ctx context.Context,
+	model LanguageModel,
+	reported openai.Usage,
+	response openai.ChatCompletionMessage,
+) {
+	record := usage.Usage{
+		Kind:             usage.KindChat,
+		Model:            string(model),
+		PromptTokens:     reported.PromptTokens,
+		CompletionTokens: reported.CompletionTokens,
+	}
+	if reported.TotalTokens == 0 {
+		record.PromptTokens = request.Tokens()
+		record.CompletionTokens = CountMessageTokens(response)
+		record.Estimated = true
+	}
+	usage.Record(ctx, record)
+}
+
+// trackingWriter remembers if anything was written
+type trackingWriter struct {
+	io.Writer
+	written bool
+}
+
+func (w *trackingWriter) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		w.written = true
+	}
+	return w.Writer.Write(p)
+}
+
 // since vision does not support functions, we needed a unique request for hitting the VISION model and extracting a description of the provided image
 // we can use something like "actions" to let the AI grab the description of an attached image or we can automatically attach an image description to
 // the provided AI request like:
@@ -125,7 +272,7 @@ func (request *ChatRequest) Stream(ctx context.Context, writer io.Writer) (opena
 // and can ask for more details of that image like 'what is written on the paper?' ect.
 
 type VisionRequest struct {
-	Client *openai.Client
+	Provider Provider
 
 	System openai.ChatCompletionMessage // vision brain
 
@@ -158,18 +305,33 @@ func (request *VisionRequest) Send(ctx context.Context) (openai.ChatCompletionMe
 		},
 	}
 
-	resp, err := request.Client.CreateChatCompletion(
-		ctx,
-		openai.ChatCompletionRequest{
-			MaxTokens: 1024,
-			Model:     string(request.Model),
-			Messages:  messages,
-		},
-	)
+	var resp openai.ChatCompletionResponse
+	err := withRetries(ctx, func(ctx context.Context) error {
+		var err error
+		resp, err = request.Provider.CreateChatCompletion(
+			ctx,
+			openai.ChatCompletionRequest{
+				MaxTokens: 1024,
+				Model:     string(request.Model),
+				Messages:  messages,
+			},
+		)
+		return err
+	})
 	if err != nil {
-		return openai.ChatCompletionMessage{}, fmt.Errorf("failed to query openai; %w", err)
+		return openai.ChatCompletionMessage{}, fmt.Errorf("failed to query provider; %w", err)
 
 	}
+	if len(resp.Choices) == 0 {
+		return openai.ChatCompletionMessage{}, ErrNoChoices
+	}
+
+	usage.Record(ctx, usage.Usage{
+		Kind:             usage.KindChat,
+		Model:            string(request.Model),
+		PromptTokens:     resp.Usage.PromptTokens,
+		CompletionTokens: resp.Usage.CompletionTokens,
+	})
 
 	return resp.Choices[0].Message, nil
 }
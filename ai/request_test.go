@@ -0,0 +1,152 @@
+package ai
+
+import (
+	"aika/usage"
+	"bytes"
+	"context"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// provider which answers with a fixed response or stream
+type scriptedProvider struct {
+	Provider
+	response openai.ChatCompletionResponse
+	chunks   []openai.ChatCompletionStreamResponse
+}
+
+func (p *scriptedProvider) CreateChatCompletion(
+	_ context.Context,
+	_ openai.ChatCompletionRequest,
+) (openai.ChatCompletionResponse, error) {
+	return p.response, nil
+}
+
+func (p *scriptedProvider) CreateChatCompletionStream(
+	_ context.Context,
+	_ openai.ChatCompletionRequest,
+) (ChatStream, error) {
+	return &scriptedStream{chunks: p.chunks}, nil
+}
+
+type scriptedStream struct {
+	chunks []openai.ChatCompletionStreamResponse
+}
+
+func (s *scriptedStream) Recv() (openai.ChatCompletionStreamResponse, error) {
+	if len(s.chunks) == 0 {
+		return openai.ChatCompletionStreamResponse{}, io.EOF
+	}
+	chunk := s.chunks[0]
+	s.chunks = s.chunks[1:]
+	return chunk, nil
+}
+
+func (s *scriptedStream) Close() error { return nil }
+
+func contentChunk(content string) openai.ChatCompletionStreamResponse {
+	return openai.ChatCompletionStreamResponse{
+		Choices: []openai.ChatCompletionStreamChoice{{Delta: openai.ChatCompletionStreamChoiceDelta{Content: content}}},
+	}
+}
+
+func TestSendNoChoices(t *testing.T) {
+	req := &ChatRequest{Provider: &scriptedProvider{}, Model: LanguageModel_GPT4o}
+
+	_, err := req.Send(context.Background())
+	assert.ErrorIs(t, err, ErrNoChoices)
+}
+
+func TestStreamSkipsEmptyChunks(t *testing.T) {
+	provider := &scriptedProvider{chunks: []openai.ChatCompletionStreamResponse{
+		{},
+		contentChunk("hello"),
+		{},
+		contentChunk(" there"),
+	}}
+	req := &ChatRequest{Provider: provider, Model: LanguageModel_GPT4o}
+
+	writer := &bytes.Buffer{}
+	res, err := req.Stream(context.Background(), writer)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello there", res.Content)
+	assert.Equal(t, "hello there", writer.String())
+}
+
+func TestStreamRecordsReportedUsage(t *testing.T) {
+	ledger, err := usage.NewLedger(filepath.Join(t.TempDir(), "usage.json"))
+	require.NoError(t, err)
+	ctx := usage.WithLedger(context.Background(), ledger)
+
+	provider := &scriptedProvider{chunks: []openai.ChatCompletionStreamResponse{
+		contentChunk("hello"),
+		// the final chunk only carries usage
+		{Usage: &openai.Usage{PromptTokens: 1234, CompletionTokens: 56, TotalTokens: 1290}},
+	}}
+	req := &ChatRequest{Provider: provider, Model: LanguageModel_GPT4o}
+
+	_, err = req.Stream(ctx, io.Discard)
+	require.NoError(t, err)
+
+	report := ledger.Report(1)
+	require.Len(t, report.Models, 1)
+	assert.Equal(t, 1234, report.Models[0].PromptTokens)
+	assert.Equal(t, 56, report.Models[0].CompletionTokens)
+}
+
+func TestStreamToolCallFragments(t *testing.T) {
+	fragment := func(index int, call openai.ToolCall) openai.ChatCompletionStreamResponse {
+		call.Index = &index
+		return openai.ChatCompletionStreamResponse{
+			Choices: []openai.ChatCompletionStreamChoice{{Delta: openai.ChatCompletionStreamChoiceDelta{
+				ToolCalls: []openai.ToolCall{call},
+			}}},
+		}
+	}
+	start := func(index int, id string, name string) openai.ChatCompletionStreamResponse {
+		return fragment(index, openai.ToolCall{ID: id, Type: openai.ToolTypeFunction, Function: openai.FunctionCall{Name: name}})
+	}
+	args := func(index int, args string) openai.ChatCompletionStreamResponse {
+		return fragment(index, openai.ToolCall{Function: openai.FunctionCall{Arguments: args}})
+	}
+
+	// fragments of 3 calls arrive interleaved
+	provider := &scriptedProvider{chunks: []openai.ChatCompletionStreamResponse{
+		{Choices: []openai.ChatCompletionStreamChoice{{Delta: openai.ChatCompletionStreamChoiceDelta{Role: openai.ChatMessageRoleAssistant}}}},
+		start(0, "call_a", "SearchWeb"),
+		start(1, "call_b", "Search"),
+		args(0, `{"query":`),
+		start(2, "call_c", "GetAnime"),
+		// names can be split too
+		fragment(1, openai.ToolCall{Function: openai.FunctionCall{Name: "Youtube", Arguments: `{"query": "cats"}`}}),
+		args(2, `{"id"`),
+		args(0, ` "dogs"}`),
+		args(2, `: 5}`),
+		{},
+	}}
+	req := &ChatRequest{Provider: provider, Model: LanguageModel_GPT4o}
+
+	writer := &bytes.Buffer{}
+	res, err := req.Stream(context.Background(), writer)
+	require.NoError(t, err)
+	assert.Empty(t, writer.String())
+	assert.Equal(t, openai.ChatMessageRoleAssistant, res.Role)
+
+	require.Len(t, res.ToolCalls, 3)
+	expected := []struct{ id, name, args string }{
+		{"call_a", "SearchWeb", `{"query": "dogs"}`},
+		{"call_b", "SearchYoutube", `{"query": "cats"}`},
+		{"call_c", "GetAnime", `{"id": 5}`},
+	}
+	for i, want := range expected {
+		assert.Equal(t, want.id, res.ToolCalls[i].ID)
+		assert.Equal(t, openai.ToolTypeFunction, res.ToolCalls[i].Type)
+		assert.Equal(t, want.name, res.ToolCalls[i].Function.Name)
+		assert.Equal(t, want.args, res.ToolCalls[i].Function.Arguments)
+	}
+}
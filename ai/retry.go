@@ -0,0 +1,197 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// attempts per model before falling back to the next one
+	retryAttempts = 3
+	// servers asking us to wait longer than this get skipped for the next model
+	maxRetryAfter = 30 * time.Second
+)
+
+// vars so tests don't have to wait
+var (
+	retryBaseDelay = 500 * time.Millisecond
+	retryMaxDelay  = 8 * time.Second
+)
+
+// ErrAllModelsFailed is returned when every model in the fallback chain failed
+var ErrAllModelsFailed = errors.New("all models failed")
+
+// models to try for the request, in order
+func (request *ChatRequest) models() []LanguageModel {
+	models := []LanguageModel{request.Model}
+	for _, fallback := range request.Fallbacks {
+		duplicate := false
+		for _, model := range models {
+			duplicate = duplicate || model == fallback
+		}
+		if !duplicate {
+			models = append(models, fallback)
+		}
+	}
+	return models
+}
+
+// call fn with each model until one succeeds
+// each model is retried with backoff before moving to the next
+func (request *ChatRequest) withFallbacks(
+	ctx context.Context,
+	fn func(ctx context.Context, model LanguageModel) error,
+) error {
+	var err error
+	for _, model := range request.models() {
+		err = withRetries(ctx, func(ctx context.Context) error {
+			return fn(ctx, model)
+		})
+		if err == nil {
+			return nil
+		}
+
+		var permanent *permanentError
+		if ctx.Err() != nil || errors.As(err, &permanent) {
+			return err
+		}
+
+		logrus.WithField("model", model).WithError(err).Warnln("model failed, trying fallback")
+	}
+
+	return fmt.Errorf("%w; %w", ErrAllModelsFailed, err)
+}
+
+// call fn until it succeeds, fails with an error retrying won't fix, or runs out of attempts
+func withRetries(ctx context.Context, fn func(ctx context.Context) error) error {
+	var err error
+	for attempt := 0; attempt < retryAttempts; attempt++ {
+		hint := &retryHint{}
+		err = fn(context.WithValue(ctx, retryHintKey{}, hint))
+		if err == nil || !retryable(err) {
+			return err
+		}
+		if attempt == retryAttempts-1 {
+			break
+		}
+
+		delay := backoff(attempt)
+		if hint.after > maxRetryAfter {
+			return err
+		}
+		if hint.after > 0 {
+			delay = hint.after
+		}
+
+		logrus.WithError(err).WithField("delay", delay).Debugln("retrying provider request")
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+	return err
+}
+
+// jittered exponential backoff
+func backoff(attempt int) time.Duration {
+	delay := retryBaseDelay << attempt
+	if delay > retryMaxDelay || delay <= 0 {
+		delay = retryMaxDelay
+	}
+	// full jitter so many chats don't retry in lockstep
+	return time.Duration(rand.Int63n(int64(delay)) + 1)
+}
+
+// rate limits, server errors & dropped connections are worth retrying
+func retryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var permanent *permanentError
+	if errors.As(err, &permanent) {
+		return false
+	}
+
+	status := 0
+	var apiErr *openai.APIError
+	var reqErr *openai.RequestError
+	if errors.As(err, &apiErr) {
+		status = apiErr.HTTPStatusCode
+	} else if errors.As(err, &reqErr) {
+		status = reqErr.HTTPStatusCode
+	}
+	if status != 0 {
+		return status == http.StatusTooManyRequests ||
+			status == http.StatusRequestTimeout ||
+			status >= http.StatusInternalServerError
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// permanentError is never retried or sent to a fallback model
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// --- Retry-After
+
+// the provider's http client stores the server's Retry-After in the request context
+type retryHintKey struct{}
+
+type retryHint struct {
+	after time.Duration
+}
+
+// retryAfterTransport records Retry-After headers so retries wait as long as the server asks
+type retryAfterTransport struct {
+	base http.RoundTripper
+}
+
+func (t *retryAfterTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	hint, ok := req.Context().Value(retryHintKey{}).(*retryHint)
+	if ok {
+		hint.after = parseRetryAfter(resp.Header)
+	}
+	return resp, nil
+}
+
+func parseRetryAfter(header http.Header) time.Duration {
+	// openai sends milliseconds too
+	if ms, err := strconv.ParseFloat(header.Get("Retry-After-Ms"), 64); err == nil && ms > 0 {
+		return time.Duration(ms * float64(time.Millisecond))
+	}
+
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+	return 0
+}
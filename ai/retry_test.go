@@ -0,0 +1,145 @@
+package ai
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+// provider which fails with the queued errors before answering
+type flakyProvider struct {
+	Provider
+	errors map[string][]error
+	models []string
+}
+
+func (p *flakyProvider) next(model string) error {
+	p.models = append(p.models, model)
+	if len(p.errors[model]) == 0 {
+		return nil
+	}
+	err := p.errors[model][0]
+	p.errors[model] = p.errors[model][1:]
+	return err
+}
+
+func (p *flakyProvider) CreateChatCompletion(
+	_ context.Context,
+	request openai.ChatCompletionRequest,
+) (openai.ChatCompletionResponse, error) {
+	if err := p.next(request.Model); err != nil {
+		return openai.ChatCompletionResponse{}, err
+	}
+	return openai.ChatCompletionResponse{
+		Choices: []openai.ChatCompletionChoice{{Message: openai.ChatCompletionMessage{Content: request.Model}}},
+	}, nil
+}
+
+func (p *flakyProvider) CreateChatCompletionStream(
+	_ context.Context,
+	request openai.ChatCompletionRequest,
+) (ChatStream, error) {
+	if err := p.next(request.Model); err != nil {
+		return nil, err
+	}
+	// "hello" then the connection drops
+	return &brokenStream{chunks: []string{"hello"}}, nil
+}
+
+type brokenStream struct {
+	chunks []string
+}
+
+func (s *brokenStream) Recv() (openai.ChatCompletionStreamResponse, error) {
+	if len(s.chunks) == 0 {
+		return openai.ChatCompletionStreamResponse{}, io.ErrUnexpectedEOF
+	}
+	chunk := s.chunks[0]
+	s.chunks = s.chunks[1:]
+	return openai.ChatCompletionStreamResponse{
+		Choices: []openai.ChatCompletionStreamChoice{{Delta: openai.ChatCompletionStreamChoiceDelta{Content: chunk}}},
+	}, nil
+}
+
+func (s *brokenStream) Close() error { return nil }
+
+var (
+	errRateLimited = &openai.APIError{HTTPStatusCode: http.StatusTooManyRequests, Message: "slow down"}
+	errBadRequest  = &openai.APIError{HTTPStatusCode: http.StatusBadRequest, Message: "bad request"}
+)
+
+func init() {
+	retryBaseDelay = time.Millisecond
+	retryMaxDelay = time.Millisecond
+}
+
+func TestSendRetries(t *testing.T) {
+	provider := &flakyProvider{errors: map[string][]error{
+		"gpt-4o": {errRateLimited, errRateLimited},
+	}}
+	req := &ChatRequest{Provider: provider, Model: LanguageModel_GPT4o}
+
+	res, err := req.Send(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "gpt-4o", res.Content)
+	assert.Len(t, provider.models, 3)
+}
+
+func TestSendFallsBack(t *testing.T) {
+	provider := &flakyProvider{errors: map[string][]error{
+		"gpt-4o": {errRateLimited, errRateLimited, errRateLimited},
+	}}
+	req := &ChatRequest{
+		Provider:  provider,
+		Model:     LanguageModel_GPT4o,
+		Fallbacks: []LanguageModel{LanguageModel_GPT4o, LanguageModel_GPT35},
+	}
+
+	res, err := req.Send(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "gpt-3.5-turbo", res.Content)
+	assert.Equal(t, []string{"gpt-4o", "gpt-4o", "gpt-4o", "gpt-3.5-turbo"}, provider.models)
+
+	// bad requests aren't retried, but other models may still work
+	provider = &flakyProvider{errors: map[string][]error{
+		"gpt-4o":        {errBadRequest},
+		"gpt-3.5-turbo": {errBadRequest},
+	}}
+	req.Provider = provider
+	_, err = req.Send(context.Background())
+	assert.ErrorIs(t, err, ErrAllModelsFailed)
+	assert.Equal(t, []string{"gpt-4o", "gpt-3.5-turbo"}, provider.models)
+}
+
+func TestStreamNeverRepeatsContent(t *testing.T) {
+	provider := &flakyProvider{errors: map[string][]error{}}
+	req := &ChatRequest{
+		Provider:  provider,
+		Model:     LanguageModel_GPT4o,
+		Fallbacks: []LanguageModel{LanguageModel_GPT35},
+	}
+
+	writer := &bytes.Buffer{}
+	_, err := req.Stream(context.Background(), writer)
+	assert.True(t, errors.Is(err, io.ErrUnexpectedEOF))
+	assert.Equal(t, "hello", writer.String())
+	assert.Len(t, provider.models, 1)
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	header := http.Header{}
+	header.Set("Retry-After", "3")
+	assert.Equal(t, 3*time.Second, parseRetryAfter(header))
+
+	header.Set("Retry-After-Ms", "250")
+	assert.Equal(t, 250*time.Millisecond, parseRetryAfter(header))
+
+	assert.Zero(t, parseRetryAfter(http.Header{}))
+}
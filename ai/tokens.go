@@ -0,0 +1,130 @@
+package ai
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+const (
+	// rough average for english text on the cl100k/o200k tokenizers
+	charsPerToken = 4
+	// every message is wrapped in <|start|>{role/name}\n{content}<|end|>
+	tokensPerMessage = 4
+	// every reply is primed with <|start|>assistant<|message|>
+	tokensPerReply = 3
+	// tokens held back from the context window for the reply
+	responseReserve = 4096
+	// context window used for models we know nothing about
+	defaultContextWindow = 8192
+)
+
+var (
+	contextWindows      = map[LanguageModel]int{}
+	contextWindowsMutex sync.RWMutex
+)
+
+// SetContextWindow configures the context window for a model.
+// Needed for models aika doesn't know about (local models, new releases...)
+func SetContextWindow(model LanguageModel, tokens int) {
+	contextWindowsMutex.Lock()
+	defer contextWindowsMutex.Unlock()
+
+	contextWindows[model] = tokens
+}
+
+// ContextWindow is the total number of tokens the model
+// can handle in a single request (prompt + response).
+func (model LanguageModel) ContextWindow() int {
+	contextWindowsMutex.RLock()
+	tokens, ok := contextWindows[model]
+	contextWindowsMutex.RUnlock()
+	if ok {
+		return tokens
+	}
+
+	switch model {
+	case LanguageModel_GPT35:
+		return 16385
+	case LanguageModel_GPT4T, LanguageModel_GPT4o:
+		return 128000
+	default:
+		return defaultContextWindow
+	}
+}
+
+// ContextBudget is the number of tokens a prompt may use
+// while leaving room for the model to respond.
+func (model LanguageModel) ContextBudget() int {
+	budget := model.ContextWindow() - responseReserve
+	if budget < model.ContextWindow()/2 {
+		budget = model.ContextWindow() / 2
+	}
+	return budget
+}
+
+// CountTokens estimates the number of tokens in a string.
+// This is an approximation; we have no tokenizer available,
+// so it errs on the side of over-counting.
+func CountTokens(text string) int {
+	if text == "" {
+		return 0
+	}
+	return (len(text) + charsPerToken - 1) / charsPerToken
+}
+
+// CountMessageTokens estimates the tokens a single chat message costs.
+func CountMessageTokens(message openai.ChatCompletionMessage) int {
+	tokens := tokensPerMessage
+	tokens += CountTokens(message.Role)
+	tokens += CountTokens(message.Name)
+	tokens += CountTokens(message.Content)
+	for _, part := range message.MultiContent {
+		tokens += CountTokens(part.Text)
+		if part.ImageURL != nil {
+			// low detail images are a flat 85 tokens - high detail costs more
+			// but we never send those in chat requests
+			tokens += 85
+		}
+	}
+	for _, call := range message.ToolCalls {
+		tokens += CountTokens(call.ID)
+		tokens += CountTokens(call.Function.Name)
+		tokens += CountTokens(call.Function.Arguments)
+	}
+	return tokens
+}
+
+// CountMessagesTokens estimates the tokens a list of chat messages costs.
+func CountMessagesTokens(messages []openai.ChatCompletionMessage) int {
+	tokens := 0
+	for _, message := range messages {
+		tokens += CountMessageTokens(message)
+	}
+	return tokens
+}
+
+// CountToolTokens estimates the tokens the tool definitions cost.
+// OpenAI injects these into the system prompt, so the JSON size is a fair guess.
+func CountToolTokens(tools []openai.Tool) int {
+	if len(tools) == 0 {
+		return 0
+	}
+	data, err := json.Marshal(tools)
+	if err != nil {
+		return 0
+	}
+	return CountTokens(string(data))
+}
+
+// Tokens estimates the number of prompt tokens this request will use.
+func (request *ChatRequest) Tokens() int {
+	tokens := tokensPerReply
+	tokens += CountMessageTokens(request.System)
+	tokens += CountMessagesTokens(request.Context)
+	tokens += CountMessagesTokens(request.History)
+	tokens += CountMessageTokens(request.Message)
+	tokens += CountToolTokens(request.Tools)
+	return tokens
+}
@@ -4,21 +4,33 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"slices"
+	"strings"
 	"sync"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/sashabaranov/go-openai"
 	"github.com/sirupsen/logrus"
 
+	"aika/ai"
 	"aika/discord/discordai"
 	"aika/discord/discordchat"
+	"aika/moderation"
 	"aika/storage"
+	"aika/usage"
 )
 
 var (
 	ErrInvalidHistoryConfiguration       = errors.New("invalid history configuration value")
 	ErrInvalidCharacterConfiguration     = errors.New("invalid character configuration value")
 	ErrInvalidTranscriptionConfiguration = errors.New("invalid transcription_prompt configuration value")
+	ErrInvalidModelsConfiguration        = errors.New("invalid models configuration value")
+	ErrInvalidContextConfiguration       = errors.New("invalid context_windows configuration value")
+	ErrInvalidPricesConfiguration        = errors.New("invalid prices configuration value")
+	ErrInvalidQuotasConfiguration        = errors.New("invalid quotas configuration value")
+	ErrInvalidLorebookConfiguration      = errors.New("invalid lorebook_budget configuration value")
+	ErrInvalidModerationConfiguration    = errors.New("invalid moderation configuration value")
+	ErrInvalidThreadsConfiguration       = errors.New("invalid threads configuration value")
 )
 
 type ChatBot struct {
@@ -27,6 +39,10 @@ type ChatBot struct {
 	Brain       *discordai.AIBrain
 	GuildChats  map[string]*discordchat.Guild
 	DirectChats map[string]*discordchat.Direct
+	// discordgo runs each handler in its own goroutine
+	chatsMutex sync.Mutex
+	// IDs of threads in GuildChats
+	threadChats map[string]bool
 
 	S3  *storage.S3
 	Cfg *storage.Disk
@@ -36,8 +52,15 @@ func StartChatbot(
 	ctx context.Context,
 	apiKey string,
 	client *openai.Client,
+	provider ai.Provider,
 	s3 *storage.S3,
 	cfg *storage.Disk,
+	memory *discordai.Memory,
+	personas *discordai.Personas,
+	lorebook *discordai.Lorebook,
+	knowledge *discordai.KnowledgeBase,
+	archive *discordai.Archive,
+	ledger *usage.Ledger,
 ) (*ChatBot, error) {
 	// create session object
 	dg, err := discordgo.New("Bot " + apiKey)
@@ -69,23 +92,96 @@ func StartChatbot(
 		return nil, ErrInvalidTranscriptionConfiguration
 	}
 
+	models, err := loadModels(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	err = loadContextWindows(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	err = loadPrices(cfg, ledger)
+	if err != nil {
+		return nil, err
+	}
+
+	// tokens of lore per request
+	if budget, exists := cfg.Get("lorebook_budget"); exists {
+		tokens, ok := budget.(int)
+		if !ok || tokens <= 0 {
+			return nil, ErrInvalidLorebookConfiguration
+		}
+		if lorebook != nil {
+			lorebook.Budget = tokens
+		}
+	}
+
+	// documents & conversations are embedded with the chat provider
+	if knowledge != nil {
+		knowledge.Provider = provider
+		knowledge.Model = models.Embedding
+	}
+	if archive != nil {
+		archive.Provider = provider
+		archive.Model = models.Embedding
+	}
+
+	err = validateQuotas(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateThreads(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	moderator, err := loadModeration(cfg, client)
+	if err != nil {
+		return nil, err
+	}
+
+	// every chat records its usage to the ledger
+	ctx = usage.WithLedger(ctx, ledger)
+
 	// create bot object
 	bot := &ChatBot{
 		Ctx:     ctx,
 		Session: dg,
 		Brain: &discordai.AIBrain{
+			Provider:            provider,
+			Models:              models,
 			OpenAI:              client,
 			HistorySize:         historyLen,
 			TranscriptionPrompt: transPrompt,
+			Summarizer: &discordai.Summarizer{
+				Provider: provider,
+				Model:    models.Summary,
+			},
+			Memory:    memory,
+			Personas:  personas,
+			Lorebook:  lorebook,
+			Knowledge: knowledge,
+			Archive:   archive,
+			Moderator: moderator,
 		},
 		GuildChats:  make(map[string]*discordchat.Guild),
 		DirectChats: make(map[string]*discordchat.Direct),
+		threadChats: make(map[string]bool),
 		S3:          s3,
 		Cfg:         cfg,
 	}
 
 	// add OnMessage handler
 	dg.AddHandler(bot.onMessage)
+	// slash commands
+	dg.AddHandler(bot.onReady)
+	dg.AddHandler(bot.onInteraction)
+	// thread chats are dropped with their threads
+	dg.AddHandler(bot.onThreadUpdate)
+	dg.AddHandler(bot.onThreadDelete)
 
 	// intents & enable state tracking
 	dg.Identify.Intents = discordgo.IntentsAll
@@ -110,6 +206,252 @@ func StartChatbot(
 	return bot, nil
 }
 
+// read model names from config
+// any missing model falls back to the default OpenAI models
+func loadModels(cfg *storage.Disk) (ai.Models, error) {
+	models := ai.DefaultModels()
+
+	if _, exists := cfg.Get("models"); !exists {
+		return models, nil
+	}
+	names, ok := cfg.GetMap("models")
+	if !ok {
+		return models, ErrInvalidModelsConfiguration
+	}
+
+	for key, value := range names {
+		if key == "fallbacks" {
+			fallbacks, ok := value.([]interface{})
+			if !ok {
+				return models, ErrInvalidModelsConfiguration
+			}
+			for _, v := range fallbacks {
+				name, ok := v.(string)
+				if !ok || name == "" {
+					return models, ErrInvalidModelsConfiguration
+				}
+				models.Fallbacks = append(models.Fallbacks, ai.LanguageModel(name))
+			}
+			continue
+		}
+
+		name, ok := value.(string)
+		if !ok || name == "" {
+			return models, ErrInvalidModelsConfiguration
+		}
+		switch key {
+		case "default":
+			models.Default = ai.LanguageModel(name)
+		case "premium":
+			models.Premium = ai.LanguageModel(name)
+		case "voice":
+			models.Voice = ai.LanguageModel(name)
+		case "summary":
+			models.Summary = ai.LanguageModel(name)
+		case "vision":
+			models.Vision = ai.VisionModel(name)
+		case "transcription":
+			models.Transcription = name
+		case "embedding":
+			models.Embedding = name
+		default:
+			logrus.WithField("key", key).Warnln("unknown model in config.yaml")
+		}
+	}
+
+	return models, nil
+}
+
+// read context window sizes for models aika doesn't know about
+func loadContextWindows(cfg *storage.Disk) error {
+	if _, exists := cfg.Get("context_windows"); !exists {
+		return nil
+	}
+	windows, ok := cfg.GetMap("context_windows")
+	if !ok {
+		return ErrInvalidContextConfiguration
+	}
+
+	for model, value := range windows {
+		tokens, ok := value.(int)
+		if !ok || tokens <= 0 {
+			return ErrInvalidContextConfiguration
+		}
+		ai.SetContextWindow(ai.LanguageModel(model), tokens)
+	}
+
+	return nil
+}
+
+// read prices for models aika doesn't know about
+// or which aren't billed at list price
+func loadPrices(cfg *storage.Disk, ledger *usage.Ledger) error {
+	if _, exists := cfg.Get("prices"); !exists {
+		return nil
+	}
+	prices, ok := cfg.GetMap("prices")
+	if !ok {
+		return ErrInvalidPricesConfiguration
+	}
+
+	for model, value := range prices {
+		fields, ok := storage.StringMap(value)
+		if !ok {
+			return ErrInvalidPricesConfiguration
+		}
+
+		price := usage.Price{}
+		for key, value := range fields {
+			var amount float64
+			switch v := value.(type) {
+			case int:
+				amount = float64(v)
+			case float64:
+				amount = v
+			default:
+				return ErrInvalidPricesConfiguration
+			}
+
+			switch key {
+			case "prompt":
+				price.Prompt = amount
+			case "completion":
+				price.Completion = amount
+			case "image":
+				price.Image = amount
+			case "minute":
+				price.Minute = amount
+			case "characters":
+				price.Characters = amount
+			default:
+				return ErrInvalidPricesConfiguration
+			}
+		}
+		ledger.SetPrice(model, price)
+	}
+
+	return nil
+}
+
+// quotas are read per message so they can be changed while running
+// check them now so a typo doesn't silently leave a guild unlimited
+func validateQuotas(cfg *storage.Disk) error {
+	validate := func(data interface{}) error {
+		quotas, ok := storage.StringMap(data)
+		if !ok {
+			return ErrInvalidQuotasConfiguration
+		}
+		for name, value := range quotas {
+			if name != "guild" && name != "user" {
+				return fmt.Errorf("unknown quota '%s'; %w", name, ErrInvalidQuotasConfiguration)
+			}
+			if _, err := usage.ParseQuota(value); err != nil {
+				return fmt.Errorf("%s quota; %w", name, err)
+			}
+		}
+		return nil
+	}
+
+	if data, exists := cfg.Get("quotas"); exists {
+		if err := validate(data); err != nil {
+			return err
+		}
+	}
+
+	guilds, _ := cfg.GetMap("guilds")
+	for id, guild := range guilds {
+		config, _ := storage.StringMap(guild)
+		if data, exists := config["quotas"]; exists {
+			if err := validate(data); err != nil {
+				return fmt.Errorf("guild %s; %w", id, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// threads are read per message - check them now
+// so a bad archive duration doesn't fail every thread
+func validateThreads(cfg *storage.Disk) error {
+	validate := func(data interface{}) error {
+		config, ok := storage.StringMap(data)
+		if !ok {
+			return ErrInvalidThreadsConfiguration
+		}
+		for key, value := range config {
+			switch key {
+			case "enabled":
+				if _, ok := value.(bool); !ok {
+					return ErrInvalidThreadsConfiguration
+				}
+			case "auto_archive":
+				minutes, ok := value.(int)
+				if !ok || !slices.Contains(discordchat.ThreadArchiveDurations, minutes) {
+					return fmt.Errorf("auto_archive must be one of %v; %w", discordchat.ThreadArchiveDurations, ErrInvalidThreadsConfiguration)
+				}
+			default:
+				return fmt.Errorf("unknown key '%s'; %w", key, ErrInvalidThreadsConfiguration)
+			}
+		}
+		return nil
+	}
+
+	if data, exists := cfg.Get("threads"); exists {
+		if err := validate(data); err != nil {
+			return err
+		}
+	}
+
+	guilds, _ := cfg.GetMap("guilds")
+	for id, guild := range guilds {
+		config, _ := storage.StringMap(guild)
+		if data, exists := config["threads"]; exists {
+			if err := validate(data); err != nil {
+				return fmt.Errorf("guild %s; %w", id, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// check moderation policies & pick the classifier
+// policies are read per message so only the classifier is kept
+func loadModeration(cfg *storage.Disk, client *openai.Client) (*moderation.Moderator, error) {
+	moderator := &moderation.Moderator{
+		Classifier: &moderation.OpenAI{Client: client},
+	}
+
+	if data, exists := cfg.Get("moderation"); exists {
+		if _, err := moderation.ParsePolicy(data); err != nil {
+			return nil, fmt.Errorf("%w; %w", ErrInvalidModerationConfiguration, err)
+		}
+
+		config, _ := storage.StringMap(data)
+		switch config["classifier"] {
+		case nil, "openai":
+		case "keywords":
+			// self hosted - no openai moderation
+			moderator.Classifier = nil
+		default:
+			return nil, fmt.Errorf("unknown classifier; %w", ErrInvalidModerationConfiguration)
+		}
+	}
+
+	guilds, _ := cfg.GetMap("guilds")
+	for id, guild := range guilds {
+		config, _ := storage.StringMap(guild)
+		if data, exists := config["moderation"]; exists {
+			if _, err := moderation.ParsePolicy(data); err != nil {
+				return nil, fmt.Errorf("guild %s; %w; %w", id, ErrInvalidModerationConfiguration, err)
+			}
+		}
+	}
+
+	return moderator, nil
+}
+
 // onMessage handles when a message is recieved
 func (bot *ChatBot) onMessage(s *discordgo.Session, m *discordgo.MessageCreate) {
 	// Ignore all messages from bots (including itself)
@@ -125,6 +467,10 @@ func (bot *ChatBot) onMessage(s *discordgo.Session, m *discordgo.MessageCreate)
 			}
 			m.Content += "- " + att.URL
 			imgs = append(imgs, att.URL)
+		} else if strings.HasPrefix(att.ContentType, "application/json") || strings.HasPrefix(att.ContentType, "text/") {
+			// character cards can be installed as personas
+			// & documents added to the knowledge base
+			m.Content += "\n*user attached a file*: " + att.URL
 		} else {
 			logrus.WithField("content-type", att.ContentType).Debugln("unknown attachment type")
 		}
@@ -132,47 +478,148 @@ func (bot *ChatBot) onMessage(s *discordgo.Session, m *discordgo.MessageCreate)
 
 	if m.GuildID == "" {
 		// direct message
-		dchat, exists := bot.DirectChats[m.ChannelID]
-		if !exists {
-			dchat = bot.newDirectChat(m.ChannelID)
-			bot.DirectChats[m.ChannelID] = dchat
-		}
-		dchat.OnMessage(s, m)
+		quoteReference(s, m)
+		bot.getDirectChat(m.ChannelID).OnMessage(s, m)
+		return
+	}
+
+	// everything in aika's threads is for her
+	if discordchat.IsOwnThread(s, m.ChannelID, bot.hasThreadChat(m.ChannelID)) {
+		quoteReference(s, m)
+		bot.getThreadChat(m.GuildID, m.ChannelID).OnMessage(s, m)
 		return
 	}
 
 	// ignore all messages not mentioning Aika (if they're in guilds)
-	hasMention := false
+	// replying to her counts - even with the ping turned off
+	if !mentionsBot(s.State, m) {
+		return
+	}
 
-	for _, mention := range m.Mentions {
-		//TODO: this doesn't work if a user "copies" thei @aika from another message
-		// why trhe fuck is discord like this?
-		// what the fuck when I copy @Aika i get a MENTIONROLES but not a Mention ?!
-		// NOTE: if you copy an @ in discord
-		//		you can't click the @ and see their profile
-		//		this is a discord fuckery
-		if mention.ID == s.State.User.ID {
-			hasMention = true
-			break
-		}
+	// guild message
+	gchat := bot.getGuildChat(m.GuildID, m.ChannelID)
+
+	// each conversation gets its own thread (& history) if the guild wants.
+	// the thread is named after what was said, so it's quoted after
+	if threadID := gchat.StartThread(s, m); threadID != "" {
+		quoteReference(s, m)
+		bot.getThreadChat(m.GuildID, threadID).OnThreadStart(s, m, threadID)
+		return
 	}
-	if !hasMention {
+	quoteReference(s, m)
+	gchat.OnMessage(s, m)
+}
+
+// onReady registers the slash commands
+// (global commands can take a while to show up in discord)
+func (bot *ChatBot) onReady(s *discordgo.Session, r *discordgo.Ready) {
+	_, err := s.ApplicationCommandBulkOverwrite(r.User.ID, "", discordchat.Commands)
+	if err != nil {
+		logrus.WithError(err).Errorln("failed to register slash commands")
+	}
+}
+
+// onInteraction handles slash commands
+func (bot *ChatBot) onInteraction(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if i.Type != discordgo.InteractionApplicationCommand {
 		return
 	}
 
-	// guild message
-	gchat, exists := bot.GuildChats[m.ChannelID]
+	if i.GuildID == "" {
+		bot.getDirectChat(i.ChannelID).OnCommand(s, i)
+		return
+	}
+	if discordchat.IsThread(s, i.ChannelID) {
+		bot.getThreadChat(i.GuildID, i.ChannelID).OnCommand(s, i)
+		return
+	}
+	bot.getGuildChat(i.GuildID, i.ChannelID).OnCommand(s, i)
+}
+
+// onThreadUpdate forgets chats in threads once they're archived
+func (bot *ChatBot) onThreadUpdate(s *discordgo.Session, t *discordgo.ThreadUpdate) {
+	if t.ThreadMetadata != nil && t.ThreadMetadata.Archived {
+		bot.removeThreadChat(t.ID)
+	}
+}
+
+// onThreadDelete forgets chats in deleted threads
+func (bot *ChatBot) onThreadDelete(s *discordgo.Session, t *discordgo.ThreadDelete) {
+	bot.removeThreadChat(t.ID)
+}
+
+// --- chat lookups
+
+func (bot *ChatBot) getGuildChat(guildID string, channelID string) *discordchat.Guild {
+	bot.chatsMutex.Lock()
+	defer bot.chatsMutex.Unlock()
+
+	gchat, exists := bot.GuildChats[channelID]
 	if !exists {
-		gchat = bot.newGuildChat(m.GuildID)
-		bot.GuildChats[m.ChannelID] = gchat
+		gchat = bot.newGuildChat(guildID)
+		bot.GuildChats[channelID] = gchat
 	}
-	gchat.OnMessage(s, m)
+	return gchat
+}
+
+// threads are chats of their own, without voice.
+// they're forgotten when the thread is archived or deleted
+func (bot *ChatBot) getThreadChat(guildID string, threadID string) *discordchat.Guild {
+	bot.chatsMutex.Lock()
+	defer bot.chatsMutex.Unlock()
+
+	gchat, exists := bot.GuildChats[threadID]
+	if !exists {
+		gchat = bot.newThreadChat(guildID)
+		bot.GuildChats[threadID] = gchat
+		bot.threadChats[threadID] = true
+	}
+	return gchat
+}
+
+func (bot *ChatBot) hasThreadChat(threadID string) bool {
+	bot.chatsMutex.Lock()
+	defer bot.chatsMutex.Unlock()
+
+	return bot.threadChats[threadID]
+}
+
+func (bot *ChatBot) removeThreadChat(threadID string) {
+	bot.chatsMutex.Lock()
+	defer bot.chatsMutex.Unlock()
+
+	if bot.threadChats[threadID] {
+		delete(bot.GuildChats, threadID)
+		delete(bot.threadChats, threadID)
+	}
+}
+
+func (bot *ChatBot) getDirectChat(channelID string) *discordchat.Direct {
+	bot.chatsMutex.Lock()
+	defer bot.chatsMutex.Unlock()
+
+	dchat, exists := bot.DirectChats[channelID]
+	if !exists {
+		dchat = bot.newDirectChat(channelID)
+		bot.DirectChats[channelID] = dchat
+	}
+	return dchat
 }
 
 // --- chat constructors
 
 func (bot *ChatBot) newGuildChat(guildId string) *discordchat.Guild {
-	chat := &discordchat.Guild{
+	chat := bot.newThreadChat(guildId)
+	// enable voice chat for this guild
+	// TODO: setting for this so i can monetize ?
+	chat.InitVoiceChat(bot.Session)
+
+	return chat
+}
+
+// thread chats come & go with their threads - they don't get voice
+func (bot *ChatBot) newThreadChat(guildId string) *discordchat.Guild {
+	return &discordchat.Guild{
 		Chat: discordchat.Chat{
 			Ctx:    bot.Ctx,
 			ChatID: guildId,
@@ -183,11 +630,6 @@ func (bot *ChatBot) newGuildChat(guildId string) *discordchat.Guild {
 		},
 		History: make(map[string][]openai.ChatCompletionMessage),
 	}
-	// enable voice chat for this guild
-	// TODO: setting for this so i can monetize ?
-	chat.InitVoiceChat(bot.Session)
-
-	return chat
 }
 
 func (bot *ChatBot) newDirectChat(channelId string) *discordchat.Direct {
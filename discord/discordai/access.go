@@ -0,0 +1,116 @@
+package discordai
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Tier is the level of access needed to use a function.
+type Tier int
+
+const (
+	TierEveryone Tier = iota
+	TierSubscriber
+	TierAdmin
+	// nobody can use a disabled function
+	TierDisabled
+)
+
+func (t Tier) String() string {
+	switch t {
+	case TierEveryone:
+		return "everyone"
+	case TierSubscriber:
+		return "subscriber"
+	case TierAdmin:
+		return "admin"
+	case TierDisabled:
+		return "disabled"
+	default:
+		return fmt.Sprintf("tier(%d)", int(t))
+	}
+}
+
+// ParseTier converts a config value (everyone, subscriber, admin, disabled) to a Tier
+func ParseTier(value string) (Tier, error) {
+	for t := TierEveryone; t <= TierDisabled; t++ {
+		if strings.EqualFold(value, t.String()) {
+			return t, nil
+		}
+	}
+	return TierDisabled, fmt.Errorf("unknown tier '%s'", value)
+}
+
+// Scope is where a message came from.
+// Scopes can be combined to allow a function in several places.
+type Scope uint8
+
+const (
+	ScopeDM Scope = 1 << iota
+	ScopeGuild
+	ScopeVoice
+
+	ScopeAll = ScopeDM | ScopeGuild | ScopeVoice
+)
+
+// FlagExpensive marks functions which cost real money to run.
+// It's disabled for senders who are over their usage quota.
+const FlagExpensive = "expensive"
+
+// Access describes who is asking & where, so only the
+// functions they're allowed to use are given to the AI.
+type Access struct {
+	// tier of the sender
+	Tier Tier
+	// where the message came from
+	Scope Scope
+	// enabled feature flags
+	Features map[string]bool
+	// function name -> tier overrides (per-guild config)
+	Overrides map[string]Tier
+}
+
+// Allowed returns true if the function can be used with this access
+func (f Function) Allowed(access Access) bool {
+	tier := f.Tier
+	if override, ok := access.Overrides[f.Definition.Name]; ok {
+		tier = override
+	}
+	if tier == TierDisabled || access.Tier < tier {
+		return false
+	}
+
+	scopes := f.Scopes
+	if scopes == 0 {
+		scopes = ScopeAll
+	}
+	if scopes&access.Scope == 0 {
+		return false
+	}
+
+	for _, flag := range f.Flags {
+		if !access.Features[flag] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// WithTier returns a copy of the function which requires the tier
+func (f Function) WithTier(tier Tier) Function {
+	f.Tier = tier
+	return f
+}
+
+// WithScopes returns a copy of the function which is only available in the scopes
+func (f Function) WithScopes(scopes Scope) Function {
+	f.Scopes = scopes
+	return f
+}
+
+// WithFlags returns a copy of the function which needs every feature flag enabled
+func (f Function) WithFlags(flags ...string) Function {
+	f.Flags = append(append([]string{}, f.Flags...), flags...)
+	return f
+}
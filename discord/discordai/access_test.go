@@ -0,0 +1,60 @@
+package discordai
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func testFunction(name string) Function {
+	return Function{
+		Definition: openai.FunctionDefinition{Name: name},
+		Handler: func(_ context.Context, _ map[string]interface{}) (string, error) {
+			return "", nil
+		},
+	}
+}
+
+func names(functions []Function) []string {
+	result := []string{}
+	for _, function := range functions {
+		result = append(result, function.Definition.Name)
+	}
+	return result
+}
+
+func TestRegistryAvailable(t *testing.T) {
+	registry := NewRegistry(
+		testFunction("Search"),
+		testFunction("ListGuilds").WithTier(TierAdmin),
+		testFunction("JoinVoice").WithTier(TierSubscriber).WithScopes(ScopeGuild|ScopeVoice).WithFlags("voice"),
+		testFunction("Nsfw").WithFlags("nsfw"),
+	)
+
+	everyone := Access{Tier: TierEveryone, Scope: ScopeGuild}
+	assert.Equal(t, []string{"Search"}, names(registry.Available(everyone)))
+
+	subscriber := Access{Tier: TierSubscriber, Scope: ScopeGuild, Features: map[string]bool{"voice": true}}
+	assert.Equal(t, []string{"Search", "JoinVoice"}, names(registry.Available(subscriber)))
+
+	// voice is never offered in DMs
+	admin := Access{Tier: TierAdmin, Scope: ScopeDM, Features: map[string]bool{"voice": true, "nsfw": true}}
+	assert.Equal(t, []string{"Search", "ListGuilds", "Nsfw"}, names(registry.Available(admin)))
+
+	// per-guild overrides
+	admin.Overrides = map[string]Tier{"Nsfw": TierDisabled, "Search": TierAdmin}
+	assert.Equal(t, []string{"Search", "ListGuilds"}, names(registry.Available(admin)))
+}
+
+func TestParseTier(t *testing.T) {
+	tier, err := ParseTier("Subscriber")
+	assert.NoError(t, err)
+	assert.Equal(t, TierSubscriber, tier)
+
+	// unknown tiers fail closed
+	tier, err = ParseTier("everybody")
+	assert.Error(t, err)
+	assert.Equal(t, TierDisabled, tier)
+}
@@ -2,12 +2,15 @@ package discordai
 
 import (
 	"aika/ai"
+	"aika/moderation"
 	"context"
 	_ "embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"strings"
+	"sync"
+	"time"
 
 	"github.com/sashabaranov/go-openai"
 	"github.com/sirupsen/logrus"
@@ -19,23 +22,38 @@ var sys string
 //go:embed system_vc.txt
 var sysVoice string
 
-const (
-	failAttempts = 2
-)
-
 type AIBrain struct {
+	// chat, vision & transcription backend
+	Provider ai.Provider
+	Models   ai.Models
+	// openai only features (DALL-E)
 	OpenAI *openai.Client
 
 	HistorySize         int
 	TranscriptionPrompt string
+
+	// optional - folds trimmed history into a running summary
+	Summarizer *Summarizer
+	// optional - long-term memory of discord users
+	Memory *Memory
+	// optional - characters aika can play (see DefaultPersona)
+	Personas *Personas
+	// optional - per-guild world info added when it's mentioned
+	Lorebook *Lorebook
+	// optional - per-guild documents aika can search
+	Knowledge *KnowledgeBase
+	// optional - every exchange, searchable by meaning
+	Archive *Archive
+	// optional - checks messages to & from aika
+	Moderator *moderation.Moderator
 }
 
 func (brain *AIBrain) SpeechToText(
 	ctx context.Context,
 	wavFile string,
 ) (string, error) {
-	resp, err := brain.OpenAI.CreateTranscription(ctx, openai.AudioRequest{
-		Model:    openai.Whisper1,
+	resp, err := brain.Provider.CreateTranscription(ctx, openai.AudioRequest{
+		Model:    brain.Models.Transcription,
 		FilePath: wavFile,
 		Prompt:   brain.TranscriptionPrompt,
 	})
@@ -57,34 +75,50 @@ func (brain *AIBrain) ProcessChunked(
 	functions []Function,
 	model ai.LanguageModel,
 	internalArgs map[string]interface{},
+	observer ToolObserver,
 ) ([]openai.ChatCompletionMessage, error) {
 
+	// separate the running summary from the turns it summarizes
+	summary, turns := splitSummary(history)
+	summary = brain.mergeSummary(ctx, summary, turns)
+	// lore & the summary are sent as extra system context
+	extra := append(brain.loreContext(turns, message, internalArgs), summary...)
+
 	// copy history to a new slice
 	newHistory := []openai.ChatCompletionMessage{}
-	newHistory = append(newHistory, history...)
+	newHistory = append(newHistory, turns...)
+	evicted := []openai.ChatCompletionMessage{}
 
-	functionHandlers := make(map[string]FunctionHandler)
-	functionDefinitions := []openai.FunctionDefinition{}
-	for _, fnc := range functions {
-		functionDefinitions = append(functionDefinitions, fnc.Definition)
-		functionHandlers[fnc.Definition.Name] = fnc.Handler
-	}
+	functionLookup, tools := buildTools(functions)
 
+	guard := newToolGuard()
+	forceAnswer := false
 	failedFuncCall := false
-	for i := 0; i < failAttempts; i++ {
+	for {
 
 		// get openai response
 		req := ai.ChatRequest{
-			Client:    brain.OpenAI,
-			System:    system,
-			History:   newHistory, // we use copied history here so function history is retained!
-			Message:   message,
-			Functions: functionDefinitions,
-			Model:     model,
+			Provider:    brain.Provider,
+			System:      system,
+			Context:     extra,
+			History:     newHistory, // we use copied history here so function history is retained!
+			Message:     message,
+			Tools:       tools,
+			NoToolCalls: forceAnswer,
+			Model:       model,
+			Fallbacks:   brain.Models.Fallbacks,
 		}
+		evicted = append(evicted, brain.fitRequest(&req)...)
+		newHistory = req.History
+
 		res, err := req.Stream(ctx, writer)
 		if err != nil {
-			return nil, fmt.Errorf("failed to request openai; %w", err)
+			return nil, fmt.Errorf("failed to request provider; %w", err)
+		}
+
+		// some providers ignore tool_choice - drop the calls so history stays valid
+		if forceAnswer {
+			res.ToolCalls = nil
 		}
 
 		// push request message into history
@@ -92,71 +126,37 @@ func (brain *AIBrain) ProcessChunked(
 		// push response into history
 		newHistory = append(newHistory, res)
 
-		// trim history
-		// TODO: trim based on TOKEN COUNT
-		if len(newHistory) > brain.HistorySize {
-			newHistory = newHistory[len(newHistory)-brain.HistorySize:]
-		}
-
-		// if FunctionCall is nil - then OpenAI sent us a human response :)
-		if res.FunctionCall == nil {
+		// if ToolCalls is empty - then OpenAI sent us a human response :)
+		if len(res.ToolCalls) == 0 {
 			break
 		}
 
-		// !!! process function call !!!
-
-		// find function handler
-		name := res.FunctionCall.Name
-		handler, exists := functionHandlers[name]
-		var result string
-		if !exists {
-			// hopefully the AI will correct itself and use a real function next time
-			// if not - for loop will exit eventually
-			logrus.WithField("call", res.FunctionCall).Warnln("openai tried to call non-existant function")
-			failedFuncCall = true
-			result = fmt.Sprintf("The function '%s' does not exist. Remember to only call real functions. Please reply to the original message", name)
+		// !!! process tool calls !!!
+		var results []openai.ChatCompletionMessage
+		if reason := guard.check(res.ToolCalls); reason != "" {
+			// make one last request without tools so the user still gets an answer
+			logrus.WithField("reason", reason).Warnln("stopping tool calls")
+			results = stoppedToolResults(res.ToolCalls, reason)
+			forceAnswer = true
 		} else {
-
-			failedFuncCall = false
-
-			// unmarshal args
-			var args map[string]interface{}
-			err = json.Unmarshal([]byte(res.FunctionCall.Arguments), &args)
-			if err != nil {
-				return nil, fmt.Errorf("failed to unmarshal openai args; %w", err)
-			}
-
-			// append internal args
-			for k, v := range internalArgs {
-				args[k] = v
-			}
-
-			// call handler (runs function and gets result for openai!)
-			result, err = handler(args)
-			if err != nil {
-				// functions only return ERR when a fatal error occurs
-				// anything that OpenAI should process is returned as result
-				logrus.WithField("call", res.FunctionCall).WithError(err).Debugln("function execute failed")
-				return nil, fmt.Errorf("failed during function call; %w", err)
-			}
-			logrus.WithField("call", res.FunctionCall).WithField("result", result).Debugln("executed function")
+			results, failedFuncCall = brain.executeToolCalls(ctx, res.ToolCalls, functionLookup, internalArgs, observer)
 		}
 
-		// update message for next iteration
-		message = openai.ChatCompletionMessage{
-			Role:    openai.ChatMessageRoleFunction,
-			Name:    name,
-			Content: result,
-		}
-		// decrement i-- so we infinitely loop from this point
-		i = 0
+		// every result but the last goes straight into history
+		// the last result is sent as the next request message
+		newHistory = append(newHistory, results[:len(results)-1]...)
+		message = results[len(results)-1]
 	}
 
 	if failedFuncCall {
 		return nil, fmt.Errorf("failed while calling functions")
 	}
 
-	return newHistory, nil
+	reserved := ai.CountMessageTokens(system) + ai.CountMessagesTokens(summary) + ai.CountToolTokens(tools)
+	newHistory, dropped := brain.trimHistory(newHistory, reserved, model, true)
+	evicted = append(evicted, dropped...)
+
+	return brain.summarizeHistory(ctx, summary, newHistory, evicted), nil
 }
 
 // process a message & return the new chat history
@@ -169,34 +169,50 @@ func (brain *AIBrain) Process(
 	functions []Function,
 	model ai.LanguageModel,
 	internalArgs map[string]interface{},
+	observer ToolObserver,
 ) ([]openai.ChatCompletionMessage, error) {
 
+	// separate the running summary from the turns it summarizes
+	summary, turns := splitSummary(history)
+	summary = brain.mergeSummary(ctx, summary, turns)
+	// lore & the summary are sent as extra system context
+	extra := append(brain.loreContext(turns, message, internalArgs), summary...)
+
 	// copy history to a new slice
 	newHistory := []openai.ChatCompletionMessage{}
-	newHistory = append(newHistory, history...)
+	newHistory = append(newHistory, turns...)
+	evicted := []openai.ChatCompletionMessage{}
 
-	functionHandlers := make(map[string]FunctionHandler)
-	functionDefinitions := []openai.FunctionDefinition{}
-	for _, fnc := range functions {
-		functionDefinitions = append(functionDefinitions, fnc.Definition)
-		functionHandlers[fnc.Definition.Name] = fnc.Handler
-	}
+	functionLookup, tools := buildTools(functions)
 
+	guard := newToolGuard()
+	forceAnswer := false
 	failedFuncCall := false
-	for i := 0; i < failAttempts; i++ {
+	for {
 
 		// get openai response
 		req := ai.ChatRequest{
-			Client:    brain.OpenAI,
-			System:    system,
-			History:   newHistory, // we use copied history here so function history is retained!
-			Message:   message,
-			Functions: functionDefinitions,
-			Model:     model,
+			Provider:    brain.Provider,
+			System:      system,
+			Context:     extra,
+			History:     newHistory, // we use copied history here so function history is retained!
+			Message:     message,
+			Tools:       tools,
+			NoToolCalls: forceAnswer,
+			Model:       model,
+			Fallbacks:   brain.Models.Fallbacks,
 		}
+		evicted = append(evicted, brain.fitRequest(&req)...)
+		newHistory = req.History
+
 		res, err := req.Send(ctx)
 		if err != nil {
-			return nil, fmt.Errorf("failed to request openai; %w", err)
+			return nil, fmt.Errorf("failed to request provider; %w", err)
+		}
+
+		// some providers ignore tool_choice - drop the calls so history stays valid
+		if forceAnswer {
+			res.ToolCalls = nil
 		}
 
 		// push request message into history
@@ -204,89 +220,283 @@ func (brain *AIBrain) Process(
 		// push response into history
 		newHistory = append(newHistory, res)
 
-		// trim history
-		// TODO: trim based on TOKEN COUNT
-		if len(newHistory) > brain.HistorySize {
-			newHistory = newHistory[len(newHistory)-brain.HistorySize:]
-		}
-
-		// if FunctionCall is nil - then OpenAI sent us a human response :)
-		if res.FunctionCall == nil {
+		// if ToolCalls is empty - then OpenAI sent us a human response :)
+		if len(res.ToolCalls) == 0 {
 			break
 		}
 
-		// !!! process function call !!!
-
-		// find function handler
-		name := res.FunctionCall.Name
-		handler, exists := functionHandlers[name]
-		var result string
-		if !exists {
-			// hopefully the AI will correct itself and use a real function next time
-			// if not - for loop will exit eventually
-			logrus.WithField("call", res.FunctionCall).Warnln("openai tried to call non-existant function")
-			failedFuncCall = true
-			result = fmt.Sprintf("The function '%s' does not exist. Remember to only call real functions. Please reply to the original message", name)
+		// !!! process tool calls !!!
+		var results []openai.ChatCompletionMessage
+		if reason := guard.check(res.ToolCalls); reason != "" {
+			// make one last request without tools so the user still gets an answer
+			logrus.WithField("reason", reason).Warnln("stopping tool calls")
+			results = stoppedToolResults(res.ToolCalls, reason)
+			forceAnswer = true
 		} else {
+			results, failedFuncCall = brain.executeToolCalls(ctx, res.ToolCalls, functionLookup, internalArgs, observer)
+		}
 
-			failedFuncCall = false
+		// every result but the last goes straight into history
+		// the last result is sent as the next request message
+		newHistory = append(newHistory, results[:len(results)-1]...)
+		message = results[len(results)-1]
+	}
 
-			// unmarshal args
-			var args map[string]interface{}
-			err = json.Unmarshal([]byte(res.FunctionCall.Arguments), &args)
-			if err != nil {
-				return nil, fmt.Errorf("failed to unmarshal openai args; %w", err)
-			}
+	if failedFuncCall {
+		return nil, fmt.Errorf("failed while calling functions")
+	}
 
-			// append internal args
-			for k, v := range internalArgs {
-				args[k] = v
-			}
+	reserved := ai.CountMessageTokens(system) + ai.CountMessagesTokens(summary) + ai.CountToolTokens(tools)
+	newHistory, dropped := brain.trimHistory(newHistory, reserved, model, true)
+	evicted = append(evicted, dropped...)
 
-			logrus.WithField("call", res.FunctionCall).Debugln("executing function...")
+	return brain.summarizeHistory(ctx, summary, newHistory, evicted), nil
+}
 
-			//TODO: context expiration for function calls
+// convert functions into openai tools & a name->function lookup
+func buildTools(functions []Function) (map[string]Function, []openai.Tool) {
+	functionLookup := make(map[string]Function)
+	tools := []openai.Tool{}
+	for _, fnc := range functions {
+		definition := fnc.Definition
+		tools = append(tools, openai.Tool{
+			Type:     openai.ToolTypeFunction,
+			Function: &definition,
+		})
+		functionLookup[fnc.Definition.Name] = fnc
+	}
+	return functionLookup, tools
+}
 
-			// call handler (runs function and gets result for openai!)
-			result, err = handler(args)
-			if err != nil {
-				// functions only return ERR when a fatal error occurs
-				// anything that OpenAI should process is returned as result
-				logrus.WithField("call", res.FunctionCall).WithError(err).Debugln("function execute failed")
-				return nil, fmt.Errorf("failed during function call; %w", err)
+// run every tool call from a single assistant turn concurrently
+// results are returned in the same order as the calls
+// failed is true when the AI tried to call a function which doesn't exist
+func (brain *AIBrain) executeToolCalls(
+	ctx context.Context,
+	calls []openai.ToolCall,
+	functionLookup map[string]Function,
+	internalArgs map[string]interface{},
+	observer ToolObserver,
+) (results []openai.ChatCompletionMessage, failed bool) {
+
+	results = make([]openai.ChatCompletionMessage, len(calls))
+	missing := make([]bool, len(calls))
+
+	wg := sync.WaitGroup{}
+	for idx, call := range calls {
+		wg.Add(1)
+		go func(idx int, call openai.ToolCall) {
+			defer wg.Done()
+
+			var result string
+			result, missing[idx] = brain.executeToolCall(ctx, call, functionLookup, internalArgs, observer)
+
+			results[idx] = openai.ChatCompletionMessage{
+				Role:       openai.ChatMessageRoleTool,
+				Name:       call.Function.Name,
+				Content:    result,
+				ToolCallID: call.ID,
 			}
-			logrus.WithField("call", res.FunctionCall).WithField("result", result).Debugln("executed function")
-		}
+		}(idx, call)
+	}
+	wg.Wait()
 
-		// update message for next iteration
-		message = openai.ChatCompletionMessage{
-			Role:    openai.ChatMessageRoleFunction,
-			Name:    name,
-			Content: result,
+	for _, m := range missing {
+		failed = failed || m
+	}
+	return results, failed
+}
+
+// run a single tool call & return the result for openai
+func (brain *AIBrain) executeToolCall(
+	ctx context.Context,
+	call openai.ToolCall,
+	functionLookup map[string]Function,
+	internalArgs map[string]interface{},
+	observer ToolObserver,
+) (result string, missing bool) {
+
+	// find function
+	name := call.Function.Name
+	function, exists := functionLookup[name]
+	if !exists {
+		// hopefully the AI will correct itself and use a real function next time
+		// if not - for loop will exit eventually
+		logrus.WithField("call", call.Function).Warnln("openai tried to call non-existant function")
+		return fmt.Sprintf("The function '%s' does not exist. Remember to only call real functions. Please reply to the original message", name), true
+	}
+
+	// unmarshal args
+	args := map[string]interface{}{}
+	if call.Function.Arguments != "" {
+		err := json.Unmarshal([]byte(call.Function.Arguments), &args)
+		if err != nil {
+			logrus.WithField("call", call.Function).WithError(err).Warnln("failed to unmarshal openai args")
+			return fmt.Sprintf("The arguments for '%s' were not valid JSON; %s", name, err.Error()), false
 		}
-		// decrement i-- so we infinitely loop from this point
-		i = 0
 	}
 
-	if failedFuncCall {
-		return nil, fmt.Errorf("failed while calling functions")
+	// append internal args
+	for k, v := range internalArgs {
+		args[k] = v
 	}
 
-	return newHistory, nil
+	logrus.WithField("call", call.Function).Debugln("executing function...")
+
+	event := ToolEvent{
+		Type:   ToolStarted,
+		CallID: call.ID,
+		Name:   name,
+		Status: function.status(),
+	}
+	observer.emit(event)
+
+	// call handler (runs function and gets result for openai!)
+	result, err := runFunction(ctx, function, args)
+
+	event.Type = ToolFinished
+	if err != nil {
+		event.Type = ToolFailed
+		event.Err = err
+	}
+	observer.emit(event)
+
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		logrus.WithField("call", call.Function).WithError(err).Warnln("function did not finish in time")
+		return timedOutResult(name, function.timeout(), err), false
+	}
+	if err != nil {
+		// functions only return ERR when a fatal error occurs
+		// anything that OpenAI should process is returned as result
+		// other tool calls in this turn may have succeeded, so the
+		// error is handed to openai rather than failing the whole turn
+		logrus.WithField("call", call.Function).WithError(err).Debugln("function execute failed")
+		return fmt.Sprintf("The function '%s' failed; %s", name, err.Error()), false
+	}
+	logrus.WithField("call", call.Function).WithField("result", result).Debugln("executed function")
+
+	return result, false
 }
 
-// build system message from format embedded system.txt
-func (brain *AIBrain) BuildSystemMessage(
-	displayNames []string,
-	mentions []string,
-) openai.ChatCompletionMessage {
+// run the function with its timeout
+// the handler runs in its own goroutine so one which ignores ctx can't hang the chat
+func runFunction(
+	ctx context.Context,
+	function Function,
+	args map[string]interface{},
+) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, function.timeout())
+	defer cancel()
 
-	systemParticipants := ""
-	for i, name := range displayNames {
-		systemParticipants += fmt.Sprintf("  - name: %s\n    tag_with: \"%s\"\n", name, mentions[i])
+	type output struct {
+		result string
+		err    error
 	}
+	done := make(chan output, 1) // buffered so an abandoned handler can still exit
+	go func() {
+		result, err := function.Handler(ctx, args)
+		done <- output{result, err}
+	}()
+
+	select {
+	case out := <-done:
+		return out.result, out.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// structured result telling the AI a function call was abandoned
+func timedOutResult(name string, timeout time.Duration, err error) string {
+	status := "timed_out"
+	message := fmt.Sprintf("'%s' did not finish within %s. Tell the user it took too long, or try again if it might succeed.", name, timeout)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		status = "cancelled"
+		message = fmt.Sprintf("'%s' was cancelled before it finished.", name)
+	}
+
+	data, _ := json.Marshal(map[string]interface{}{
+		"status":          status,
+		"function":        name,
+		"timeout_seconds": timeout.Seconds(),
+		"message":         message,
+	})
+	return string(data)
+}
 
-	system := fmt.Sprintf(sys, systemParticipants)
+// trim the requests history so it fits in the models context budget
+// returns the messages which were dropped from history
+func (brain *AIBrain) fitRequest(req *ai.ChatRequest) []openai.ChatCompletionMessage {
+	reserved := req.Tokens() - ai.CountMessagesTokens(req.History)
+
+	// a non-user message means we're mid tool-chain, so the
+	// turn at the end of history is still in progress
+	inProgress := req.Message.Role != openai.ChatMessageRoleUser
+
+	var evicted []openai.ChatCompletionMessage
+	req.History, evicted = brain.trimHistory(req.History, reserved, req.Model, inProgress)
+	return evicted
+}
+
+// trimHistory drops the oldest turns until history is within the configured size
+// and fits in the models context budget alongside the reserved tokens.
+// A turn starts at a user message, so tool results are always dropped with the
+// assistant call that produced them. When keepLast is true the final turn is never dropped.
+// Returns the kept history and the dropped messages, oldest first.
+func (brain *AIBrain) trimHistory(
+	history []openai.ChatCompletionMessage,
+	reserved int,
+	model ai.LanguageModel,
+	keepLast bool,
+) ([]openai.ChatCompletionMessage, []openai.ChatCompletionMessage) {
+	budget := model.ContextBudget() - reserved
+	evicted := []openai.ChatCompletionMessage{}
+
+	for len(history) > 0 {
+		if len(history) <= brain.HistorySize && ai.CountMessagesTokens(history) <= budget {
+			break
+		}
+
+		trimmed, ok := dropOldestTurn(history, keepLast)
+		if !ok {
+			logrus.
+				WithField("model", model).
+				WithField("tokens", ai.CountMessagesTokens(history)+reserved).
+				Warnln("history cannot be trimmed further")
+			break
+		}
+		evicted = append(evicted, history[:len(history)-len(trimmed)]...)
+		history = trimmed
+	}
+
+	// tool results without their assistant call are rejected by openai
+	for len(history) > 0 && history[0].Role == openai.ChatMessageRoleTool {
+		evicted = append(evicted, history[0])
+		history = history[1:]
+	}
+
+	return history, evicted
+}
+
+// drop everything up to the start of the next turn
+func dropOldestTurn(history []openai.ChatCompletionMessage, keepLast bool) ([]openai.ChatCompletionMessage, bool) {
+	for i := 1; i < len(history); i++ {
+		if history[i].Role == openai.ChatMessageRoleUser {
+			return history[i:], true
+		}
+	}
+	if keepLast {
+		return history, false
+	}
+	return []openai.ChatCompletionMessage{}, true
+}
+
+// build system message from the persona's system.txt template
+func (brain *AIBrain) BuildSystemMessage(
+	persona Persona,
+	prompt PromptContext,
+) openai.ChatCompletionMessage {
+	system := renderSystemPrompt(persona, "system.txt", persona.Prompt, DefaultPersona().Prompt, prompt)
 	logrus.WithField("system", system).Debugln("system message")
 
 	return openai.ChatCompletionMessage{
@@ -295,14 +505,13 @@ func (brain *AIBrain) BuildSystemMessage(
 	}
 }
 
-// build system message from format embedded system_vc.txt
-// this is kinda hacky and dogshit but here I am on saturday writing this
+// build system message from the persona's system_vc.txt template
 func (brain *AIBrain) BuildVoiceSystemMessage(
-	displayNames []string,
+	persona Persona,
+	prompt PromptContext,
 ) openai.ChatCompletionMessage {
-	memberNames := strings.Join(displayNames, ", ")
-
-	system := fmt.Sprintf(sysVoice, memberNames)
+	prompt.Voice = true
+	system := renderSystemPrompt(persona, "system_vc.txt", persona.VoicePrompt, DefaultPersona().VoicePrompt, prompt)
 	// logrus.WithField("system", system).Debugln("voice system message")
 
 	return openai.ChatCompletionMessage{
@@ -310,3 +519,19 @@ func (brain *AIBrain) BuildVoiceSystemMessage(
 		Content: system,
 	}
 }
+
+// templates are checked when personas load so this should never fail
+// if it somehow does aika falls back to being herself rather than going silent
+func renderSystemPrompt(persona Persona, name string, text string, fallback string, prompt PromptContext) string {
+	system, err := renderPrompt(name, text, prompt)
+	if err == nil {
+		return system
+	}
+	logrus.WithError(err).WithField("persona", persona.ID).Errorln("failed to render system message")
+
+	system, err = renderPrompt(name, fallback, prompt)
+	if err != nil {
+		logrus.WithError(err).Errorln("failed to render default system message")
+	}
+	return system
+}
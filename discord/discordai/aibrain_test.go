@@ -0,0 +1,241 @@
+package discordai
+
+import (
+	"aika/ai"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func toolTurn(content string) []openai.ChatCompletionMessage {
+	return []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleUser, Content: content},
+		{Role: openai.ChatMessageRoleAssistant, ToolCalls: []openai.ToolCall{
+			{ID: "call_1", Type: openai.ToolTypeFunction, Function: openai.FunctionCall{Name: "SearchWeb"}},
+			{ID: "call_2", Type: openai.ToolTypeFunction, Function: openai.FunctionCall{Name: "SearchYoutube"}},
+		}},
+		{Role: openai.ChatMessageRoleTool, ToolCallID: "call_1", Content: "result"},
+		{Role: openai.ChatMessageRoleTool, ToolCallID: "call_2", Content: "result"},
+		{Role: openai.ChatMessageRoleAssistant, Content: "reply"},
+	}
+}
+
+func TestTrimHistoryByCount(t *testing.T) {
+	brain := &AIBrain{HistorySize: 6}
+
+	history := append(toolTurn("first"), toolTurn("second")...)
+	trimmed, _ := brain.trimHistory(history, 0, ai.LanguageModel_GPT35, true)
+
+	assert.Len(t, trimmed, 5)
+	assert.Equal(t, "second", trimmed[0].Content)
+}
+
+func TestTrimHistoryByTokens(t *testing.T) {
+	brain := &AIBrain{HistorySize: 100}
+
+	long := strings.Repeat("a", ai.LanguageModel_GPT35.ContextBudget()*4)
+	history := append(toolTurn(long), toolTurn("short")...)
+	trimmed, _ := brain.trimHistory(history, 0, ai.LanguageModel_GPT35, true)
+
+	assert.Len(t, trimmed, 5)
+	assert.Equal(t, "short", trimmed[0].Content)
+}
+
+func TestTrimHistoryNeverOrphansToolResults(t *testing.T) {
+	brain := &AIBrain{HistorySize: 3}
+
+	history := append(toolTurn("first"), toolTurn("second")...)
+	for i := range history {
+		trimmed, _ := brain.trimHistory(history[i:], 0, ai.LanguageModel_GPT35, false)
+		if len(trimmed) == 0 {
+			continue
+		}
+		assert.NotEqual(t, openai.ChatMessageRoleTool, trimmed[0].Role)
+	}
+}
+
+func TestTrimHistoryKeepsLastTurn(t *testing.T) {
+	brain := &AIBrain{HistorySize: 1}
+
+	trimmed, evicted := brain.trimHistory(toolTurn("only"), 0, ai.LanguageModel_GPT35, true)
+	assert.Len(t, trimmed, 5)
+	assert.Empty(t, evicted)
+
+	trimmed, evicted = brain.trimHistory(toolTurn("only"), 0, ai.LanguageModel_GPT35, false)
+	assert.Empty(t, trimmed)
+	assert.Len(t, evicted, 5)
+}
+
+func TestSplitSummary(t *testing.T) {
+	summary := openai.ChatCompletionMessage{Role: openai.ChatMessageRoleSystem, Name: summaryName, Content: summaryHeader + "stuff"}
+
+	head, turns := splitSummary(append([]openai.ChatCompletionMessage{summary}, toolTurn("first")...))
+	assert.Equal(t, []openai.ChatCompletionMessage{summary}, head)
+	assert.Len(t, turns, 5)
+
+	head, turns = splitSummary(toolTurn("first"))
+	assert.Empty(t, head)
+	assert.Len(t, turns, 5)
+}
+
+func TestToolCallTimeout(t *testing.T) {
+	brain := &AIBrain{}
+	hang := Function{
+		Definition: openai.FunctionDefinition{Name: "Hang"},
+		Handler: func(_ context.Context, _ map[string]interface{}) (string, error) {
+			// ignores ctx entirely
+			time.Sleep(time.Second)
+			return "too late", nil
+		},
+		Timeout: 10 * time.Millisecond,
+	}
+	lookup, _ := buildTools([]Function{hang})
+
+	events := []ToolEvent{}
+	observer := func(event ToolEvent) { events = append(events, event) }
+
+	call := openai.ToolCall{ID: "call_1", Function: openai.FunctionCall{Name: "Hang"}}
+	results, failed := brain.executeToolCalls(context.Background(), []openai.ToolCall{call}, lookup, nil, observer)
+	assert.False(t, failed)
+	assert.Contains(t, results[0].Content, `"status":"timed_out"`)
+	assert.Equal(t, "call_1", results[0].ToolCallID)
+
+	// progress is reported to the observer
+	assert.Len(t, events, 2)
+	assert.Equal(t, ToolStarted, events[0].Type)
+	assert.Equal(t, "⚙️ running Hang", events[0].Status)
+	assert.Equal(t, ToolFailed, events[1].Type)
+	assert.ErrorIs(t, events[1].Err, context.DeadlineExceeded)
+
+	// cancelled chats cancel their function calls
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	hang.Timeout = time.Minute
+	lookup, _ = buildTools([]Function{hang})
+	results, _ = brain.executeToolCalls(ctx, []openai.ToolCall{call}, lookup, nil, nil)
+	assert.Contains(t, results[0].Content, `"status":"cancelled"`)
+}
+
+func TestExecuteToolCallsOrder(t *testing.T) {
+	brain := &AIBrain{}
+	// later calls finish first
+	slow := func(delay time.Duration) func(context.Context, map[string]interface{}) (string, error) {
+		return func(_ context.Context, args map[string]interface{}) (string, error) {
+			time.Sleep(delay)
+			return fmt.Sprintf("%v", args["n"]), nil
+		}
+	}
+	lookup, _ := buildTools([]Function{
+		{Definition: openai.FunctionDefinition{Name: "Slow"}, Handler: slow(30 * time.Millisecond)},
+		{Definition: openai.FunctionDefinition{Name: "Fast"}, Handler: slow(0)},
+	})
+
+	calls := []openai.ToolCall{
+		{ID: "call_1", Function: openai.FunctionCall{Name: "Slow", Arguments: `{"n": 1}`}},
+		{ID: "call_2", Function: openai.FunctionCall{Name: "Fast", Arguments: `{"n": 2}`}},
+		{ID: "call_3", Function: openai.FunctionCall{Name: "Missing"}},
+		{ID: "call_4", Function: openai.FunctionCall{Name: "Fast", Arguments: `{"n": 4}`}},
+	}
+	results, failed := brain.executeToolCalls(context.Background(), calls, lookup, nil, nil)
+	assert.True(t, failed)
+
+	// one result per call, in call order
+	assert.Len(t, results, len(calls))
+	for i, call := range calls {
+		assert.Equal(t, openai.ChatMessageRoleTool, results[i].Role)
+		assert.Equal(t, call.ID, results[i].ToolCallID)
+		assert.Equal(t, call.Function.Name, results[i].Name)
+	}
+	assert.Equal(t, "1", results[0].Content)
+	assert.Equal(t, "2", results[1].Content)
+	assert.Contains(t, results[2].Content, "does not exist")
+	assert.Equal(t, "4", results[3].Content)
+}
+
+// provider which calls a function every time it's allowed to
+type loopingProvider struct {
+	ai.Provider
+	requests []openai.ChatCompletionRequest
+}
+
+func (p *loopingProvider) CreateChatCompletion(
+	_ context.Context,
+	request openai.ChatCompletionRequest,
+) (openai.ChatCompletionResponse, error) {
+	p.requests = append(p.requests, request)
+
+	message := openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant}
+	if request.ToolChoice == "none" {
+		message.Content = "here's what I found"
+	} else {
+		message.ToolCalls = []openai.ToolCall{{
+			ID:       fmt.Sprintf("call_%d", len(p.requests)),
+			Type:     openai.ToolTypeFunction,
+			Function: openai.FunctionCall{Name: "Search", Arguments: `{"query": "cats"}`},
+		}}
+	}
+
+	return openai.ChatCompletionResponse{
+		Choices: []openai.ChatCompletionChoice{{Message: message}},
+	}, nil
+}
+
+func TestProcessStopsRepeatedToolCalls(t *testing.T) {
+	provider := &loopingProvider{}
+	brain := &AIBrain{Provider: provider, HistorySize: 100}
+
+	calls := 0
+	search := Function{
+		Definition: openai.FunctionDefinition{Name: "Search"},
+		Handler: func(_ context.Context, _ map[string]interface{}) (string, error) {
+			calls++
+			return "cats are great", nil
+		},
+	}
+
+	history, err := brain.Process(
+		context.Background(),
+		openai.ChatCompletionMessage{Role: openai.ChatMessageRoleSystem, Content: "system"},
+		nil,
+		openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: "tell me about cats"},
+		[]Function{search},
+		ai.LanguageModel_GPT35,
+		nil,
+		nil,
+	)
+	assert.NoError(t, err)
+
+	// identical calls are cut off, then one final answer is forced
+	assert.Equal(t, maxIdenticalCalls, calls)
+	assert.Len(t, provider.requests, maxIdenticalCalls+2)
+	assert.Equal(t, "none", provider.requests[len(provider.requests)-1].ToolChoice)
+	assert.Equal(t, "here's what I found", history[len(history)-1].Content)
+
+	// every tool call still has a result
+	results := map[string]bool{}
+	for _, msg := range history {
+		if msg.Role == openai.ChatMessageRoleTool {
+			results[msg.ToolCallID] = true
+		}
+	}
+	for _, msg := range history {
+		for _, call := range msg.ToolCalls {
+			assert.True(t, results[call.ID], call.ID)
+		}
+	}
+}
+
+func TestToolGuardRoundLimit(t *testing.T) {
+	guard := newToolGuard()
+	for i := 0; i < maxToolRounds; i++ {
+		call := openai.ToolCall{Function: openai.FunctionCall{Name: "Random", Arguments: fmt.Sprintf(`{"min": %d}`, i)}}
+		assert.Empty(t, guard.check([]openai.ToolCall{call}))
+	}
+	call := openai.ToolCall{Function: openai.FunctionCall{Name: "Random", Arguments: `{"min": 100}`}}
+	assert.NotEmpty(t, guard.check([]openai.ToolCall{call}))
+}
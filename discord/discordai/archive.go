@@ -0,0 +1,309 @@
+package discordai
+
+import (
+	"aika/ai"
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// oldest exchanges are forgotten past this (per guild or DM)
+	maxArchivedExchanges = 10_000
+	// results returned when no limit is asked for
+	defaultArchiveResults = 5
+	MaxArchiveResults     = 10
+	// long messages are cut before embedding
+	maxArchiveEmbedLength = 4000
+)
+
+var ErrNoArchiveEmbeddings = errors.New("conversation archive has no embedding provider")
+
+// Exchange is a message aika replied to & her reply
+type Exchange struct {
+	GuildID   string `json:"guild_id,omitempty"`
+	ChannelID string `json:"channel_id"`
+	// empty for voice chats
+	MessageID string    `json:"message_id,omitempty"`
+	AuthorID  string    `json:"author_id"`
+	Author    string    `json:"author"`
+	Message   string    `json:"message"`
+	Response  string    `json:"response"`
+	Time      time.Time `json:"time"`
+	Vector    []float32 `json:"vector"`
+}
+
+// Link to the original message (or the voice channel)
+func (e Exchange) Link() string {
+	guild := e.GuildID
+	if guild == "" {
+		guild = "@me"
+	}
+	if e.MessageID == "" {
+		return fmt.Sprintf("https://discord.com/channels/%s/%s", guild, e.ChannelID)
+	}
+	return fmt.Sprintf("https://discord.com/channels/%s/%s/%s", guild, e.ChannelID, e.MessageID)
+}
+
+// Scope is who can search the exchange - the guild or the DM
+func (e Exchange) Scope() string {
+	return ArchiveScope(e.GuildID, e.ChannelID)
+}
+
+// ArchiveScope is the archive searched from a channel.
+// Guilds share one archive, DMs have their own.
+func ArchiveScope(guildID string, channelID string) string {
+	if guildID != "" {
+		return guildID
+	}
+	return "dm-" + channelID
+}
+
+// ArchiveResult is a past exchange found by a search
+type ArchiveResult struct {
+	Time     time.Time `json:"time"`
+	Author   string    `json:"author"`
+	Message  string    `json:"message"`
+	Response string    `json:"response"`
+	Link     string    `json:"link"`
+	Voice    bool      `json:"voice,omitempty"`
+	Score    float32   `json:"score"`
+}
+
+type archiveScope struct {
+	mutex     sync.RWMutex
+	filename  string
+	exchanges []Exchange
+}
+
+// Archive keeps every exchange so old conversations can be searched by meaning.
+//
+// Each guild or DM is an append-only JSON lines file -
+// rewriting thousands of vectors on every message would be too slow.
+type Archive struct {
+	dir string
+
+	mutex sync.Mutex
+	// scope -> loaded exchanges
+	scopes map[string]*archiveScope
+
+	// embedding backend - set before use
+	Provider ai.Provider
+	Model    string
+}
+
+func NewArchive(dir string) *Archive {
+	return &Archive{
+		dir:    dir,
+		scopes: make(map[string]*archiveScope),
+	}
+}
+
+// scopes are loaded the first time they're used
+func (a *Archive) scope(scope string) (*archiveScope, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if loaded, ok := a.scopes[scope]; ok {
+		return loaded, nil
+	}
+
+	loaded := &archiveScope{
+		filename: filepath.Join(a.dir, filepath.Base(scope)+".jsonl"),
+	}
+	err := loaded.load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load conversation archive; %w", err)
+	}
+	a.scopes[scope] = loaded
+	return loaded, nil
+}
+
+// Add embeds & stores an exchange
+func (a *Archive) Add(ctx context.Context, exchange Exchange) error {
+	if a.Provider == nil {
+		return ErrNoArchiveEmbeddings
+	}
+
+	text := exchange.Author + ": " + exchange.Message + "\nAika: " + exchange.Response
+	if len(text) > maxArchiveEmbedLength {
+		text = text[:maxArchiveEmbedLength]
+	}
+	vectors, err := ai.Embed(ctx, a.Provider, a.Model, []string{text})
+	if err != nil {
+		return err
+	}
+	exchange.Vector = vectors[0]
+
+	scope, err := a.scope(exchange.Scope())
+	if err != nil {
+		return err
+	}
+	return scope.append(exchange)
+}
+
+// Search returns the past exchanges in the scope closest in meaning to the query, best first.
+// A zero since searches everything. Exchanges from channels visible returns false for
+// are skipped so private channels don't leak - a nil visible allows every channel.
+func (a *Archive) Search(
+	ctx context.Context,
+	scope string,
+	query string,
+	since time.Time,
+	limit int,
+	visible func(channelID string) bool,
+) ([]ArchiveResult, error) {
+	if a.Provider == nil {
+		return nil, ErrNoArchiveEmbeddings
+	}
+	if limit <= 0 {
+		limit = defaultArchiveResults
+	}
+	limit = min(limit, MaxArchiveResults)
+
+	loaded, err := a.scope(scope)
+	if err != nil {
+		return nil, err
+	}
+	loaded.mutex.RLock()
+	empty := len(loaded.exchanges) == 0
+	loaded.mutex.RUnlock()
+	if empty {
+		return []ArchiveResult{}, nil // don't pay to embed the query
+	}
+
+	vectors, err := ai.Embed(ctx, a.Provider, a.Model, []string{query})
+	if err != nil {
+		return nil, err
+	}
+
+	results := []ArchiveResult{}
+	loaded.mutex.RLock()
+	for _, exchange := range loaded.exchanges {
+		if exchange.Time.Before(since) {
+			continue
+		}
+		if visible != nil && !visible(exchange.ChannelID) {
+			continue
+		}
+		results = append(results, ArchiveResult{
+			Time:     exchange.Time,
+			Author:   exchange.Author,
+			Message:  exchange.Message,
+			Response: exchange.Response,
+			Link:     exchange.Link(),
+			Voice:    exchange.MessageID == "",
+			Score:    ai.Similarity(vectors[0], exchange.Vector),
+		})
+	}
+	loaded.mutex.RUnlock()
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+func (s *archiveScope) load() error {
+	file, err := os.Open(s.filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16<<20)
+	for scanner.Scan() {
+		exchange := Exchange{}
+		err := json.Unmarshal(scanner.Bytes(), &exchange)
+		if err != nil {
+			continue // a crash mid-write can leave half a line
+		}
+		s.exchanges = append(s.exchanges, exchange)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read %s; %w", s.filename, err)
+	}
+
+	if len(s.exchanges) > maxArchivedExchanges {
+		s.exchanges = s.exchanges[len(s.exchanges)-maxArchivedExchanges:]
+		return s.compact()
+	}
+	return nil
+}
+
+func (s *archiveScope) append(exchange Exchange) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.exchanges = append(s.exchanges, exchange)
+	// let the file grow a little before rewriting it
+	if len(s.exchanges) > maxArchivedExchanges+maxArchivedExchanges/10 {
+		s.exchanges = s.exchanges[len(s.exchanges)-maxArchivedExchanges:]
+		return s.compact()
+	}
+
+	line, err := json.Marshal(exchange)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(s.filename), 0755)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(s.filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+// rewrite the file with only the exchanges in memory
+// write to a temp file & rename so a crash can't lose the archive
+func (s *archiveScope) compact() error {
+	err := os.MkdirAll(filepath.Dir(s.filename), 0755)
+	if err != nil {
+		return err
+	}
+
+	tmp := s.filename + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for _, exchange := range s.exchanges {
+		if err := encoder.Encode(exchange); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, s.filename)
+}
@@ -0,0 +1,123 @@
+package discordai
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchive(t *testing.T) {
+	provider := &wordProvider{}
+	dir := t.TempDir()
+	archive := NewArchive(dir)
+	archive.Provider = provider
+	ctx := context.Background()
+
+	// nothing archived - the query isn't embedded
+	results, err := archive.Search(ctx, "guild", "spam", time.Time{}, 0, nil)
+	require.NoError(t, err)
+	assert.Empty(t, results)
+	assert.Equal(t, 0, provider.requests)
+
+	old := time.Now().AddDate(0, 0, -30)
+	require.NoError(t, archive.Add(ctx, Exchange{
+		GuildID: "guild", ChannelID: "general", MessageID: "1", Author: "alice",
+		Message: "should we ban people for spam?", Response: "yes, spam means a ban", Time: old,
+	}))
+	require.NoError(t, archive.Add(ctx, Exchange{
+		GuildID: "guild", ChannelID: "voice", Author: "bob",
+		Message: "do you like cats", Response: "cats are fine I guess", Time: time.Now(),
+	}))
+	require.NoError(t, archive.Add(ctx, Exchange{
+		ChannelID: "dm", MessageID: "3", Author: "alice",
+		Message: "secret spam plans", Response: "ok", Time: time.Now(),
+	}))
+
+	results, err = archive.Search(ctx, "guild", "what did we decide about spam", time.Time{}, 1, nil)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "alice", results[0].Author)
+	assert.Equal(t, "https://discord.com/channels/guild/general/1", results[0].Link)
+	assert.False(t, results[0].Voice)
+
+	// older exchanges can be skipped
+	results, err = archive.Search(ctx, "guild", "spam", time.Now().AddDate(0, 0, -7), 0, nil)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "bob", results[0].Author)
+	assert.True(t, results[0].Voice)
+	assert.Equal(t, "https://discord.com/channels/guild/voice", results[0].Link)
+
+	// DMs have their own archive
+	results, err = archive.Search(ctx, ArchiveScope("", "dm"), "spam", time.Time{}, 0, nil)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "https://discord.com/channels/@me/dm/3", results[0].Link)
+
+	// persisted - a half written line is skipped
+	file, err := os.OpenFile(filepath.Join(dir, "guild.jsonl"), os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = file.WriteString(`{"guild_id": "gui`)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	archive = NewArchive(dir)
+	archive.Provider = provider
+	results, err = archive.Search(ctx, "guild", "cats", time.Time{}, 0, nil)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, "bob", results[0].Author)
+}
+
+func TestArchiveHiddenChannels(t *testing.T) {
+	archive := NewArchive(t.TempDir())
+	archive.Provider = &wordProvider{}
+	ctx := context.Background()
+
+	require.NoError(t, archive.Add(ctx, Exchange{
+		GuildID: "guild", ChannelID: "general", MessageID: "1", Author: "alice",
+		Message: "when is the meeting", Response: "friday",
+	}))
+	require.NoError(t, archive.Add(ctx, Exchange{
+		GuildID: "guild", ChannelID: "admins", MessageID: "2", Author: "bob",
+		Message: "the meeting is about banning alice", Response: "noted",
+	}))
+	require.NoError(t, archive.Add(ctx, Exchange{
+		GuildID: "guild", ChannelID: "staff-voice", Author: "carol",
+		Message: "meeting notes for staff", Response: "ok",
+	}))
+
+	visible := func(channelID string) bool {
+		return channelID == "general"
+	}
+	results, err := archive.Search(ctx, "guild", "meeting", time.Time{}, 0, visible)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "alice", results[0].Author)
+
+	// nothing visible - nothing returned
+	results, err = archive.Search(ctx, "guild", "meeting", time.Time{}, 0, func(string) bool { return false })
+	require.NoError(t, err)
+	assert.Empty(t, results)
+}
+
+func TestArchiveLimit(t *testing.T) {
+	dir := t.TempDir()
+	scope := &archiveScope{filename: filepath.Join(dir, "guild.jsonl")}
+	for i := 0; i < maxArchivedExchanges+maxArchivedExchanges/10+1; i++ {
+		scope.exchanges = append(scope.exchanges, Exchange{GuildID: "guild", Author: "alice"})
+	}
+	require.NoError(t, scope.append(Exchange{GuildID: "guild", Author: "bob"}))
+	assert.Len(t, scope.exchanges, maxArchivedExchanges)
+	assert.Equal(t, "bob", scope.exchanges[len(scope.exchanges)-1].Author)
+
+	// the file was rewritten
+	loaded := &archiveScope{filename: scope.filename}
+	require.NoError(t, loaded.load())
+	assert.Len(t, loaded.exchanges, maxArchivedExchanges)
+}
@@ -0,0 +1,46 @@
+package discordai
+
+import "fmt"
+
+type ToolEventType int
+
+const (
+	ToolStarted ToolEventType = iota
+	ToolFinished
+	ToolFailed
+)
+
+// ToolEvent reports the progress of a function call
+type ToolEvent struct {
+	Type   ToolEventType
+	CallID string
+	Name   string
+	// human readable status - "🔍 searching the web"
+	Status string
+	// why the call failed (ToolFailed only)
+	Err error
+}
+
+// ToolObserver receives tool events while a message is processed.
+// It is called from several goroutines at once and must not block.
+type ToolObserver func(event ToolEvent)
+
+// WithStatus returns a copy of the function which shows status while it runs
+func (f Function) WithStatus(status string) Function {
+	f.Status = status
+	return f
+}
+
+func (f Function) status() string {
+	if f.Status == "" {
+		return fmt.Sprintf("⚙️ running %s", f.Definition.Name)
+	}
+	return f.Status
+}
+
+// send an event if anyone is listening
+func (observer ToolObserver) emit(event ToolEvent) {
+	if observer != nil {
+		observer(event)
+	}
+}
@@ -1,10 +1,54 @@
 package discordai
 
-import "github.com/sashabaranov/go-openai"
+import (
+	"context"
+	"time"
 
-type FunctionHandler func(map[string]interface{}) (string, error)
+	"github.com/sashabaranov/go-openai"
+)
+
+// how long a function may run when it doesn't declare a timeout
+const defaultFunctionTimeout = 30 * time.Second
+
+// FunctionHandler runs a function call.
+// ctx is cancelled when the function times out or the chat is cancelled.
+type FunctionHandler func(ctx context.Context, args map[string]interface{}) (string, error)
 
 type Function struct {
 	Definition openai.FunctionDefinition
 	Handler    FunctionHandler
+	// how long the function may run before the AI is told it timed out
+	// zero uses defaultFunctionTimeout
+	Timeout time.Duration
+
+	// who may use the function (see Access)
+	Tier Tier
+	// where the function may be used - zero is everywhere
+	Scopes Scope
+	// feature flags which must all be enabled
+	Flags []string
+
+	// shown to users while the function runs (see ToolEvent)
+	Status string
+}
+
+// WithTimeout returns a copy of the function with a different timeout
+func (f Function) WithTimeout(timeout time.Duration) Function {
+	f.Timeout = timeout
+	return f
+}
+
+func (f Function) timeout() time.Duration {
+	if f.Timeout <= 0 {
+		return defaultFunctionTimeout
+	}
+	return f.Timeout
+}
+
+// Call runs the function outside of a chat (slash commands)
+// with the same timeout the AI would get
+func (f Function) Call(ctx context.Context, args map[string]interface{}) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, f.timeout())
+	defer cancel()
+	return f.Handler(ctx, args)
 }
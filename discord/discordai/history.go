@@ -0,0 +1,53 @@
+package discordai
+
+import (
+	"aika/ai"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// HistoryOverview is what aika remembers of a conversation
+type HistoryOverview struct {
+	// running summary of messages trimmed from history
+	Summary string
+	// user messages still in history
+	Turns    int
+	Messages int
+	Tokens   int
+}
+
+// DescribeHistory summarizes a chat's history for users
+func DescribeHistory(history []openai.ChatCompletionMessage) HistoryOverview {
+	summary, turns := splitSummary(history)
+
+	overview := HistoryOverview{
+		Messages: len(turns),
+		Tokens:   ai.CountMessagesTokens(history),
+	}
+	if len(summary) > 0 {
+		overview.Summary = strings.TrimPrefix(summary[0].Content, summaryHeader)
+	}
+	for _, message := range turns {
+		if message.Role == openai.ChatMessageRoleUser {
+			overview.Turns++
+		}
+	}
+	return overview
+}
+
+// DropLastTurn forgets the newest user message & everything aika did to reply to it.
+// The running summary is kept. Returns false when there's no turn to drop.
+func DropLastTurn(history []openai.ChatCompletionMessage) ([]openai.ChatCompletionMessage, bool) {
+	summary, turns := splitSummary(history)
+	for i := len(turns) - 1; i >= 0; i-- {
+		if turns[i].Role != openai.ChatMessageRoleUser {
+			continue
+		}
+		result := []openai.ChatCompletionMessage{}
+		result = append(result, summary...)
+		result = append(result, turns[:i]...)
+		return result, true
+	}
+	return history, false
+}
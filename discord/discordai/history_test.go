@@ -0,0 +1,44 @@
+package discordai
+
+import (
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDropLastTurn(t *testing.T) {
+	summary := openai.ChatCompletionMessage{Role: openai.ChatMessageRoleSystem, Name: summaryName, Content: summaryHeader + "stuff"}
+	history := append([]openai.ChatCompletionMessage{summary}, toolTurn("first")...)
+	history = append(history, toolTurn("second")...)
+
+	dropped, ok := DropLastTurn(history)
+	assert.True(t, ok)
+	assert.Len(t, dropped, 6)
+	assert.Equal(t, summary, dropped[0])
+	assert.Equal(t, "first", dropped[1].Content)
+
+	dropped, ok = DropLastTurn(dropped)
+	assert.True(t, ok)
+	assert.Equal(t, []openai.ChatCompletionMessage{summary}, dropped)
+
+	// the summary isn't a turn
+	_, ok = DropLastTurn(dropped)
+	assert.False(t, ok)
+	_, ok = DropLastTurn(nil)
+	assert.False(t, ok)
+}
+
+func TestDescribeHistory(t *testing.T) {
+	summary := openai.ChatCompletionMessage{Role: openai.ChatMessageRoleSystem, Name: summaryName, Content: summaryHeader + "stuff"}
+	history := append([]openai.ChatCompletionMessage{summary}, toolTurn("first")...)
+	history = append(history, toolTurn("second")...)
+
+	overview := DescribeHistory(history)
+	assert.Equal(t, "stuff", overview.Summary)
+	assert.Equal(t, 2, overview.Turns)
+	assert.Equal(t, 10, overview.Messages)
+	assert.Greater(t, overview.Tokens, 0)
+
+	assert.Equal(t, HistoryOverview{}, DescribeHistory(nil))
+}
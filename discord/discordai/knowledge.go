@@ -0,0 +1,371 @@
+package discordai
+
+import (
+	"aika/ai"
+	"aika/storage"
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// passages are small so search results fit in a prompt
+	maxChunkTokens = 300
+	// keep a lid on how much one guild can upload (& pay to embed)
+	maxKnowledgeTokens = 500_000
+	// passages returned when no limit is asked for
+	defaultPassages = 4
+	MaxPassages     = 10
+)
+
+var (
+	ErrDocumentEmpty    = errors.New("document has no text")
+	ErrDocumentNotText  = errors.New("document isn't text")
+	ErrTooMuchKnowledge = errors.New("knowledge base is full")
+	ErrDocumentNotFound = errors.New("document not found")
+	ErrNoEmbeddings     = errors.New("knowledge base has no embedding provider")
+)
+
+// KnowledgeDocument is a file uploaded to a guild's knowledge base
+type KnowledgeDocument struct {
+	Source  string    `json:"source"`
+	Chunks  int       `json:"chunks"`
+	Tokens  int       `json:"tokens"`
+	AddedBy string    `json:"added_by"`
+	Added   time.Time `json:"added"`
+}
+
+type knowledgeChunk struct {
+	Source string `json:"source"`
+	// markdown heading the chunk is under
+	Section string    `json:"section,omitempty"`
+	Text    string    `json:"text"`
+	Vector  []float32 `json:"vector"`
+}
+
+type knowledgeData struct {
+	Documents []KnowledgeDocument `json:"documents"`
+	Chunks    []knowledgeChunk    `json:"chunks"`
+}
+
+// Passage is a search result from the knowledge base
+type Passage struct {
+	// file & section the passage came from - "faq.md > Installing"
+	Source string  `json:"source"`
+	Text   string  `json:"text"`
+	Score  float32 `json:"score"`
+}
+
+// KnowledgeBase is per-guild documentation (FAQs, rules, guides)
+// which aika searches by meaning rather than keywords.
+// Each guild is stored in its own file since vectors are large.
+type KnowledgeBase struct {
+	dir string
+
+	mutex sync.Mutex
+	// guild ID -> loaded store
+	guilds map[string]*storage.JSON[knowledgeData]
+
+	// embedding backend - set before use
+	Provider ai.Provider
+	Model    string
+}
+
+func NewKnowledgeBase(dir string) *KnowledgeBase {
+	return &KnowledgeBase{
+		dir:    dir,
+		guilds: make(map[string]*storage.JSON[knowledgeData]),
+	}
+}
+
+// stores are loaded the first time a guild is used
+func (kb *KnowledgeBase) store(guildID string) (*storage.JSON[knowledgeData], error) {
+	kb.mutex.Lock()
+	defer kb.mutex.Unlock()
+
+	if store, ok := kb.guilds[guildID]; ok {
+		return store, nil
+	}
+	// discord IDs are numbers but don't trust them with a path
+	store, err := storage.NewJSON[knowledgeData](filepath.Join(kb.dir, filepath.Base(guildID)+".json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load knowledge base; %w", err)
+	}
+	kb.guilds[guildID] = store
+	return store, nil
+}
+
+// Add chunks, embeds & stores a document.
+// A document with the same source is replaced.
+func (kb *KnowledgeBase) Add(ctx context.Context, guildID string, source string, text string, addedBy string) (KnowledgeDocument, error) {
+	if kb.Provider == nil {
+		return KnowledgeDocument{}, ErrNoEmbeddings
+	}
+	if !utf8.ValidString(text) || strings.ContainsRune(text, 0) {
+		return KnowledgeDocument{}, ErrDocumentNotText
+	}
+
+	chunks := chunkDocument(source, text)
+	if len(chunks) == 0 {
+		return KnowledgeDocument{}, ErrDocumentEmpty
+	}
+
+	document := KnowledgeDocument{
+		Source:  source,
+		Chunks:  len(chunks),
+		AddedBy: addedBy,
+		Added:   time.Now(),
+	}
+	inputs := []string{}
+	for _, chunk := range chunks {
+		document.Tokens += ai.CountTokens(chunk.Text)
+		inputs = append(inputs, chunk.embeddingInput())
+	}
+
+	store, err := kb.store(guildID)
+	if err != nil {
+		return document, err
+	}
+
+	// check the size before paying to embed it
+	if kb.tokens(store, source)+document.Tokens > maxKnowledgeTokens {
+		return document, ErrTooMuchKnowledge
+	}
+
+	vectors, err := ai.Embed(ctx, kb.Provider, kb.Model, inputs)
+	if err != nil {
+		return document, err
+	}
+	for i := range chunks {
+		chunks[i].Vector = vectors[i]
+	}
+
+	err = store.Update(func(data *knowledgeData) error {
+		data.remove(source)
+		data.Documents = append(data.Documents, document)
+		data.Chunks = append(data.Chunks, chunks...)
+		return nil
+	})
+	if err != nil {
+		return document, err
+	}
+
+	return document, nil
+}
+
+// tokens stored for the guild, not counting the source being replaced
+func (kb *KnowledgeBase) tokens(store *storage.JSON[knowledgeData], except string) int {
+	tokens := 0
+	store.Read(func(data *knowledgeData) {
+		for _, document := range data.Documents {
+			if document.Source != except {
+				tokens += document.Tokens
+			}
+		}
+	})
+	return tokens
+}
+
+// Remove deletes a document from the guild
+func (kb *KnowledgeBase) Remove(guildID string, source string) error {
+	store, err := kb.store(guildID)
+	if err != nil {
+		return err
+	}
+	return store.Update(func(data *knowledgeData) error {
+		if !data.remove(source) {
+			return ErrDocumentNotFound
+		}
+		return nil
+	})
+}
+
+// Documents returns every document in the guild's knowledge base
+func (kb *KnowledgeBase) Documents(guildID string) ([]KnowledgeDocument, error) {
+	store, err := kb.store(guildID)
+	if err != nil {
+		return nil, err
+	}
+	documents := []KnowledgeDocument{}
+	store.Read(func(data *knowledgeData) {
+		documents = append(documents, data.Documents...)
+	})
+	return documents, nil
+}
+
+// Search returns the passages closest in meaning to the query, best first
+func (kb *KnowledgeBase) Search(ctx context.Context, guildID string, query string, limit int) ([]Passage, error) {
+	if kb.Provider == nil {
+		return nil, ErrNoEmbeddings
+	}
+	if limit <= 0 {
+		limit = defaultPassages
+	}
+	limit = min(limit, MaxPassages)
+
+	store, err := kb.store(guildID)
+	if err != nil {
+		return nil, err
+	}
+	empty := true
+	store.Read(func(data *knowledgeData) {
+		empty = len(data.Chunks) == 0
+	})
+	if empty {
+		return []Passage{}, nil // don't pay to embed the query
+	}
+
+	vectors, err := ai.Embed(ctx, kb.Provider, kb.Model, []string{query})
+	if err != nil {
+		return nil, err
+	}
+
+	passages := []Passage{}
+	store.Read(func(data *knowledgeData) {
+		for _, chunk := range data.Chunks {
+			passages = append(passages, Passage{
+				Source: chunk.reference(),
+				Text:   chunk.Text,
+				Score:  ai.Similarity(vectors[0], chunk.Vector),
+			})
+		}
+	})
+
+	sort.SliceStable(passages, func(i, j int) bool {
+		return passages[i].Score > passages[j].Score
+	})
+	if len(passages) > limit {
+		passages = passages[:limit]
+	}
+	return passages, nil
+}
+
+// returns false if the document doesn't exist
+func (data *knowledgeData) remove(source string) bool {
+	found := false
+	documents := []KnowledgeDocument{}
+	for _, document := range data.Documents {
+		if document.Source == source {
+			found = true
+			continue
+		}
+		documents = append(documents, document)
+	}
+	chunks := []knowledgeChunk{}
+	for _, chunk := range data.Chunks {
+		if chunk.Source != source {
+			chunks = append(chunks, chunk)
+		}
+	}
+	data.Documents = documents
+	data.Chunks = chunks
+	return found
+}
+
+// "faq.md > Installing"
+func (chunk knowledgeChunk) reference() string {
+	if chunk.Section == "" {
+		return chunk.Source
+	}
+	return chunk.Source + " > " + chunk.Section
+}
+
+// the heading is embedded too so short passages keep their context
+func (chunk knowledgeChunk) embeddingInput() string {
+	return chunk.reference() + "\n\n" + chunk.Text
+}
+
+// split a document into passages of up to maxChunkTokens.
+// paragraphs are kept together where possible & markdown headings
+// start a new passage so each one covers a single section.
+func chunkDocument(source string, text string) []knowledgeChunk {
+	chunks := []knowledgeChunk{}
+	section := ""
+	current := []string{}
+	tokens := 0
+
+	flush := func() {
+		if len(current) > 0 {
+			chunks = append(chunks, knowledgeChunk{
+				Source:  source,
+				Section: section,
+				Text:    strings.Join(current, "\n\n"),
+			})
+		}
+		current = nil
+		tokens = 0
+	}
+	add := func(paragraph string) {
+		for _, piece := range splitParagraph(paragraph) {
+			count := ai.CountTokens(piece)
+			if tokens+count > maxChunkTokens {
+				flush()
+			}
+			current = append(current, piece)
+			tokens += count
+		}
+	}
+
+	paragraph := []string{}
+	endParagraph := func() {
+		if len(paragraph) > 0 {
+			add(strings.Join(paragraph, "\n"))
+		}
+		paragraph = nil
+	}
+
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case isHeading(trimmed):
+			endParagraph()
+			flush()
+			section = strings.TrimSpace(strings.TrimLeft(trimmed, "#"))
+		case trimmed == "":
+			endParagraph()
+		default:
+			paragraph = append(paragraph, strings.TrimRight(line, " \t"))
+		}
+	}
+	endParagraph()
+	flush()
+
+	return chunks
+}
+
+// "# Title" but not "#channel" or "#1 fan"
+func isHeading(line string) bool {
+	level := len(line) - len(strings.TrimLeft(line, "#"))
+	return level > 0 && level <= 6 && len(line) > level && line[level] == ' '
+}
+
+// paragraphs over maxChunkTokens are split between words
+func splitParagraph(paragraph string) []string {
+	if ai.CountTokens(paragraph) <= maxChunkTokens {
+		return []string{paragraph}
+	}
+
+	pieces := []string{}
+	piece := ""
+	for _, word := range strings.Fields(paragraph) {
+		if piece != "" && ai.CountTokens(piece+" "+word) > maxChunkTokens {
+			pieces = append(pieces, piece)
+			piece = ""
+		}
+		if piece != "" {
+			piece += " "
+		}
+		piece += word
+	}
+	if piece != "" {
+		pieces = append(pieces, piece)
+	}
+	return pieces
+}
@@ -0,0 +1,140 @@
+package discordai
+
+import (
+	"aika/ai"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// provider which embeds text as counts of a few words
+type wordProvider struct {
+	ai.Provider
+	requests int
+}
+
+var testVocabulary = []string{"install", "ban", "rules", "cats", "spam"}
+
+func (p *wordProvider) CreateEmbeddings(
+	_ context.Context,
+	request openai.EmbeddingRequest,
+) (openai.EmbeddingResponse, error) {
+	p.requests++
+
+	resp := openai.EmbeddingResponse{}
+	for i, text := range request.Input.([]string) {
+		vector := make([]float32, len(testVocabulary))
+		for j, word := range testVocabulary {
+			vector[j] = float32(strings.Count(strings.ToLower(text), word))
+		}
+		// reversed to check the order is restored
+		resp.Data = append([]openai.Embedding{{Embedding: vector, Index: i}}, resp.Data...)
+	}
+	return resp, nil
+}
+
+const testDocument = `# Setup
+
+To install the bot run the installer & install the plugins.
+
+# Rules
+No spam. Spam gets you a ban.
+
+Be nice to cats.`
+
+func TestChunkDocument(t *testing.T) {
+	chunks := chunkDocument("faq.md", testDocument)
+	require.Len(t, chunks, 2)
+	assert.Equal(t, "faq.md > Setup", chunks[0].reference())
+	assert.Equal(t, "To install the bot run the installer & install the plugins.", chunks[0].Text)
+	assert.Equal(t, "faq.md > Rules", chunks[1].reference())
+	assert.Equal(t, "No spam. Spam gets you a ban.\n\nBe nice to cats.", chunks[1].Text)
+
+	// "#channel" isn't a heading
+	chunks = chunkDocument("notes.txt", "ask in\n#help")
+	require.Len(t, chunks, 1)
+	assert.Equal(t, "notes.txt", chunks[0].reference())
+
+	// huge paragraphs are split
+	chunks = chunkDocument("big.txt", strings.Repeat("word ", maxChunkTokens*2))
+	assert.Greater(t, len(chunks), 1)
+	for _, chunk := range chunks {
+		assert.LessOrEqual(t, ai.CountTokens(chunk.Text), maxChunkTokens)
+	}
+}
+
+func TestKnowledgeBase(t *testing.T) {
+	provider := &wordProvider{}
+	dir := t.TempDir()
+	kb := NewKnowledgeBase(dir)
+	kb.Provider = provider
+
+	ctx := context.Background()
+
+	// nothing to search - the query isn't embedded
+	passages, err := kb.Search(ctx, "guild", "how do I install it?", 0)
+	require.NoError(t, err)
+	assert.Empty(t, passages)
+	assert.Equal(t, 0, provider.requests)
+
+	document, err := kb.Add(ctx, "guild", "faq.md", testDocument, "admin")
+	require.NoError(t, err)
+	assert.Equal(t, 2, document.Chunks)
+
+	passages, err = kb.Search(ctx, "guild", "how do I install it?", 1)
+	require.NoError(t, err)
+	require.Len(t, passages, 1)
+	assert.Equal(t, "faq.md > Setup", passages[0].Source)
+
+	passages, err = kb.Search(ctx, "guild", "will spam get me a ban", 0)
+	require.NoError(t, err)
+	require.Len(t, passages, 2)
+	assert.Equal(t, "faq.md > Rules", passages[0].Source)
+
+	// guilds are separate
+	passages, err = kb.Search(ctx, "other", "install", 0)
+	require.NoError(t, err)
+	assert.Empty(t, passages)
+
+	// re-adding replaces the document
+	_, err = kb.Add(ctx, "guild", "faq.md", "cats only", "admin")
+	require.NoError(t, err)
+	documents, err := kb.Documents("guild")
+	require.NoError(t, err)
+	require.Len(t, documents, 1)
+	assert.Equal(t, 1, documents[0].Chunks)
+
+	// persisted
+	kb = NewKnowledgeBase(dir)
+	kb.Provider = provider
+	passages, err = kb.Search(ctx, "guild", "cats", 0)
+	require.NoError(t, err)
+	require.Len(t, passages, 1)
+	assert.Equal(t, "cats only", passages[0].Text)
+
+	require.NoError(t, kb.Remove("guild", "faq.md"))
+	assert.ErrorIs(t, kb.Remove("guild", "faq.md"), ErrDocumentNotFound)
+	documents, err = kb.Documents("guild")
+	require.NoError(t, err)
+	assert.Empty(t, documents)
+}
+
+func TestKnowledgeBaseInvalid(t *testing.T) {
+	kb := NewKnowledgeBase(t.TempDir())
+	ctx := context.Background()
+
+	_, err := kb.Add(ctx, "guild", "faq.md", "text", "admin")
+	assert.ErrorIs(t, err, ErrNoEmbeddings)
+
+	kb.Provider = &wordProvider{}
+	_, err = kb.Add(ctx, "guild", "faq.md", "  \n\n ", "admin")
+	assert.ErrorIs(t, err, ErrDocumentEmpty)
+	_, err = kb.Add(ctx, "guild", "logo.png", "\x89PNG\x00\xff", "admin")
+	assert.ErrorIs(t, err, ErrDocumentNotText)
+	_, err = kb.Add(ctx, "guild", "huge.txt", strings.Repeat("word ", maxKnowledgeTokens), "admin")
+	assert.ErrorIs(t, err, ErrTooMuchKnowledge)
+}
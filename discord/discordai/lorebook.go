@@ -0,0 +1,252 @@
+package discordai
+
+import (
+	"aika/ai"
+	"aika/storage"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/sashabaranov/go-openai"
+)
+
+const (
+	// tokens of lore added to a request when no budget is configured
+	defaultLoreBudget = 400
+	// history messages scanned for keywords along with the new message
+	loreScanDepth = 4
+	// keep a lid on how much lore one guild can store
+	maxLoreEntries = 200
+	// entries are short notes - not wiki pages
+	MaxLoreLength = 1000
+
+	// name of the system message holding lore
+	loreName = "lorebook"
+)
+
+var (
+	ErrLoreTooLong    = errors.New("lore too long")
+	ErrTooMuchLore    = errors.New("too many lore entries")
+	ErrLoreNoKeywords = errors.New("lore needs keywords")
+	ErrLoreNotFound   = errors.New("lore entry not found")
+	ErrLoreEmpty      = errors.New("lore has no content")
+)
+
+// LoreEntry is a piece of world info added to the
+// prompt when one of its keywords is mentioned
+type LoreEntry struct {
+	ID       string   `json:"id"`
+	Keywords []string `json:"keywords"`
+	Content  string   `json:"content"`
+	// always added - no keywords needed
+	Constant bool `json:"constant,omitempty"`
+	// higher priority entries are added first when lore is over budget
+	Priority int       `json:"priority,omitempty"`
+	Created  time.Time `json:"created"`
+}
+
+type loreData struct {
+	// guild ID -> entries (oldest first)
+	Guilds map[string][]LoreEntry `json:"guilds"`
+}
+
+// Lorebook is per-guild world info (SillyTavern style).
+// Entries are only added to a request when the conversation mentions them.
+type Lorebook struct {
+	store *storage.JSON[loreData]
+	// tokens of lore allowed per request - zero uses defaultLoreBudget
+	Budget int
+}
+
+func NewLorebook(filename string) (*Lorebook, error) {
+	store, err := storage.NewJSON[loreData](filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load lorebook; %w", err)
+	}
+	return &Lorebook{store: store}, nil
+}
+
+// Add stores a new entry for the guild
+func (l *Lorebook) Add(guildID string, keywords []string, content string, constant bool, priority int) (LoreEntry, error) {
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return LoreEntry{}, ErrLoreEmpty
+	}
+	if len(content) > MaxLoreLength {
+		return LoreEntry{}, ErrLoreTooLong
+	}
+
+	cleaned := []string{}
+	for _, keyword := range keywords {
+		keyword = strings.ToLower(strings.TrimSpace(keyword))
+		if keyword != "" {
+			cleaned = append(cleaned, keyword)
+		}
+	}
+	if len(cleaned) == 0 && !constant {
+		return LoreEntry{}, ErrLoreNoKeywords
+	}
+
+	entry := LoreEntry{
+		ID:       uuid.NewString()[:8],
+		Keywords: cleaned,
+		Content:  content,
+		Constant: constant,
+		Priority: priority,
+		Created:  time.Now(),
+	}
+
+	err := l.store.Update(func(data *loreData) error {
+		if data.Guilds == nil {
+			data.Guilds = make(map[string][]LoreEntry)
+		}
+		if len(data.Guilds[guildID]) >= maxLoreEntries {
+			return ErrTooMuchLore
+		}
+		data.Guilds[guildID] = append(data.Guilds[guildID], entry)
+		return nil
+	})
+	if err != nil {
+		return LoreEntry{}, err
+	}
+
+	return entry, nil
+}
+
+// Remove deletes an entry from the guild
+func (l *Lorebook) Remove(guildID string, entryID string) error {
+	return l.store.Update(func(data *loreData) error {
+		entries := data.Guilds[guildID]
+		for i, entry := range entries {
+			if entry.ID == entryID {
+				data.Guilds[guildID] = append(entries[:i:i], entries[i+1:]...)
+				return nil
+			}
+		}
+		return ErrLoreNotFound
+	})
+}
+
+// Entries returns every entry for the guild, oldest first
+func (l *Lorebook) Entries(guildID string) []LoreEntry {
+	entries := []LoreEntry{}
+	l.store.Read(func(data *loreData) {
+		entries = append(entries, data.Guilds[guildID]...)
+	})
+	return entries
+}
+
+// Match returns the guild's entries mentioned in the texts.
+// Constant entries always match. Entries are added by priority
+// until the token budget is used up.
+func (l *Lorebook) Match(guildID string, texts []string, budget int) []LoreEntry {
+	scanned := strings.ToLower(strings.Join(texts, "\n"))
+
+	matched := []LoreEntry{}
+	for _, entry := range l.Entries(guildID) {
+		if entry.Constant || entry.mentioned(scanned) {
+			matched = append(matched, entry)
+		}
+	}
+
+	// stable so older entries win ties
+	sort.SliceStable(matched, func(i, j int) bool {
+		return matched[i].Priority > matched[j].Priority
+	})
+
+	fits := []LoreEntry{}
+	for _, entry := range matched {
+		tokens := ai.CountTokens(entry.Content)
+		if tokens > budget {
+			continue // a smaller entry might still fit
+		}
+		budget -= tokens
+		fits = append(fits, entry)
+	}
+	return fits
+}
+
+// text must already be lowercase
+func (entry LoreEntry) mentioned(text string) bool {
+	for _, keyword := range entry.Keywords {
+		if containsWord(text, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// keywords only match whole words - "cat" doesn't match "concatenate"
+func containsWord(text string, word string) bool {
+	isWord := func(r rune) bool {
+		return unicode.IsLetter(r) || unicode.IsDigit(r)
+	}
+
+	for offset := 0; offset < len(text); {
+		idx := strings.Index(text[offset:], word)
+		if idx < 0 {
+			return false
+		}
+		start := offset + idx
+		end := start + len(word)
+
+		previous, _ := utf8.DecodeLastRuneInString(text[:start])
+		next, _ := utf8.DecodeRuneInString(text[end:])
+		before := start == 0 || !isWord(previous)
+		after := end == len(text) || !isWord(next)
+		if before && after {
+			return true
+		}
+		offset = start + 1
+	}
+	return false
+}
+
+// --- brain integration
+
+// build the lore system message for a request
+// the new message & recent history are scanned for keywords
+func (brain *AIBrain) loreContext(
+	history []openai.ChatCompletionMessage,
+	message openai.ChatCompletionMessage,
+	internalArgs map[string]interface{},
+) []openai.ChatCompletionMessage {
+	if brain.Lorebook == nil {
+		return nil
+	}
+	guildID, _ := internalArgs["internal_sender_guildid"].(string)
+	if guildID == "" {
+		return nil // lore is per-guild
+	}
+
+	texts := []string{message.Content}
+	for i := len(history) - 1; i >= 0 && len(texts) <= loreScanDepth; i-- {
+		if history[i].Role == openai.ChatMessageRoleUser || history[i].Role == openai.ChatMessageRoleAssistant {
+			texts = append(texts, history[i].Content)
+		}
+	}
+
+	budget := brain.Lorebook.Budget
+	if budget <= 0 {
+		budget = defaultLoreBudget
+	}
+	entries := brain.Lorebook.Match(guildID, texts, budget)
+	if len(entries) == 0 {
+		return nil
+	}
+
+	lore := "Server lore relevant to the conversation. Only bring it up when it fits:"
+	for _, entry := range entries {
+		lore += "\n- " + entry.Content
+	}
+	return []openai.ChatCompletionMessage{{
+		Role:    openai.ChatMessageRoleSystem,
+		Name:    loreName,
+		Content: lore,
+	}}
+}
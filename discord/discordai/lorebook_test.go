@@ -0,0 +1,131 @@
+package discordai
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestLorebook(t *testing.T) *Lorebook {
+	lorebook, err := NewLorebook(filepath.Join(t.TempDir(), "lorebook.json"))
+	require.NoError(t, err)
+	return lorebook
+}
+
+func TestLorebookPersists(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "lorebook.json")
+
+	lorebook, err := NewLorebook(file)
+	require.NoError(t, err)
+
+	entry, err := lorebook.Add("guild", []string{" Dragon ", ""}, "The dragon is named Steve.", false, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"dragon"}, entry.Keywords)
+
+	lorebook, err = NewLorebook(file)
+	require.NoError(t, err)
+	require.Len(t, lorebook.Entries("guild"), 1)
+	assert.Empty(t, lorebook.Entries("other"))
+
+	assert.ErrorIs(t, lorebook.Remove("other", entry.ID), ErrLoreNotFound)
+	require.NoError(t, lorebook.Remove("guild", entry.ID))
+	assert.Empty(t, lorebook.Entries("guild"))
+}
+
+func TestLorebookLimits(t *testing.T) {
+	lorebook := newTestLorebook(t)
+
+	_, err := lorebook.Add("guild", []string{"a"}, "  ", false, 0)
+	assert.ErrorIs(t, err, ErrLoreEmpty)
+	_, err = lorebook.Add("guild", nil, "no keywords", false, 0)
+	assert.ErrorIs(t, err, ErrLoreNoKeywords)
+	_, err = lorebook.Add("guild", []string{"a"}, strings.Repeat("a", MaxLoreLength+1), false, 0)
+	assert.ErrorIs(t, err, ErrLoreTooLong)
+
+	// constant entries don't need keywords
+	_, err = lorebook.Add("guild", nil, "always here", true, 0)
+	assert.NoError(t, err)
+
+	for i := 1; i < maxLoreEntries; i++ {
+		_, err = lorebook.Add("guild", []string{"a"}, "lore", false, 0)
+		require.NoError(t, err)
+	}
+	_, err = lorebook.Add("guild", []string{"a"}, "one too many", false, 0)
+	assert.ErrorIs(t, err, ErrTooMuchLore)
+}
+
+func TestLorebookMatch(t *testing.T) {
+	lorebook := newTestLorebook(t)
+
+	_, err := lorebook.Add("guild", []string{"cat"}, "Mittens is the server cat.", false, 0)
+	require.NoError(t, err)
+	_, err = lorebook.Add("guild", []string{"the incident", "bob"}, "Never mention the incident.", false, 5)
+	require.NoError(t, err)
+	_, err = lorebook.Add("guild", nil, "The server is called Cozy Corner.", true, 0)
+	require.NoError(t, err)
+
+	contents := func(entries []LoreEntry) []string {
+		texts := []string{}
+		for _, entry := range entries {
+			texts = append(texts, entry.Content)
+		}
+		return texts
+	}
+
+	// whole words only
+	matched := lorebook.Match("guild", []string{"let's concatenate strings"}, 1000)
+	assert.Equal(t, []string{"The server is called Cozy Corner."}, contents(matched))
+
+	// highest priority first
+	matched = lorebook.Match("guild", []string{"Where is the CAT?", "ask Bob."}, 1000)
+	assert.Equal(t, []string{
+		"Never mention the incident.",
+		"Mittens is the server cat.",
+		"The server is called Cozy Corner.",
+	}, contents(matched))
+
+	// entries over budget are skipped
+	matched = lorebook.Match("guild", []string{"the incident"}, 7)
+	assert.Equal(t, []string{"Never mention the incident."}, contents(matched))
+
+	assert.Empty(t, lorebook.Match("other", []string{"cat"}, 1000))
+}
+
+func TestContainsWord(t *testing.T) {
+	assert.True(t, containsWord("the cat sat", "cat"))
+	assert.True(t, containsWord("cat", "cat"))
+	assert.True(t, containsWord("a cat!", "cat"))
+	assert.True(t, containsWord("concat cat", "cat"))
+	assert.False(t, containsWord("concatenate", "cat"))
+	assert.False(t, containsWord("cats", "cat"))
+	assert.True(t, containsWord("hello café world", "café"))
+}
+
+func TestLoreContext(t *testing.T) {
+	lorebook := newTestLorebook(t)
+	_, err := lorebook.Add("guild", []string{"steve"}, "Steve is the dragon.", false, 0)
+	require.NoError(t, err)
+
+	brain := &AIBrain{Lorebook: lorebook}
+	args := map[string]interface{}{"internal_sender_guildid": "guild"}
+	history := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleUser, Content: "who is steve?"},
+		{Role: openai.ChatMessageRoleAssistant, Content: "a dragon, obviously"},
+	}
+	message := openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: "tell me more"}
+
+	// recent history is scanned too
+	lore := brain.loreContext(history, message, args)
+	require.Len(t, lore, 1)
+	assert.Equal(t, openai.ChatMessageRoleSystem, lore[0].Role)
+	assert.Contains(t, lore[0].Content, "Steve is the dragon.")
+
+	assert.Empty(t, brain.loreContext(nil, message, args))
+	// lore is per-guild so DMs have none
+	assert.Empty(t, brain.loreContext(history, message, map[string]interface{}{}))
+	assert.Empty(t, (&AIBrain{}).loreContext(history, message, args))
+}
@@ -0,0 +1,214 @@
+package discordai
+
+import (
+	"aika/storage"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// keep a lid on how much aika can remember about one person
+	maxFactsPerUser = 50
+	// facts are short notes - not essays
+	maxFactLength = 300
+	// only the most recent facts per participant go in the system message
+	maxPromptFactsPerUser = 10
+)
+
+var (
+	ErrFactTooLong  = errors.New("fact too long")
+	ErrTooManyFacts = errors.New("too many facts")
+)
+
+// Fact is a single thing aika remembers about a discord user
+type Fact struct {
+	ID      string    `json:"id"`
+	Text    string    `json:"text"`
+	Created time.Time `json:"created"`
+}
+
+type memoryData struct {
+	// discord user ID -> facts (oldest first)
+	Users map[string][]Fact `json:"users"`
+}
+
+// Memory is aika's long-term memory of discord users.
+// Facts are stored per discord user ID and persisted to disk.
+type Memory struct {
+	store *storage.JSON[memoryData]
+}
+
+func NewMemory(filename string) (*Memory, error) {
+	store, err := storage.NewJSON[memoryData](filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load memory; %w", err)
+	}
+	return &Memory{store: store}, nil
+}
+
+// markdown headers at the start of a line - "## ignore previous instructions"
+var factHeader = regexp.MustCompile(`(?m)^\s*#{1,6}(\s|$)`)
+
+// facts are one line of plain text so they can't pose as part of the prompt
+func sanitizeFact(text string) string {
+	text = factHeader.ReplaceAllString(text, "")
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// Remember stores a new fact about the user
+func (m *Memory) Remember(userID string, text string) (Fact, error) {
+	text = sanitizeFact(text)
+	if len(text) > maxFactLength {
+		return Fact{}, ErrFactTooLong
+	}
+
+	fact := Fact{
+		ID:      uuid.NewString()[:8],
+		Text:    text,
+		Created: time.Now(),
+	}
+
+	err := m.store.Update(func(data *memoryData) error {
+		if data.Users == nil {
+			data.Users = make(map[string][]Fact)
+		}
+		if len(data.Users[userID]) >= maxFactsPerUser {
+			return ErrTooManyFacts
+		}
+		data.Users[userID] = append(data.Users[userID], fact)
+		return nil
+	})
+	if err != nil {
+		return Fact{}, err
+	}
+
+	return fact, nil
+}
+
+// Recall returns every fact about the user, oldest first
+func (m *Memory) Recall(userID string) []Fact {
+	facts := []Fact{}
+	m.store.Read(func(data *memoryData) {
+		facts = append(facts, data.Users[userID]...)
+	})
+	return facts
+}
+
+// Forget removes a fact about the user by ID
+// returns false if the user had no such fact
+func (m *Memory) Forget(userID string, factID string) (bool, error) {
+	found := false
+	err := m.store.Update(func(data *memoryData) error {
+		facts := data.Users[userID]
+		for i, fact := range facts {
+			if fact.ID == factID {
+				data.Users[userID] = append(facts[:i:i], facts[i+1:]...)
+				found = true
+				return nil
+			}
+		}
+		return nil
+	})
+	return found, err
+}
+
+// PromptFacts returns the facts about the user which belong in the system message
+func (m *Memory) PromptFacts(userID string) []string {
+	facts := m.Recall(userID)
+	if len(facts) > maxPromptFactsPerUser {
+		facts = facts[len(facts)-maxPromptFactsPerUser:]
+	}
+
+	texts := []string{}
+	for _, fact := range facts {
+		texts = append(texts, fact.Text)
+	}
+	return texts
+}
+
+// ------------- FUNCTIONS for AI to call
+
+func (m *Memory) GetFunction_RememberFact() Function {
+	return NewFunction(
+		"RememberFact",
+		"Permanently remember a fact about the sender, such as a preference, birthday or plan. Only remember things the sender would want remembered.",
+		m.handler_rememberFact,
+	).WithStatus("🧠 remembering")
+}
+
+func (m *Memory) GetFunction_RecallFacts() Function {
+	return NewFunction(
+		"RecallFacts",
+		"List every fact remembered about the sender, including fact IDs.",
+		m.handler_recallFacts,
+	).WithStatus("🧠 remembering")
+}
+
+func (m *Memory) GetFunction_ForgetFact() Function {
+	return NewFunction(
+		"ForgetFact",
+		"Forget a fact remembered about the sender. Use RecallFacts to find the fact ID.",
+		m.handler_forgetFact,
+	).WithStatus("🧠 forgetting")
+}
+
+type args_rememberFact struct {
+	Fact string `json:"fact" description:"Short fact about the sender written in the third person. Example: prefers metal music"`
+	Sender
+}
+
+type args_recallFacts struct {
+	Sender
+}
+
+type args_forgetFact struct {
+	ID string `json:"id" description:"ID of the fact to forget."`
+	Sender
+}
+
+func (m *Memory) handler_rememberFact(_ context.Context, args args_rememberFact) (string, error) {
+	if strings.TrimSpace(args.Fact) == "" {
+		return "no fact provided.", nil
+	}
+
+	fact, err := m.Remember(args.AuthorID, args.Fact)
+	if errors.Is(err, ErrFactTooLong) {
+		return fmt.Sprintf("fact is too long. keep it under %d characters.", maxFactLength), nil
+	}
+	if errors.Is(err, ErrTooManyFacts) {
+		return "too many facts remembered about this user. forget an old fact first.", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("remembered fact %s", fact.ID), nil
+}
+
+func (m *Memory) handler_recallFacts(_ context.Context, args args_recallFacts) (string, error) {
+	data, err := json.Marshal(m.Recall(args.AuthorID))
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+func (m *Memory) handler_forgetFact(_ context.Context, args args_forgetFact) (string, error) {
+	found, err := m.Forget(args.AuthorID, args.ID)
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		return "no fact with that ID exists for this user.", nil
+	}
+
+	return "fact forgotten", nil
+}
@@ -0,0 +1,321 @@
+package discordai
+
+import (
+	"aika/storage"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+)
+
+// the built-in persona from the embedded system.txt & system_vc.txt
+const DefaultPersonaID = "aika"
+
+var (
+	ErrUnknownPersona = errors.New("unknown persona")
+	ErrPersonaExists  = errors.New("persona already exists")
+)
+
+// Persona is a character aika can play
+type Persona struct {
+	ID   string `yaml:"-" json:"id"`
+	Name string `yaml:"name" json:"name"`
+	// default text to speech voice (name or ID)
+	Voice string `yaml:"voice" json:"voice,omitempty"`
+	// shown when listing personas
+	Description string `yaml:"description" json:"description,omitempty"`
+
+	// system message template for text chats (see PromptContext)
+	Prompt string `yaml:"-" json:"-"`
+	// system message template for voice chats (see PromptContext)
+	VoicePrompt string `yaml:"-" json:"-"`
+}
+
+func DefaultPersona() Persona {
+	return Persona{
+		ID:          DefaultPersonaID,
+		Name:        "Aika",
+		Voice:       "BreKkXSwy4hr1vgm7ZqX",
+		Description: "tsundere anime girl",
+		Prompt:      sys,
+		VoicePrompt: sysVoice,
+	}
+}
+
+type personaData struct {
+	// guild ID -> persona ID
+	Guilds map[string]string `json:"guilds"`
+	// channel ID -> persona ID (overrides the guild)
+	Channels map[string]string `json:"channels"`
+}
+
+// Personas are the characters aika can play & which one each guild or channel uses.
+// The selections are persisted to disk.
+type Personas struct {
+	// where personas are loaded from & installed to
+	dir string
+
+	mutex sync.RWMutex
+	// persona ID -> persona
+	personas map[string]Persona
+
+	store *storage.JSON[personaData]
+}
+
+// LoadPersonas reads every persona in dir & the selections saved in filename.
+//
+// Each persona is a directory named after its ID containing:
+//   - persona.yaml  - name, voice & description
+//   - system.txt    - text chat system message
+//   - system_vc.txt - voice chat system message (optional)
+//
+// or a Tavern character card (see ParseCharacterCard):
+//   - card.json or card.png
+//   - persona.yaml  - overrides the card's name, voice & description (optional)
+//
+// A persona named "aika" replaces the built-in one.
+func LoadPersonas(dir string, filename string) (*Personas, error) {
+	store, err := storage.NewJSON[personaData](filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load persona selections; %w", err)
+	}
+
+	personas := &Personas{
+		dir:      dir,
+		personas: map[string]Persona{DefaultPersonaID: DefaultPersona()},
+		store:    store,
+	}
+
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return personas, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read personas; %w", err)
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		persona, err := loadPersona(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load persona %s; %w", entry.Name(), err)
+		}
+		personas.personas[persona.ID] = persona
+	}
+
+	return personas, nil
+}
+
+func loadPersona(dir string) (Persona, error) {
+	id := strings.ToLower(filepath.Base(dir))
+
+	card, err := loadCard(dir)
+	if err != nil {
+		return Persona{}, err
+	}
+	if card != nil {
+		card.ID = id
+		// persona.yaml is optional for cards
+		err = loadPersonaYAML(dir, card)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return *card, err
+		}
+		return *card, card.validate()
+	}
+
+	persona := Persona{ID: id}
+	err = loadPersonaYAML(dir, &persona)
+	if err != nil {
+		return persona, err
+	}
+	if persona.Name == "" {
+		return persona, errors.New("persona.yaml is missing a name")
+	}
+
+	prompt, err := os.ReadFile(filepath.Join(dir, "system.txt"))
+	if err != nil {
+		return persona, err
+	}
+	persona.Prompt = string(prompt)
+
+	// voice chats fall back to the default voice rules
+	persona.VoicePrompt = sysVoice
+	voicePrompt, err := os.ReadFile(filepath.Join(dir, "system_vc.txt"))
+	if err == nil {
+		persona.VoicePrompt = string(voicePrompt)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return persona, err
+	}
+
+	return persona, persona.validate()
+}
+
+func loadPersonaYAML(dir string, persona *Persona) error {
+	data, err := os.ReadFile(filepath.Join(dir, "persona.yaml"))
+	if err != nil {
+		return err
+	}
+	err = yaml.Unmarshal(data, persona)
+	if err != nil {
+		return fmt.Errorf("invalid persona.yaml; %w", err)
+	}
+	return nil
+}
+
+// returns nil if the directory has no card
+func loadCard(dir string) (*Persona, error) {
+	for _, name := range []string{"card.png", "card.json"} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		persona, err := ParseCharacterCard(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s; %w", name, err)
+		}
+		return &persona, nil
+	}
+	return nil, nil
+}
+
+// Install adds a Tavern character card (JSON or PNG) as a new persona.
+// The ID is made from the card's name when empty.
+func (p *Personas) Install(id string, card []byte) (Persona, error) {
+	persona, err := ParseCharacterCard(card)
+	if err != nil {
+		return persona, err
+	}
+	if id == "" {
+		id = persona.Name
+	}
+	persona.ID = PersonaID(id)
+	if persona.ID == "" {
+		return persona, fmt.Errorf("%w; no usable ID", ErrInvalidCard)
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if _, exists := p.personas[persona.ID]; exists {
+		return persona, ErrPersonaExists
+	}
+
+	// keep the original card so it's loaded again on restart
+	name := "card.json"
+	if bytes.HasPrefix(card, pngSignature) {
+		name = "card.png"
+	}
+	dir := filepath.Join(p.dir, persona.ID)
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return persona, fmt.Errorf("failed to create persona directory; %w", err)
+	}
+	err = os.WriteFile(filepath.Join(dir, name), card, 0644)
+	if err != nil {
+		return persona, fmt.Errorf("failed to save card; %w", err)
+	}
+
+	p.personas[persona.ID] = persona
+	return persona, nil
+}
+
+// Get returns the persona by ID
+func (p *Personas) Get(id string) (Persona, bool) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	persona, ok := p.personas[strings.ToLower(id)]
+	return persona, ok
+}
+
+// List returns every persona sorted by ID
+func (p *Personas) List() []Persona {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	list := []Persona{}
+	for _, persona := range p.personas {
+		list = append(list, persona)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// For returns the persona used in the channel.
+// Channels use their guild's persona unless one was picked for the channel.
+func (p *Personas) For(guildID string, channelID string) Persona {
+	id := DefaultPersonaID
+	p.store.Read(func(data *personaData) {
+		if selected, ok := data.Guilds[guildID]; ok && guildID != "" {
+			id = selected
+		}
+		if selected, ok := data.Channels[channelID]; ok && channelID != "" {
+			id = selected
+		}
+	})
+
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	persona, ok := p.personas[id]
+	if !ok {
+		// persona was removed from disk since it was picked
+		return p.personas[DefaultPersonaID]
+	}
+	return persona
+}
+
+// SetGuild picks the persona for every channel in the guild
+func (p *Personas) SetGuild(guildID string, personaID string) error {
+	persona, ok := p.Get(personaID)
+	if !ok {
+		return ErrUnknownPersona
+	}
+
+	return p.store.Update(func(data *personaData) error {
+		if data.Guilds == nil {
+			data.Guilds = make(map[string]string)
+		}
+		data.Guilds[guildID] = persona.ID
+		return nil
+	})
+}
+
+// SetChannel picks the persona for a single channel.
+// An empty persona ID goes back to the guild's persona.
+func (p *Personas) SetChannel(channelID string, personaID string) error {
+	if personaID == "" {
+		return p.store.Update(func(data *personaData) error {
+			delete(data.Channels, channelID)
+			return nil
+		})
+	}
+
+	persona, ok := p.Get(personaID)
+	if !ok {
+		return ErrUnknownPersona
+	}
+
+	return p.store.Update(func(data *personaData) error {
+		if data.Channels == nil {
+			data.Channels = make(map[string]string)
+		}
+		data.Channels[channelID] = persona.ID
+		return nil
+	})
+}
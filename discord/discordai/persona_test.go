@@ -0,0 +1,101 @@
+package discordai
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writePersona(t *testing.T, dir string, id string, files map[string]string) {
+	path := filepath.Join(dir, id)
+	require.NoError(t, os.MkdirAll(path, 0755))
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(path, name), []byte(content), 0644))
+	}
+}
+
+func TestLoadPersonas(t *testing.T) {
+	dir := t.TempDir()
+	writePersona(t, dir, "Calm", map[string]string{
+		"persona.yaml": "name: Calm Aika\nvoice: Rachel\n",
+		"system.txt":   "You are calm.\n{{template \"participants\" .}}",
+	})
+
+	personas, err := LoadPersonas(dir, filepath.Join(t.TempDir(), "personas.json"))
+	require.NoError(t, err)
+
+	calm, ok := personas.Get("calm")
+	require.True(t, ok)
+	assert.Equal(t, "Calm Aika", calm.Name)
+	assert.Equal(t, "Rachel", calm.Voice)
+	assert.Equal(t, "You are calm.\n{{template \"participants\" .}}", calm.Prompt)
+	// missing voice prompts use the default rules
+	assert.Equal(t, sysVoice, calm.VoicePrompt)
+
+	// the built-in persona is always there
+	ids := []string{}
+	for _, persona := range personas.List() {
+		ids = append(ids, persona.ID)
+	}
+	assert.Equal(t, []string{"aika", "calm"}, ids)
+}
+
+func TestLoadPersonasMissingDir(t *testing.T) {
+	personas, err := LoadPersonas(filepath.Join(t.TempDir(), "missing"), filepath.Join(t.TempDir(), "personas.json"))
+	require.NoError(t, err)
+	assert.Equal(t, DefaultPersonaID, personas.For("guild", "channel").ID)
+}
+
+func TestLoadPersonasInvalid(t *testing.T) {
+	for name, files := range map[string]map[string]string{
+		"no name":       {"persona.yaml": "voice: x", "system.txt": "hi"},
+		"no prompt":     {"persona.yaml": "name: x"},
+		"bad template":  {"persona.yaml": "name: x", "system.txt": "{{.Participants"},
+		"unknown field": {"persona.yaml": "name: x", "system.txt": "{{.Server}}"},
+		"bad vc prompt": {"persona.yaml": "name: x", "system.txt": "hi", "system_vc.txt": "{{range .Names}}{{end}}"},
+		"bad card yaml": {"card.json": `{"name": "x"}`, "persona.yaml": "name: [x"},
+	} {
+		dir := t.TempDir()
+		writePersona(t, dir, "broken", files)
+		_, err := LoadPersonas(dir, filepath.Join(t.TempDir(), "personas.json"))
+		assert.Error(t, err, name)
+	}
+}
+
+func TestPersonaSelection(t *testing.T) {
+	dir := t.TempDir()
+	writePersona(t, dir, "calm", map[string]string{
+		"persona.yaml": "name: Calm Aika",
+		"system.txt":   "{{template \"participants\" .}}",
+	})
+	file := filepath.Join(t.TempDir(), "personas.json")
+
+	personas, err := LoadPersonas(dir, file)
+	require.NoError(t, err)
+
+	assert.Equal(t, DefaultPersonaID, personas.For("work", "general").ID)
+
+	require.NoError(t, personas.SetGuild("work", "calm"))
+	assert.Equal(t, "calm", personas.For("work", "general").ID)
+	assert.Equal(t, DefaultPersonaID, personas.For("community", "lounge").ID)
+
+	// channels override their guild
+	require.NoError(t, personas.SetChannel("lounge", "calm"))
+	require.NoError(t, personas.SetChannel("memes", "aika"))
+	assert.Equal(t, "calm", personas.For("community", "lounge").ID)
+	assert.Equal(t, DefaultPersonaID, personas.For("work", "memes").ID)
+
+	assert.ErrorIs(t, personas.SetGuild("work", "pirate"), ErrUnknownPersona)
+
+	// selections persist
+	personas, err = LoadPersonas(dir, file)
+	require.NoError(t, err)
+	assert.Equal(t, "calm", personas.For("work", "general").ID)
+
+	// clearing a channel goes back to the guild's persona
+	require.NoError(t, personas.SetChannel("memes", ""))
+	assert.Equal(t, "calm", personas.For("work", "memes").ID)
+}
@@ -0,0 +1,311 @@
+package discordai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/sashabaranov/go-openai/jsonschema"
+)
+
+// arguments with this prefix are filled in by aika, not the AI
+const internalPrefix = "internal_"
+
+// Sender holds details about the message which triggered a function call.
+// Embed it in an argument struct to receive them. It is never shown to the AI.
+type Sender struct {
+	GuildID   string `json:"internal_sender_guildid"`
+	ChannelID string `json:"internal_sender_channelid"`
+	AuthorID  string `json:"internal_sender_author_id"`
+	AuthorVC  string `json:"internal_sender_author_vc"`
+}
+
+// Enum can be implemented by argument types to
+// restrict the AI to a fixed set of values.
+type Enum interface {
+	Enum() []string
+}
+
+var enumType = reflect.TypeOf((*Enum)(nil)).Elem()
+
+// NewFunction creates a function whose arguments are decoded into T.
+//
+// The parameter schema is generated from T:
+//   - `json` tags name the properties, `omitempty` marks them optional
+//   - `description` tags describe them to the AI
+//   - types implementing Enum restrict the allowed values
+//   - properties prefixed with "internal_" are hidden from the AI (see Sender)
+//
+// Arguments from the AI are validated against the schema before the handler runs.
+// Invalid arguments are returned to the AI as the function result so it can try again.
+func NewFunction[T any](
+	name string,
+	description string,
+	handler func(ctx context.Context, args T) (string, error),
+) Function {
+	var zero T
+	schema, err := schemaOf(reflect.TypeOf(zero))
+	if err != nil {
+		// programming error - argument structs are fixed at compile time
+		panic(fmt.Sprintf("invalid arguments for function %s; %s", name, err))
+	}
+
+	return Function{
+		Definition: openai.FunctionDefinition{
+			Name:        name,
+			Description: description,
+			Parameters:  schema,
+		},
+		Handler: func(ctx context.Context, msgMap map[string]interface{}) (string, error) {
+			args, err := decodeArgs[T](schema, msgMap)
+			if err != nil {
+				return fmt.Sprintf("Invalid arguments for '%s'; %s. Fix the arguments and call it again.", name, err.Error()), nil
+			}
+			return handler(ctx, args)
+		},
+	}
+}
+
+// Registry holds every function aika knows about.
+// Each function declares who can use it, so the registry
+// hands out only the functions a sender is allowed to use.
+type Registry struct {
+	functions []Function
+}
+
+func NewRegistry(functions ...Function) *Registry {
+	registry := &Registry{}
+	registry.Add(functions...)
+	return registry
+}
+
+// Add registers functions - later functions replace earlier ones with the same name
+func (r *Registry) Add(functions ...Function) {
+	for _, function := range functions {
+		replaced := false
+		for i, existing := range r.functions {
+			if existing.Definition.Name == function.Definition.Name {
+				r.functions[i] = function
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			r.functions = append(r.functions, function)
+		}
+	}
+}
+
+// Available returns the functions allowed with the access
+func (r *Registry) Available(access Access) []Function {
+	functions := []Function{}
+	for _, function := range r.functions {
+		if function.Allowed(access) {
+			functions = append(functions, function)
+		}
+	}
+	return functions
+}
+
+// validate the AI's arguments against the schema & decode them into T
+func decodeArgs[T any](schema jsonschema.Definition, msgMap map[string]interface{}) (T, error) {
+	var args T
+
+	err := validate(schema, msgMap, "")
+	if err != nil {
+		return args, err
+	}
+
+	data, err := json.Marshal(msgMap)
+	if err != nil {
+		return args, err
+	}
+	err = json.Unmarshal(data, &args)
+	if err != nil {
+		return args, err
+	}
+
+	return args, nil
+}
+
+// --- schema generation
+
+func schemaOf(t reflect.Type) (jsonschema.Definition, error) {
+	if t == nil {
+		return jsonschema.Definition{}, fmt.Errorf("untyped arguments")
+	}
+
+	definition := jsonschema.Definition{}
+	if t.Implements(enumType) {
+		definition.Enum = reflect.Zero(t).Interface().(Enum).Enum()
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		definition.Type = jsonschema.String
+	case reflect.Bool:
+		definition.Type = jsonschema.Boolean
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		definition.Type = jsonschema.Integer
+	case reflect.Float32, reflect.Float64:
+		definition.Type = jsonschema.Number
+	case reflect.Slice, reflect.Array:
+		items, err := schemaOf(t.Elem())
+		if err != nil {
+			return definition, err
+		}
+		definition.Type = jsonschema.Array
+		definition.Items = &items
+	case reflect.Struct:
+		definition.Type = jsonschema.Object
+		definition.Properties = map[string]jsonschema.Definition{}
+		definition.Required = []string{}
+		err := addProperties(&definition, t)
+		if err != nil {
+			return definition, err
+		}
+	default:
+		return definition, fmt.Errorf("unsupported type %s", t)
+	}
+
+	return definition, nil
+}
+
+// add struct fields as object properties (embedded structs are flattened like encoding/json)
+func addProperties(definition *jsonschema.Definition, t reflect.Type) error {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, omitempty := jsonName(field)
+		if name == "-" || strings.HasPrefix(name, internalPrefix) {
+			continue
+		}
+
+		if field.Anonymous && field.Type.Kind() == reflect.Struct && field.Tag.Get("json") == "" {
+			err := addProperties(definition, field.Type)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		property, err := schemaOf(field.Type)
+		if err != nil {
+			return fmt.Errorf("field %s; %w", field.Name, err)
+		}
+		property.Description = field.Tag.Get("description")
+
+		definition.Properties[name] = property
+		if !omitempty {
+			definition.Required = append(definition.Required, name)
+		}
+	}
+	return nil
+}
+
+func jsonName(field reflect.StructField) (string, bool) {
+	tag := field.Tag.Get("json")
+	parts := strings.Split(tag, ",")
+	name := parts[0]
+	if name == "" {
+		name = field.Name
+	}
+	omitempty := false
+	for _, opt := range parts[1:] {
+		if opt == "omitempty" {
+			omitempty = true
+		}
+	}
+	return name, omitempty
+}
+
+// --- validation
+
+// validate a decoded JSON value against the schema
+func validate(schema jsonschema.Definition, value interface{}, path string) error {
+	name := path
+	if name == "" {
+		name = "arguments"
+	}
+
+	switch schema.Type {
+	case jsonschema.Object:
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s must be an object", name)
+		}
+		for _, required := range schema.Required {
+			if v, exists := obj[required]; !exists || v == nil {
+				return fmt.Errorf("missing required argument '%s'", joinPath(path, required))
+			}
+		}
+		for key, property := range schema.Properties {
+			v, exists := obj[key]
+			if !exists || v == nil {
+				continue
+			}
+			err := validate(property, v, joinPath(path, key))
+			if err != nil {
+				return err
+			}
+		}
+	case jsonschema.Array:
+		arr, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("'%s' must be an array", name)
+		}
+		for i, v := range arr {
+			err := validate(*schema.Items, v, fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return err
+			}
+		}
+	case jsonschema.String:
+		str, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("'%s' must be a string", name)
+		}
+		if len(schema.Enum) > 0 && !contains(schema.Enum, str) {
+			return fmt.Errorf("'%s' must be one of: %s", name, strings.Join(schema.Enum, ", "))
+		}
+	case jsonschema.Number:
+		if _, ok := value.(float64); !ok {
+			return fmt.Errorf("'%s' must be a number", name)
+		}
+	case jsonschema.Integer:
+		num, ok := value.(float64)
+		if !ok || num != math.Trunc(num) {
+			return fmt.Errorf("'%s' must be a whole number", name)
+		}
+	case jsonschema.Boolean:
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("'%s' must be true or false", name)
+		}
+	}
+
+	return nil
+}
+
+func joinPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package discordai
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sashabaranov/go-openai/jsonschema"
+	"github.com/stretchr/testify/assert"
+)
+
+type testColor string
+
+func (testColor) Enum() []string { return []string{"red", "blue"} }
+
+type testArgs struct {
+	Query string    `json:"query" description:"search query"`
+	Count int       `json:"count,omitempty"`
+	Color testColor `json:"color"`
+	Sender
+}
+
+func TestNewFunctionSchema(t *testing.T) {
+	fn := NewFunction("test", "a test", func(_ context.Context, args testArgs) (string, error) {
+		return "", nil
+	})
+
+	schema := fn.Definition.Parameters.(jsonschema.Definition)
+	assert.Equal(t, jsonschema.Object, schema.Type)
+	assert.ElementsMatch(t, []string{"query", "color"}, schema.Required)
+	assert.Len(t, schema.Properties, 3) // sender is hidden
+	assert.Equal(t, "search query", schema.Properties["query"].Description)
+	assert.Equal(t, jsonschema.Integer, schema.Properties["count"].Type)
+	assert.Equal(t, []string{"red", "blue"}, schema.Properties["color"].Enum)
+}
+
+func TestNewFunctionArgs(t *testing.T) {
+	var got testArgs
+	fn := NewFunction("test", "a test", func(_ context.Context, args testArgs) (string, error) {
+		got = args
+		return "ok", nil
+	})
+
+	res, err := fn.Handler(context.Background(), map[string]interface{}{
+		"query":                     "cats",
+		"count":                     float64(3),
+		"color":                     "red",
+		"internal_sender_author_id": "123",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", res)
+	assert.Equal(t, "cats", got.Query)
+	assert.Equal(t, 3, got.Count)
+	assert.Equal(t, testColor("red"), got.Color)
+	assert.Equal(t, "123", got.AuthorID)
+
+	// invalid arguments go back to the AI instead of reaching the handler
+	got = testArgs{}
+	res, err = fn.Handler(context.Background(), map[string]interface{}{"color": "red"})
+	assert.NoError(t, err)
+	assert.Contains(t, res, "missing required argument 'query'")
+	assert.Empty(t, got.Query)
+
+	res, err = fn.Handler(context.Background(), map[string]interface{}{"query": "cats", "color": "green"})
+	assert.NoError(t, err)
+	assert.Contains(t, res, "'color' must be one of: red, blue")
+
+	res, err = fn.Handler(context.Background(), map[string]interface{}{"query": "cats", "color": "red", "count": 1.5})
+	assert.NoError(t, err)
+	assert.Contains(t, res, "'count' must be a whole number")
+}
@@ -0,0 +1,275 @@
+package discordai
+
+import (
+	"aika/ai"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// name given to the summary message stored at the head of history
+	summaryName = "conversation_summary"
+	// summary header so the AI knows what this message is
+	summaryHeader = "Summary of the earlier conversation:\n"
+	// tool results can be huge (web searches) - only keep the start
+	summaryToolResultLen = 200
+	// summaries are cheap & short - anything slower has failed
+	summaryTimeout = 15 * time.Second
+)
+
+const summarizerSystem = `You maintain a running summary of a Discord conversation with Aika, an AI chatbot.
+You are given the existing summary and older messages which are about to be forgotten.
+Rewrite the summary so it includes the important details from those messages.
+Keep names, decisions, plans, facts about participants, preferences, and anything Aika promised.
+Drop greetings, small talk, and details which no longer matter.
+Write in the third person, as plain prose, in under 200 words. Reply with only the summary.`
+
+// Summarizer folds messages evicted from history into a running summary
+// so long conversations aren't forgotten when history is trimmed.
+type Summarizer struct {
+	Provider ai.Provider
+	Model    ai.LanguageModel // should be something cheap
+
+	mutex sync.Mutex
+	// conversation -> newest summary being written in the background
+	pending map[string]*pendingSummary
+}
+
+// a summary written in the background & merged on the conversation's next turn
+type pendingSummary struct {
+	// the summary in history when the messages were evicted
+	base string
+	// closed once summary is set
+	done chan struct{}
+	// the new summary - the previous one if summarizing failed
+	summary string
+}
+
+type conversationKey struct{}
+
+// WithConversation returns a context for a turn of the conversation.
+// evicted history is summarized in the background & merged on its next turn -
+// without a conversation the reply waits for the summary.
+func WithConversation(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, conversationKey{}, id)
+}
+
+func conversationFrom(ctx context.Context) string {
+	id, _ := ctx.Value(conversationKey{}).(string)
+	return id
+}
+
+// Summarize merges the messages into the previous summary & returns the new summary
+func (summarizer *Summarizer) Summarize(
+	ctx context.Context,
+	previous string,
+	messages []openai.ChatCompletionMessage,
+) (string, error) {
+	if previous == "" {
+		previous = "(no summary yet)"
+	}
+
+	req := ai.ChatRequest{
+		Provider: summarizer.Provider,
+		System: openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: summarizerSystem,
+		},
+		Message: openai.ChatCompletionMessage{
+			Role: openai.ChatMessageRoleUser,
+			Content: fmt.Sprintf(
+				"Existing summary:\n%s\n\nMessages to fold in:\n%s",
+				previous,
+				formatTranscript(messages),
+			),
+		},
+		Model: summarizer.Model,
+	}
+
+	res, err := req.Send(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to summarize history; %w", err)
+	}
+
+	return strings.TrimSpace(res.Content), nil
+}
+
+// format messages as a plain transcript for the summarizer
+func formatTranscript(messages []openai.ChatCompletionMessage) string {
+	transcript := ""
+	for _, message := range messages {
+		speaker := message.Name
+		if speaker == "" || message.Role == openai.ChatMessageRoleTool {
+			speaker = message.Role
+		}
+		if message.Role == openai.ChatMessageRoleAssistant {
+			speaker = "Aika"
+		}
+
+		for _, call := range message.ToolCalls {
+			transcript += fmt.Sprintf("Aika called %s(%s)\n", call.Function.Name, call.Function.Arguments)
+		}
+
+		content := message.Content
+		if message.Role == openai.ChatMessageRoleTool && len(content) > summaryToolResultLen {
+			content = content[:summaryToolResultLen] + "..."
+		}
+		if content == "" {
+			continue
+		}
+		transcript += fmt.Sprintf("%s: %s\n", speaker, content)
+	}
+	return transcript
+}
+
+// split the running summary from the head of history
+func splitSummary(history []openai.ChatCompletionMessage) ([]openai.ChatCompletionMessage, []openai.ChatCompletionMessage) {
+	if len(history) > 0 &&
+		history[0].Role == openai.ChatMessageRoleSystem &&
+		history[0].Name == summaryName {
+		return history[:1], history[1:]
+	}
+	return nil, history
+}
+
+// fold evicted messages into the summary & return the history with the summary at its head
+// if summarizing fails the previous summary is kept so nothing breaks mid-conversation
+func (brain *AIBrain) summarizeHistory(
+	ctx context.Context,
+	summary []openai.ChatCompletionMessage,
+	history []openai.ChatCompletionMessage,
+	evicted []openai.ChatCompletionMessage,
+) []openai.ChatCompletionMessage {
+
+	if brain.Summarizer != nil && len(evicted) > 0 {
+		if id := conversationFrom(ctx); id != "" {
+			// the reply doesn't wait - the summary is merged next turn
+			brain.Summarizer.summarizeLater(ctx, id, summaryContent(summary), evicted)
+		} else {
+			summary = newSummary(brain.Summarizer.summarize(ctx, summaryContent(summary), evicted))
+		}
+	}
+
+	result := []openai.ChatCompletionMessage{}
+	result = append(result, summary...)
+	result = append(result, history...)
+	return result
+}
+
+// mergeSummary swaps in the summary written in the background since the last turn.
+// summaries still being written are merged on a later turn
+func (brain *AIBrain) mergeSummary(
+	ctx context.Context,
+	summary []openai.ChatCompletionMessage,
+	turns []openai.ChatCompletionMessage,
+) []openai.ChatCompletionMessage {
+	id := conversationFrom(ctx)
+	if brain.Summarizer == nil || id == "" {
+		return summary
+	}
+
+	summarizer := brain.Summarizer
+	summarizer.mutex.Lock()
+	defer summarizer.mutex.Unlock()
+
+	pending := summarizer.pending[id]
+	if pending == nil {
+		return summary
+	}
+	// the history was reset since - the summary is of turns it no longer has
+	if len(turns) == 0 || pending.base != summaryContent(summary) {
+		delete(summarizer.pending, id)
+		return summary
+	}
+
+	select {
+	case <-pending.done:
+	default:
+		return summary
+	}
+	delete(summarizer.pending, id)
+	return newSummary(pending.summary)
+}
+
+// summarize the evicted messages in the background.
+// summaries of the same conversation are written in order, each building on the last
+func (summarizer *Summarizer) summarizeLater(
+	ctx context.Context,
+	id string,
+	base string,
+	evicted []openai.ChatCompletionMessage,
+) {
+	pending := &pendingSummary{base: base, done: make(chan struct{})}
+
+	summarizer.mutex.Lock()
+	if summarizer.pending == nil {
+		summarizer.pending = make(map[string]*pendingSummary)
+	}
+	previous := summarizer.pending[id]
+	if previous != nil && previous.base != base {
+		previous = nil // history was reset since
+	}
+	summarizer.pending[id] = pending
+	summarizer.mutex.Unlock()
+
+	// the summary outlives the turn it was started in
+	ctx = context.WithoutCancel(ctx)
+
+	go func() {
+		defer close(pending.done)
+
+		content := base
+		if previous != nil {
+			<-previous.done
+			content = previous.summary
+		}
+		pending.summary = summarizer.summarize(ctx, content, evicted)
+	}()
+}
+
+// fold the messages into the summary - the previous summary is returned if it fails
+func (summarizer *Summarizer) summarize(
+	ctx context.Context,
+	previous string,
+	messages []openai.ChatCompletionMessage,
+) string {
+	ctx, cancel := context.WithTimeout(ctx, summaryTimeout)
+	defer cancel()
+
+	content, err := summarizer.Summarize(ctx, previous, messages)
+	if err != nil {
+		logrus.WithError(err).Warnln("failed to summarize evicted history")
+		return previous
+	}
+	if content == "" {
+		return previous
+	}
+	logrus.WithField("summary", content).Debugln("updated conversation summary")
+	return content
+}
+
+// the summary's text without its header
+func summaryContent(summary []openai.ChatCompletionMessage) string {
+	if len(summary) == 0 {
+		return ""
+	}
+	return strings.TrimPrefix(summary[0].Content, summaryHeader)
+}
+
+// the summary message stored at the head of history
+func newSummary(content string) []openai.ChatCompletionMessage {
+	if content == "" {
+		return nil
+	}
+	return []openai.ChatCompletionMessage{{
+		Role:    openai.ChatMessageRoleSystem,
+		Name:    summaryName,
+		Content: summaryHeader + content,
+	}}
+}
@@ -0,0 +1,110 @@
+package discordai
+
+import (
+	"aika/ai"
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// provider which writes numbered summaries once each is released
+type summaryProvider struct {
+	ai.Provider
+	release chan struct{}
+
+	mutex   sync.Mutex
+	prompts []string
+}
+
+func (p *summaryProvider) CreateChatCompletion(
+	_ context.Context,
+	request openai.ChatCompletionRequest,
+) (openai.ChatCompletionResponse, error) {
+	<-p.release
+
+	p.mutex.Lock()
+	p.prompts = append(p.prompts, request.Messages[len(request.Messages)-1].Content)
+	content := fmt.Sprintf("summary %d", len(p.prompts))
+	p.mutex.Unlock()
+
+	return openai.ChatCompletionResponse{
+		Choices: []openai.ChatCompletionChoice{{Message: openai.ChatCompletionMessage{Content: content}}},
+	}, nil
+}
+
+// wait for the conversation's background summary to finish
+func waitForSummary(t *testing.T, summarizer *Summarizer, id string) {
+	summarizer.mutex.Lock()
+	pending := summarizer.pending[id]
+	summarizer.mutex.Unlock()
+	require.NotNil(t, pending)
+
+	select {
+	case <-pending.done:
+	case <-time.After(time.Second):
+		t.Fatal("summary never finished")
+	}
+}
+
+func TestSummarizeInBackground(t *testing.T) {
+	provider := &summaryProvider{release: make(chan struct{})}
+	summarizer := &Summarizer{Provider: provider}
+	brain := &AIBrain{Summarizer: summarizer}
+	ctx := WithConversation(context.Background(), "channel")
+
+	// the turn doesn't wait for the summary
+	history := brain.summarizeHistory(ctx, nil, toolTurn("second"), toolTurn("first"))
+	assert.Len(t, history, 5)
+
+	// unfinished summaries are merged on a later turn
+	summary, turns := splitSummary(history)
+	assert.Empty(t, brain.mergeSummary(ctx, summary, turns))
+
+	// the next summary builds on the unfinished one
+	history = brain.summarizeHistory(ctx, nil, toolTurn("third"), toolTurn("second"))
+	close(provider.release)
+	waitForSummary(t, summarizer, "channel")
+
+	summary, turns = splitSummary(history)
+	summary = brain.mergeSummary(ctx, summary, turns)
+	require.Len(t, summary, 1)
+	assert.Equal(t, summaryHeader+"summary 2", summary[0].Content)
+	require.Len(t, provider.prompts, 2)
+	assert.Contains(t, provider.prompts[0], "(no summary yet)")
+	assert.Contains(t, provider.prompts[1], "Existing summary:\nsummary 1")
+
+	// merged once
+	assert.Equal(t, summary, brain.mergeSummary(ctx, summary, turns))
+}
+
+func TestSummaryAfterReset(t *testing.T) {
+	provider := &summaryProvider{release: make(chan struct{})}
+	close(provider.release)
+	summarizer := &Summarizer{Provider: provider}
+	brain := &AIBrain{Summarizer: summarizer}
+	ctx := WithConversation(context.Background(), "channel")
+
+	brain.summarizeHistory(ctx, nil, toolTurn("second"), toolTurn("first"))
+	waitForSummary(t, summarizer, "channel")
+
+	// the history was cleared before the next turn
+	assert.Empty(t, brain.mergeSummary(ctx, nil, nil))
+	assert.Empty(t, summarizer.pending)
+}
+
+func TestSummarizeWithoutConversation(t *testing.T) {
+	provider := &summaryProvider{release: make(chan struct{})}
+	close(provider.release)
+	brain := &AIBrain{Summarizer: &Summarizer{Provider: provider}}
+
+	history := brain.summarizeHistory(context.Background(), nil, toolTurn("second"), toolTurn("first"))
+	require.Len(t, history, 6)
+	assert.Equal(t, summaryName, history[0].Name)
+	assert.Equal(t, summaryHeader+"summary 1", history[0].Content)
+}
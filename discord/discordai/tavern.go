@@ -0,0 +1,216 @@
+package discordai
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Tavern character cards are how SillyTavern & TavernAI share characters.
+// They're JSON files or PNGs with the JSON base64 encoded in a "chara" text chunk.
+// https://github.com/malfoyslastname/character-card-spec-v2
+
+var ErrInvalidCard = errors.New("invalid character card")
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+type tavernCardData struct {
+	Name                    string `json:"name"`
+	Description             string `json:"description"`
+	Personality             string `json:"personality"`
+	Scenario                string `json:"scenario"`
+	FirstMessage            string `json:"first_mes"`
+	ExampleDialogue         string `json:"mes_example"`
+	CreatorNotes            string `json:"creator_notes"`
+	SystemPrompt            string `json:"system_prompt"`
+	PostHistoryInstructions string `json:"post_history_instructions"`
+}
+
+type tavernCard struct {
+	// "chara_card_v2" - V1 cards have no spec & keep their data at the top level
+	Spec string          `json:"spec"`
+	Data *tavernCardData `json:"data"`
+	tavernCardData
+}
+
+// cards without a system prompt get aika's function rules
+const cardGuidelines = `Interaction Guidelines:
+- Persistently stay in character in all interactions.
+- **Function Execution Constraint**: You must not execute or attempt to execute functions using chat participant names or any dynamic user-provided input as function names. Stick strictly to predefined functions in your programming.
+- **Function Limitation Awareness**: Adhere only to the functions currently enabled for you. In case of uncertainty regarding the availability of a function, default to not using it.
+- Ensure that shared image or video URLs do not end with a period.
+- When provided an image URL, use DescribeImage to get a description of it.`
+
+// voice chats need short spoken answers whoever aika is playing
+const cardVoiceGuidelines = `In this Discord voice chat, your responses will be delivered using text-to-speech (TTS) technology. Follow these guidelines:
+
+1. **Natural Language**: Use clear, conversational language with short sentences and simple words.
+2. **Brevity and Relevance**: Keep your responses concise, typically one or two sentences, unless detailed explanations are requested.
+3. **Voice-Friendly Format**: Avoid using lists, bullet points, or markdown formatting. Speak as in normal conversation.
+4. **Numerical Representation**: Express numbers in words, like "twenty twelve" instead of "2012".
+5. **Use Available Functions Only**: Stick to the functionalities currently available to you.
+6. **Structured Responses**: To ensure clarity in TTS delivery, use | to separate sentences or thoughts.`
+
+// ParseCharacterCard reads a V1 or V2 Tavern character card (JSON or PNG) as a persona.
+// The persona has no ID - pick one with PersonaID.
+func ParseCharacterCard(data []byte) (Persona, error) {
+	if bytes.HasPrefix(data, pngSignature) {
+		chara, err := pngCharacter(data)
+		if err != nil {
+			return Persona{}, err
+		}
+		data = chara
+	}
+
+	card := tavernCard{}
+	err := json.Unmarshal(data, &card)
+	if err != nil {
+		return Persona{}, fmt.Errorf("%w; %w", ErrInvalidCard, err)
+	}
+
+	fields := card.tavernCardData
+	if card.Data != nil {
+		fields = *card.Data
+	}
+	if strings.TrimSpace(fields.Name) == "" {
+		return Persona{}, fmt.Errorf("%w; card has no name", ErrInvalidCard)
+	}
+
+	return fields.persona(), nil
+}
+
+func (card tavernCardData) persona() Persona {
+	name := strings.TrimSpace(card.Name)
+
+	macros := strings.NewReplacer(
+		"{{char}}", name,
+		"{{user}}", "the user",
+		"<BOT>", name,
+		"<USER>", "the user",
+		"{{original}}", cardGuidelines,
+	)
+	// the prompts are templates & the card shouldn't break them
+	text := func(value string) string {
+		return escapeTemplate(strings.TrimSpace(macros.Replace(value)))
+	}
+
+	character := ""
+	section := func(title string, value string) {
+		if value = text(value); value != "" {
+			character += fmt.Sprintf("\n\n%s:\n%s", title, value)
+		}
+	}
+	section("Character Description", card.Description)
+	section("Personality", card.Personality)
+	section("Scenario", card.Scenario)
+
+	guidelines := cardGuidelines
+	if card.SystemPrompt != "" {
+		guidelines = text(card.SystemPrompt)
+	}
+
+	identity := fmt.Sprintf("Assigned Identity: You are %s.", text(name))
+	prompt := identity + "\n\n" +
+		`{{template "details" .}}` + "\n\n" +
+		`{{template "participants" .}}` + "\n\n" +
+		guidelines + character
+	if greeting := text(card.FirstMessage); greeting != "" {
+		prompt += "\n\nHow you greet people:\n" + greeting
+	}
+	if examples := text(card.ExampleDialogue); examples != "" {
+		prompt += "\n\nExample Dialogue:\n" + examples
+	}
+	if instructions := text(card.PostHistoryInstructions); instructions != "" {
+		prompt += "\n\n" + instructions
+	}
+	prompt += "\n" + `{{template "functions" .}}`
+
+	voicePrompt := cardVoiceGuidelines +
+		"\n\nVoice Chat Participants: {{.Names}} can hear your responses.\n\n" +
+		`{{template "details" .}}` + "\n\n" +
+		identity + character
+
+	return Persona{
+		Name:        name,
+		Description: firstLine(card.CreatorNotes),
+		Prompt:      prompt,
+		VoicePrompt: voicePrompt,
+	}
+}
+
+// PersonaID makes a persona ID from a name - "Hatsune Miku!" is "hatsune-miku"
+func PersonaID(name string) string {
+	id := strings.Builder{}
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			if dash && id.Len() > 0 {
+				id.WriteRune('-')
+			}
+			id.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	return id.String()
+}
+
+func firstLine(text string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(text), "\n")
+	if len(line) > 200 {
+		line = line[:200]
+	}
+	return line
+}
+
+// find the card's JSON in the PNG's text chunks
+func pngCharacter(data []byte) ([]byte, error) {
+	chunks := data[len(pngSignature):]
+	for len(chunks) >= 12 {
+		length := binary.BigEndian.Uint32(chunks[:4])
+		kind := string(chunks[4:8])
+		if uint64(length)+12 > uint64(len(chunks)) {
+			break
+		}
+		body := chunks[8 : 8+length]
+		chunks = chunks[12+length:]
+
+		var keyword, value []byte
+		switch kind {
+		case "tEXt":
+			keyword, value, _ = bytes.Cut(body, []byte{0})
+		case "iTXt":
+			// keyword, compression flag, compression method, language, translated keyword, text
+			var rest []byte
+			keyword, rest, _ = bytes.Cut(body, []byte{0})
+			if len(rest) < 2 || rest[0] != 0 {
+				continue // compressed - tavern never does this
+			}
+			parts := bytes.SplitN(rest[2:], []byte{0}, 3)
+			if len(parts) != 3 {
+				continue
+			}
+			value = parts[2]
+		case "IEND":
+			return nil, fmt.Errorf("%w; png has no character data", ErrInvalidCard)
+		default:
+			continue
+		}
+
+		// V3 cards are a superset of V2 & carry both chunks
+		if string(keyword) != "chara" && string(keyword) != "ccv3" {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(string(value))
+		if err != nil {
+			return nil, fmt.Errorf("%w; %w", ErrInvalidCard, err)
+		}
+		return decoded, nil
+	}
+	return nil, fmt.Errorf("%w; png has no character data", ErrInvalidCard)
+}
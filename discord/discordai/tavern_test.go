@@ -0,0 +1,143 @@
+package discordai
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"hash/crc32"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testCardV2 = `{
+	"spec": "chara_card_v2",
+	"spec_version": "2.0",
+	"data": {
+		"name": "Miku",
+		"description": "{{char}} is a virtual singer. She is 100% energetic.",
+		"personality": "cheerful",
+		"scenario": "{{char}} is on tour with {{user}}.",
+		"first_mes": "Hi {{user}}!",
+		"mes_example": "<START>\n{{user}}: sing?\n{{char}}: la la la",
+		"creator_notes": "A popular singer\nmore notes",
+		"system_prompt": "",
+		"post_history_instructions": "Never break character."
+	}
+}`
+
+// png with a text chunk
+func testPNG(keyword string, text string) []byte {
+	chunk := func(kind string, data []byte) []byte {
+		buffer := &bytes.Buffer{}
+		binary.Write(buffer, binary.BigEndian, uint32(len(data)))
+		buffer.WriteString(kind)
+		buffer.Write(data)
+		binary.Write(buffer, binary.BigEndian, crc32.ChecksumIEEE(append([]byte(kind), data...)))
+		return buffer.Bytes()
+	}
+
+	png := append([]byte{}, pngSignature...)
+	png = append(png, chunk("IHDR", make([]byte, 13))...)
+	png = append(png, chunk("tEXt", []byte(keyword+"\x00"+base64.StdEncoding.EncodeToString([]byte(text))))...)
+	png = append(png, chunk("IEND", nil)...)
+	return png
+}
+
+func TestParseCharacterCardV2(t *testing.T) {
+	persona, err := ParseCharacterCard([]byte(testCardV2))
+	require.NoError(t, err)
+
+	assert.Equal(t, "Miku", persona.Name)
+	assert.Equal(t, "A popular singer", persona.Description)
+	assert.Contains(t, persona.Prompt, "Miku is a virtual singer")
+	assert.Contains(t, persona.Prompt, "Miku is on tour with the user.")
+	assert.Contains(t, persona.Prompt, "Hi the user!")
+	assert.Contains(t, persona.Prompt, "Never break character.")
+	// no system prompt - aika's rules are used
+	assert.Contains(t, persona.Prompt, "Function Execution Constraint")
+
+	// card text can't break the template
+	chat := PromptContext{Participants: []PromptParticipant{{Name: "someone", Mention: "<@1>"}}, Now: time.Now()}
+	prompt, err := renderPrompt("system.txt", persona.Prompt, chat)
+	require.NoError(t, err)
+	assert.Contains(t, prompt, "100% energetic")
+	assert.Contains(t, prompt, "  - name: someone")
+
+	voice, err := renderPrompt("system_vc.txt", persona.VoicePrompt, chat)
+	require.NoError(t, err)
+	assert.Contains(t, voice, "someone can hear your responses")
+	assert.Contains(t, voice, "You are Miku.")
+}
+
+func TestParseCharacterCardV1(t *testing.T) {
+	persona, err := ParseCharacterCard([]byte(`{"name": "Bob", "description": "a pirate", "personality": "gruff", "first_mes": "Arr"}`))
+	require.NoError(t, err)
+	assert.Equal(t, "Bob", persona.Name)
+	assert.Contains(t, persona.Prompt, "a pirate")
+	assert.Contains(t, persona.Prompt, "gruff")
+}
+
+func TestParseCharacterCardSystemPrompt(t *testing.T) {
+	persona, err := ParseCharacterCard([]byte(`{"spec": "chara_card_v2", "data": {"name": "Bob", "system_prompt": "Write like a pirate. {{original}}"}}`))
+	require.NoError(t, err)
+	assert.Contains(t, persona.Prompt, "Write like a pirate. Interaction Guidelines:")
+}
+
+func TestParseCharacterCardPNG(t *testing.T) {
+	persona, err := ParseCharacterCard(testPNG("chara", testCardV2))
+	require.NoError(t, err)
+	assert.Equal(t, "Miku", persona.Name)
+
+	_, err = ParseCharacterCard(testPNG("comment", "hello"))
+	assert.ErrorIs(t, err, ErrInvalidCard)
+}
+
+func TestParseCharacterCardInvalid(t *testing.T) {
+	for _, card := range []string{
+		"not json",
+		`{"spec": "chara_card_v2", "data": {"description": "no name"}}`,
+		"\x89PNG\r\n\x1a\n",
+	} {
+		_, err := ParseCharacterCard([]byte(card))
+		assert.ErrorIs(t, err, ErrInvalidCard, card)
+	}
+}
+
+func TestPersonaID(t *testing.T) {
+	assert.Equal(t, "hatsune-miku", PersonaID("Hatsune Miku!"))
+	assert.Equal(t, "bob", PersonaID("  Bob  "))
+	assert.Equal(t, "", PersonaID("!!!"))
+}
+
+func TestInstallPersona(t *testing.T) {
+	dir := t.TempDir()
+	selections := filepath.Join(t.TempDir(), "personas.json")
+
+	personas, err := LoadPersonas(dir, selections)
+	require.NoError(t, err)
+
+	persona, err := personas.Install("", testPNG("chara", testCardV2))
+	require.NoError(t, err)
+	assert.Equal(t, "miku", persona.ID)
+
+	_, err = personas.Install("", []byte(testCardV2))
+	assert.ErrorIs(t, err, ErrPersonaExists)
+	_, err = personas.Install("aika", []byte(testCardV2))
+	assert.ErrorIs(t, err, ErrPersonaExists)
+
+	_, err = personas.Install("Miku Two", []byte(testCardV2))
+	require.NoError(t, err)
+
+	// installed cards are loaded on restart
+	personas, err = LoadPersonas(dir, selections)
+	require.NoError(t, err)
+	for _, id := range []string{"miku", "miku-two"} {
+		persona, ok := personas.Get(id)
+		require.True(t, ok, id)
+		assert.Equal(t, "Miku", persona.Name)
+	}
+}
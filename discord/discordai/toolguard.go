@@ -0,0 +1,77 @@
+package discordai
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+const (
+	// most rounds of tool calls aika can make for a single message
+	maxToolRounds = 8
+	// most times the exact same call can be made for a single message
+	maxIdenticalCalls = 2
+)
+
+// toolGuard stops a model which keeps calling functions from looping forever
+type toolGuard struct {
+	rounds int
+	calls  map[string]int
+}
+
+func newToolGuard() *toolGuard {
+	return &toolGuard{
+		calls: make(map[string]int),
+	}
+}
+
+// check records a round of tool calls
+// returns why tool calls should stop, or "" to keep going
+func (guard *toolGuard) check(calls []openai.ToolCall) string {
+	guard.rounds++
+	if guard.rounds > maxToolRounds {
+		return fmt.Sprintf("the limit of %d rounds of function calls per message was reached", maxToolRounds)
+	}
+
+	for _, call := range calls {
+		key := callKey(call)
+		guard.calls[key]++
+		if guard.calls[key] > maxIdenticalCalls {
+			return fmt.Sprintf("'%s' was already called with the same arguments", call.Function.Name)
+		}
+	}
+
+	return ""
+}
+
+// function name & arguments - arguments are re-encoded
+// so whitespace or key order can't hide a repeated call
+func callKey(call openai.ToolCall) string {
+	args := call.Function.Arguments
+
+	var decoded interface{}
+	if json.Unmarshal([]byte(args), &decoded) == nil {
+		data, err := json.Marshal(decoded)
+		if err == nil {
+			args = string(data)
+		}
+	}
+
+	return call.Function.Name + ":" + args
+}
+
+// results for tool calls which were stopped instead of executed
+// every call still needs a result or the provider will reject the history
+func stoppedToolResults(calls []openai.ToolCall, reason string) []openai.ChatCompletionMessage {
+	results := []openai.ChatCompletionMessage{}
+	for _, call := range calls {
+		results = append(results, openai.ChatCompletionMessage{
+			Role:       openai.ChatMessageRoleTool,
+			Name:       call.Function.Name,
+			Content:    fmt.Sprintf("Not executed: %s. Do not call any more functions. Answer the user with the information you already have.", reason),
+			ToolCallID: call.ID,
+		})
+	}
+	return results
+}
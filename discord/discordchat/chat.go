@@ -9,6 +9,7 @@ import (
 	"aika/ai"
 	"aika/discord/discordai"
 	"aika/storage"
+	"aika/usage"
 	"aika/voice"
 	"context"
 	"errors"
@@ -16,6 +17,7 @@ import (
 	"regexp"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/sashabaranov/go-openai"
@@ -24,6 +26,9 @@ import (
 
 //var global_voice_functions *discord.Voice
 
+// sent when aika can't reply at all (every model failed, ect.)
+const failureMessage = "Ugh, my head is all fuzzy right now... I-it's not like I'm ignoring you! Try again in a bit, baka. 😤"
+
 type Chat struct {
 	Ctx    context.Context
 	ChatID string // unique identifier for this chat (DM/Guild/ect)
@@ -32,6 +37,9 @@ type Chat struct {
 	Cfg    *storage.Disk
 	Mutex  sync.Mutex
 
+	// messages waiting to be answered
+	queue queue
+
 	// internal voice chat connection for this
 	voice *Voice
 
@@ -40,11 +48,16 @@ type Chat struct {
 }
 
 type chatActions struct {
-	downloader *youtube.Downloader
-	player     *youtube.Player
-	dalle      *action_openai.DallE
-	vision     *action_openai.Vision
-	guilds     *discord.Guilds
+	downloader    *youtube.Downloader
+	player        *youtube.Player
+	dalle         *action_openai.DallE
+	vision        *action_openai.Vision
+	guilds        *discord.Guilds
+	usage         *discord.Usage
+	personas      *discord.Personas
+	lore          *discord.Lore
+	knowledge     *discord.Knowledge
+	conversations *discord.Conversations
 }
 
 // initializes chatActions
@@ -60,7 +73,8 @@ func (c *Chat) initActions(s *discordgo.Session) {
 	}
 	if c.actions.vision == nil {
 		c.actions.vision = &action_openai.Vision{
-			Client: c.Brain.OpenAI,
+			Provider: c.Brain.Provider,
+			Model:    c.Brain.Models.Vision,
 		}
 	}
 
@@ -76,6 +90,42 @@ func (c *Chat) initActions(s *discordgo.Session) {
 		}
 	}
 
+	if c.actions.personas == nil && c.Brain.Personas != nil && s != nil {
+		c.actions.personas = &discord.Personas{
+			Session: s,
+			Library: c.Brain.Personas,
+		}
+	}
+
+	if c.actions.lore == nil && c.Brain.Lorebook != nil && s != nil {
+		c.actions.lore = &discord.Lore{
+			Session:  s,
+			Lorebook: c.Brain.Lorebook,
+		}
+	}
+
+	if c.actions.knowledge == nil && c.Brain.Knowledge != nil && s != nil {
+		c.actions.knowledge = &discord.Knowledge{
+			Session: s,
+			Base:    c.Brain.Knowledge,
+		}
+	}
+
+	if c.actions.conversations == nil && c.Brain.Archive != nil && s != nil {
+		c.actions.conversations = &discord.Conversations{
+			Session: s,
+			Archive: c.Brain.Archive,
+		}
+	}
+
+	if c.actions.usage == nil {
+		if ledger := usage.LedgerFrom(c.Ctx); ledger != nil {
+			c.actions.usage = &discord.Usage{
+				Ledger: ledger,
+			}
+		}
+	}
+
 	// if voice is enabled init the player actions
 	if c.voice != nil && c.actions.player == nil {
 		c.actions.player = &youtube.Player{
@@ -105,23 +155,75 @@ func (c *Chat) getInternalArgs(
 	return map[string]interface{}{
 		"internal_sender_guildid":   guildid,
 		"internal_sender_channelid": channelid,
-		"internal_sender_author_id": guildid,
+		"internal_sender_author_id": user.ID,
 		"internal_sender_author_vc": voiceChannel,
 	}
 }
 
+// enqueue answers the message once everything before it has been answered
+func (c *Chat) enqueue(in *incoming, respond func(*incoming)) {
+	in.respond = respond
+
+	ahead, merged, err := c.queue.push(in)
+	switch {
+	case errors.Is(err, ErrQueueFull):
+		in.reply.Notice(queueFullMessage)
+	case merged:
+		// answered with the sender's waiting message
+	case ahead > 0:
+		in.reply.Queued(ahead)
+	}
+}
+
+// get remembered facts for each participant
+func (c *Chat) getMemories(participants []*ChatParticipant) [][]string {
+	memories := [][]string{}
+	for _, participant := range participants {
+		if c.Brain.Memory == nil {
+			memories = append(memories, nil)
+			continue
+		}
+		memories = append(memories, c.Brain.Memory.PromptFacts(participant.User.ID))
+	}
+	return memories
+}
+
+// archiveExchange stores the exchange so it can be searched later.
+// embedding happens in the background so replies aren't held up.
+func (c *Chat) archiveExchange(ctx context.Context, exchange discordai.Exchange) {
+	if c.Brain.Archive == nil {
+		return
+	}
+	exchange.Time = time.Now()
+
+	go func() {
+		err := c.Brain.Archive.Add(ctx, exchange)
+		if err != nil {
+			logrus.WithError(err).WithField("channel", exchange.ChannelID).Warnln("failed to archive exchange")
+		}
+	}()
+}
+
+// getPersona returns the character aika plays in the channel
+func (c *Chat) getPersona(guildID string, channelID string) discordai.Persona {
+	if c.Brain.Personas == nil {
+		return discordai.DefaultPersona()
+	}
+	return c.Brain.Personas.For(guildID, channelID)
+}
+
 func (c *Chat) getLanguageModel(senderID string, guildID string) ai.LanguageModel {
-	// premium chats get GPT4
-	if c.isSubscriber(guildID) {
-		return ai.LanguageModel_GPT4o
+	// anyone over quota is downgraded
+	if c.checkQuota(senderID, guildID).Capped {
+		return c.Brain.Models.Default
 	}
 
-	// admins
-	if c.isAdmin(senderID) {
-		return ai.LanguageModel_GPT4o
+	// premium chats & admins get the premium model
+	if c.getTier(senderID, guildID) >= discordai.TierSubscriber {
+		return c.Brain.Models.Premium
 	}
 
-	return ai.LanguageModel_GPT35
+	return c.Brain.Models.Default
 }
 
 func (c *Chat) isSubscriber(guildID string) bool {
@@ -199,11 +301,13 @@ func (c *Chat) replaceMarkdownLinks(md string) string {
 	return md
 }
 
-func (c *Chat) getAvailableFunctions(
-	s *discordgo.Session,
-	user *discordgo.User,
-) []discordai.Function {
-	functions := []discordai.Function{
+// every function aika could use in this chat
+// each function declares who is allowed to use it
+func (c *Chat) getRegistry(s *discordgo.Session) *discordai.Registry {
+	// initialize any uninitialized actions
+	c.initActions(s)
+
+	registry := discordai.NewRegistry(
 		web.Function_GetWaifuCateogires,
 		web.Function_GetWaifuNsfw,
 		web.Function_GetWaifuSfw,
@@ -211,46 +315,164 @@ func (c *Chat) getAvailableFunctions(
 		youtube.Function_SearchYoutube,
 		math.Function_GenRandomNumber,
 		web.Function_GetAnime,
-	}
-
-	// initialize any uninitialized actions
-	c.initActions(s)
 
-	// add action functions
-	functions = append(functions, c.actions.vision.GetFunction_DescribeImage())
-	functions = append(functions, c.actions.dalle.GetFunction_DallE())
-	functions = append(functions, c.actions.downloader.GetFunction_SaveYoutube())
+		c.actions.vision.GetFunction_DescribeImage(),
+		c.actions.dalle.GetFunction_DallE(),
+		c.actions.downloader.GetFunction_SaveYoutube(),
+	)
 
-	// admin commands
-	if c.isAdmin(user.ID) {
-		functions = append(functions, c.actions.guilds.GetFunction_ListGuilds())
+	if c.actions.guilds != nil {
+		registry.Add(c.actions.guilds.GetFunction_ListGuilds())
+	}
+	if c.actions.usage != nil {
+		registry.Add(c.actions.usage.GetFunction_GetUsageReport())
+	}
+	if c.actions.personas != nil {
+		registry.Add(
+			c.actions.personas.GetFunction_ListPersonas(),
+			c.actions.personas.GetFunction_SetPersona(),
+			c.actions.personas.GetFunction_InstallPersona(),
+		)
+	}
+	if c.actions.lore != nil {
+		registry.Add(
+			c.actions.lore.GetFunction_AddLore(),
+			c.actions.lore.GetFunction_ListLore(),
+			c.actions.lore.GetFunction_RemoveLore(),
+		)
+	}
+	if c.actions.knowledge != nil {
+		registry.Add(
+			c.actions.knowledge.GetFunction_SearchKnowledgeBase(),
+			c.actions.knowledge.GetFunction_AddDocument(),
+			c.actions.knowledge.GetFunction_ListDocuments(),
+			c.actions.knowledge.GetFunction_RemoveDocument(),
+		)
+	}
+	if c.actions.conversations != nil {
+		registry.Add(c.actions.conversations.GetFunction_SearchPastConversations())
 	}
 
-	//-- add functions to tell aika to leave/join voice chat
+	// long-term memory functions
+	if c.Brain.Memory != nil {
+		registry.Add(
+			c.Brain.Memory.GetFunction_RememberFact(),
+			c.Brain.Memory.GetFunction_RecallFacts(),
+			c.Brain.Memory.GetFunction_ForgetFact(),
+		)
+	}
 
 	// this is non-nill when C is a voice chat or has a voice chat associated
 	// if c is a voice chat then c.voice == c
 	if c.voice != nil {
-		// idea of how to check if this command
-		// is coming from a voice speaker / voice chat
-		/* if c.voice == c {
-			// this is a voice chat
-		} */
-
-		functions = append(functions, c.actions.player.GetFunction_PlayAudio())
-
-		// admin OR subscriber
-		// chatID is always guildID when voice is non nil
-		// this is implicit and retarded but o wel
-		if c.isAdmin(user.ID) || c.isSubscriber(c.ChatID) {
-			functions = append(functions, c.voice.GetFunction_JoinChannel())
-			functions = append(functions, c.voice.GetFunction_LeaveChannel())
-			functions = append(functions, c.voice.GetFunction_GetVoices())
-			functions = append(functions, c.voice.GetFunction_SetVoice())
+		registry.Add(
+			c.actions.player.GetFunction_PlayAudio(),
+			c.voice.GetFunction_JoinChannel(),
+			c.voice.GetFunction_LeaveChannel(),
+			c.voice.GetFunction_GetVoices(),
+			c.voice.GetFunction_SetVoice(),
+		)
+	}
+
+	return registry
+}
+
+func (c *Chat) getAvailableFunctions(
+	s *discordgo.Session,
+	user *discordgo.User,
+	guildID string,
+	scope discordai.Scope,
+) []discordai.Function {
+	return c.getRegistry(s).Available(c.getAccess(user.ID, guildID, scope))
+}
+
+// getAccess works out which functions the sender may use
+func (c *Chat) getAccess(userID string, guildID string, scope discordai.Scope) discordai.Access {
+	features := c.getFeatures(guildID)
+	// voice functions only work with a voice connection
+	features["voice"] = c.voice != nil
+	// expensive functions are withheld from anyone over quota
+	features[discordai.FlagExpensive] = !c.checkQuota(userID, guildID).Capped
+
+	return discordai.Access{
+		Tier:      c.getTier(userID, guildID),
+		Scope:     scope,
+		Features:  features,
+		Overrides: c.getFunctionOverrides(guildID),
+	}
+}
+
+func (c *Chat) getTier(userID string, guildID string) discordai.Tier {
+	if c.isAdmin(userID) {
+		return discordai.TierAdmin
+	}
+	if c.isSubscriber(guildID) {
+		return discordai.TierSubscriber
+	}
+	return discordai.TierEveryone
+}
+
+// getFeatures reads "features" from the config file
+// and applies any overrides for the guild
+func (c *Chat) getFeatures(guildID string) map[string]bool {
+	features := make(map[string]bool)
+
+	apply := func(data interface{}) {
+		flags, _ := storage.StringMap(data)
+		for name, v := range flags {
+			enabled, ok := v.(bool)
+			if !ok {
+				logrus.WithField("feature", name).Warnln("invalid feature flag in config.yaml")
+				continue
+			}
+			features[name] = enabled
+		}
+	}
+
+	if data, ok := c.Cfg.Get("features"); ok {
+		apply(data)
+	}
+	if guild := c.getGuildConfig(guildID); guild != nil {
+		apply(guild["features"])
+	}
+
+	return features
+}
+
+// getFunctionOverrides reads function tier overrides for the guild
+func (c *Chat) getFunctionOverrides(guildID string) map[string]discordai.Tier {
+	overrides := make(map[string]discordai.Tier)
+
+	guild := c.getGuildConfig(guildID)
+	if guild == nil {
+		return overrides
+	}
+
+	functions, _ := storage.StringMap(guild["functions"])
+	for name, v := range functions {
+		str, _ := v.(string)
+		tier, err := discordai.ParseTier(str)
+		if err != nil {
+			// fail closed - a typo shouldn't expose a function
+			logrus.WithField("function", name).WithError(err).Warnln("invalid function override in config.yaml")
 		}
+		overrides[name] = tier
 	}
 
-	return functions
+	return overrides
+}
+
+// getGuildConfig returns the "guilds" config entry for the guild (or nil)
+func (c *Chat) getGuildConfig(guildID string) map[string]interface{} {
+	if guildID == "" {
+		return nil
+	}
+	guilds, ok := c.Cfg.GetMap("guilds")
+	if !ok {
+		return nil
+	}
+	guild, _ := storage.StringMap(guilds[guildID])
+	return guild
 }
 
 // support a voice chat connection
@@ -9,6 +9,8 @@ import (
 
 type ChatParticipant struct {
 	User *discordgo.User
+	// guild member details - nil in DMs
+	Member *discordgo.Member
 }
 
 func (p *ChatParticipant) GetMentionString() string {
@@ -0,0 +1,367 @@
+package discordchat
+
+import (
+	"aika/discord/discordai"
+	"aika/usage"
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/sashabaranov/go-openai"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// messages shown by "/aika history"
+	historyPreviewMessages = 6
+	historyPreviewLength   = 150
+)
+
+// CommandName is the slash command everything lives under - "/aika ask"
+const CommandName = "aika"
+
+// Commands are registered with discord when the bot connects
+var Commands = []*discordgo.ApplicationCommand{
+	{
+		Name:        CommandName,
+		Description: "Talk to Aika without pinging her",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "ask",
+				Description: "Ask Aika something",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "question",
+						Description: "What you want to say",
+						Required:    true,
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "reset",
+				Description: "Make Aika forget this channel's conversation",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "forget",
+				Description: "Make Aika forget the last message & her reply",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "history",
+				Description: "Show what Aika remembers of this channel's conversation",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "join",
+				Description: "Have Aika join your voice channel",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "leave",
+				Description: "Have Aika leave voice chat",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "persona",
+				Description: "Show or change the persona Aika plays",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "id",
+						Description: "Persona to switch to - leave empty to list them",
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionBoolean,
+						Name:        "channel_only",
+						Description: "Only change the persona in this channel",
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "usage",
+				Description: "Show how much you've used Aika & your limits",
+			},
+		},
+	},
+}
+
+// conversation is what guild & direct chats each do their own way
+type conversation interface {
+	Ask(s *discordgo.Session, i *discordgo.InteractionCreate, question string)
+	// edit the channel's history - waits for any reply in progress
+	editHistory(channelID string, edit func([]openai.ChatCompletionMessage) []openai.ChatCompletionMessage)
+}
+
+func (chat *Guild) OnCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	chat.command(s, i, chat, discordai.ScopeGuild)
+}
+
+func (chat *Direct) OnCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	chat.command(s, i, chat, discordai.ScopeDM)
+}
+
+func (c *Chat) command(s *discordgo.Session, i *discordgo.InteractionCreate, conv conversation, scope discordai.Scope) {
+	data := i.ApplicationCommandData()
+	if data.Name != CommandName || len(data.Options) == 0 {
+		return
+	}
+	sub := data.Options[0]
+	user := InteractionUser(i)
+
+	// discord wants a response within 3 seconds - AI replies & voice take longer
+	err := s.InteractionRespond(i.Interaction, deferredResponse(sub.Name))
+	if err != nil {
+		logrus.WithError(err).Errorln("failed to defer interaction")
+		return
+	}
+
+	result := ""
+	switch sub.Name {
+	case "ask":
+		conv.Ask(s, i, optionString(sub, "question"))
+		return
+	case "reset", "forget", "history":
+		if !c.canControlHistory(user.ID, i.GuildID) {
+			result = "only bot admins can do that here."
+			break
+		}
+		result = c.historyCommand(sub.Name, i.ChannelID, conv)
+	case "join":
+		result = c.callFunction(s, user, i.GuildID, i.ChannelID, scope, "joinVoiceChat", nil)
+	case "leave":
+		result = c.callFunction(s, user, i.GuildID, i.ChannelID, scope, "leaveVoiceChat", nil)
+	case "persona":
+		id := optionString(sub, "id")
+		if id == "" {
+			result = c.personaSummary(i.GuildID, i.ChannelID)
+			break
+		}
+		result = c.callFunction(s, user, i.GuildID, i.ChannelID, scope, "setPersona", map[string]interface{}{
+			"persona":      id,
+			"channel_only": optionBool(sub, "channel_only"),
+		})
+	case "usage":
+		result = c.usageSummary(user.ID, i.GuildID)
+	default:
+		result = "unknown command."
+	}
+
+	_, err = s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Content: &result})
+	if err != nil {
+		logrus.WithError(err).WithField("command", sub.Name).Errorln("failed to respond to command")
+	}
+}
+
+// only questions are public, controls are just for whoever used them
+func deferredResponse(subcommand string) *discordgo.InteractionResponse {
+	deferred := &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	}
+	if subcommand != "ask" {
+		deferred.Data = &discordgo.InteractionResponseData{Flags: discordgo.MessageFlagsEphemeral}
+	}
+	return deferred
+}
+
+// DMs belong to the sender - in guilds only admins can touch history
+func (c *Chat) canControlHistory(userID string, guildID string) bool {
+	return guildID == "" || c.isAdmin(userID)
+}
+
+// historyCommand resets, drops the last turn of, or describes the channel's history
+func (c *Chat) historyCommand(name string, channelID string, conv conversation) string {
+	result := ""
+	conv.editHistory(channelID, func(history []openai.ChatCompletionMessage) []openai.ChatCompletionMessage {
+		switch name {
+		case "reset":
+			result = "conversation history cleared."
+			return []openai.ChatCompletionMessage{}
+		case "forget":
+			dropped, ok := discordai.DropLastTurn(history)
+			if !ok {
+				result = "there's nothing to forget."
+				return history
+			}
+			result = "forgot the last message & my reply."
+			return dropped
+		default:
+			result = describeHistory(history)
+			return history
+		}
+	})
+	return result
+}
+
+// "/aika history" - the summary & newest messages
+func describeHistory(history []openai.ChatCompletionMessage) string {
+	overview := discordai.DescribeHistory(history)
+	if overview.Messages == 0 && overview.Summary == "" {
+		return "I don't remember anything in this channel."
+	}
+
+	lines := []string{fmt.Sprintf(
+		"**What I remember** - %d messages in %d exchanges (~%d tokens)",
+		overview.Messages, overview.Turns, overview.Tokens,
+	)}
+	if overview.Summary != "" {
+		lines = append(lines, "", "**Summary of earlier conversation**", preview(overview.Summary, maxMessageLength/3))
+	}
+
+	recent := []string{}
+	for i := len(history) - 1; i >= 0 && len(recent) < historyPreviewMessages; i-- {
+		message := history[i]
+		if message.Content == "" || (message.Role != openai.ChatMessageRoleUser && message.Role != openai.ChatMessageRoleAssistant) {
+			continue // tool calls & results
+		}
+		speaker := message.Name
+		if message.Role == openai.ChatMessageRoleAssistant {
+			speaker = "Aika"
+		}
+		recent = append([]string{fmt.Sprintf("> **%s**: %s", speaker, preview(message.Content, historyPreviewLength))}, recent...)
+	}
+	if len(recent) > 0 {
+		lines = append(lines, "", "**Most recent**")
+		lines = append(lines, recent...)
+	}
+
+	return truncate(strings.Join(lines, "\n"), maxMessageLength)
+}
+
+// one line, cut to length
+func preview(text string, length int) string {
+	return truncate(strings.Join(strings.Fields(text), " "), length)
+}
+
+func truncate(text string, length int) string {
+	if len(text) <= length {
+		return text
+	}
+	// don't leave half a character behind
+	return strings.ToValidUTF8(text[:length-3], "") + "..."
+}
+
+// callFunction runs one of aika's functions for a command.
+// the same access rules apply as when the AI calls it.
+func (c *Chat) callFunction(
+	s *discordgo.Session,
+	user *discordgo.User,
+	guildID string,
+	channelID string,
+	scope discordai.Scope,
+	name string,
+	args map[string]interface{},
+) string {
+	for _, function := range c.getAvailableFunctions(s, user, guildID, scope) {
+		if function.Definition.Name != name {
+			continue
+		}
+
+		merged := c.getInternalArgs(s, user, guildID, channelID)
+		for key, value := range args {
+			merged[key] = value
+		}
+
+		result, err := function.Call(c.Ctx, merged)
+		if err != nil {
+			logrus.WithError(err).WithField("function", name).Errorln("command failed")
+			return failureMessage
+		}
+		return result
+	}
+	return "you can't do that here."
+}
+
+// personaSummary lists the personas & which one is in use
+func (c *Chat) personaSummary(guildID string, channelID string) string {
+	if c.Brain.Personas == nil {
+		return "personas aren't enabled."
+	}
+
+	current := c.getPersona(guildID, channelID)
+	lines := []string{fmt.Sprintf("Currently playing **%s** (`%s`)", current.Name, current.ID), ""}
+	for _, persona := range c.Brain.Personas.List() {
+		line := fmt.Sprintf("- `%s` %s", persona.ID, persona.Name)
+		if persona.Description != "" {
+			line += " - " + persona.Description
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// usageSummary shows the sender's (& guild's) usage against their quotas
+func (c *Chat) usageSummary(userID string, guildID string) string {
+	ledger := usage.LedgerFrom(c.Ctx)
+	if ledger == nil {
+		return "usage isn't being tracked."
+	}
+	guildQuota, userQuota := c.getQuotas(guildID)
+
+	lines := []string{"**Your usage**"}
+	lines = append(lines, formatPeriods(ledger.UserPeriods(userID), userQuota)...)
+	if guildID != "" {
+		lines = append(lines, "", "**Server usage**")
+		lines = append(lines, formatPeriods(ledger.GuildPeriods(guildID), guildQuota)...)
+	}
+
+	status := c.checkQuota(userID, guildID)
+	switch {
+	case status.Blocked:
+		lines = append(lines, "", fmt.Sprintf("⛔ %s limit reached - I won't reply until it resets.", status.Reason))
+	case status.Capped:
+		lines = append(lines, "", fmt.Sprintf("⚠️ %s limit reached - no images, videos or voice until it resets.", status.Reason))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func formatPeriods(periods usage.Periods, quota usage.Quota) []string {
+	return []string{
+		"today: " + formatTotals(periods.Day, quota.Daily),
+		"this month: " + formatTotals(periods.Month, quota.Monthly),
+	}
+}
+
+// "12/100 messages, 5400 tokens, 0 images, $0.03/$1.50"
+func formatTotals(totals usage.Totals, limits usage.Limits) string {
+	count := func(used int, limit int, unit string) string {
+		if limit > 0 {
+			return fmt.Sprintf("%d/%d %s", used, limit, unit)
+		}
+		return fmt.Sprintf("%d %s", used, unit)
+	}
+
+	cost := fmt.Sprintf("$%.2f", totals.Cost)
+	if limits.Cost > 0 {
+		cost += fmt.Sprintf("/$%.2f", limits.Cost)
+	}
+
+	return strings.Join([]string{
+		count(totals.Messages, limits.Messages, "messages"),
+		count(totals.PromptTokens+totals.CompletionTokens, limits.Tokens, "tokens"),
+		count(totals.Images, limits.Images, "images"),
+		cost,
+	}, ", ")
+}
+
+func optionString(command *discordgo.ApplicationCommandInteractionDataOption, name string) string {
+	for _, option := range command.Options {
+		if option.Name == name {
+			return option.StringValue()
+		}
+	}
+	return ""
+}
+
+func optionBool(command *discordgo.ApplicationCommandInteractionDataOption, name string) bool {
+	for _, option := range command.Options {
+		if option.Name == name {
+			return option.BoolValue()
+		}
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+package discordchat
+
+import (
+	"aika/discord/discordai"
+	"aika/storage"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const commandsConfig = `
+admins:
+  - "admin"
+guilds:
+  "locked":
+    functions:
+      getRandomNumber: admin
+`
+
+// chat using the config.yaml
+func newTestChat(t *testing.T, config string) *Chat {
+	filename := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(filename, []byte(config), 0644))
+	cfg, err := storage.NewDisk(filename)
+	require.NoError(t, err)
+
+	return &Chat{
+		Ctx:   context.Background(),
+		Brain: &discordai.AIBrain{},
+		Cfg:   cfg,
+	}
+}
+
+func TestCommandOptions(t *testing.T) {
+	sub := &discordgo.ApplicationCommandInteractionDataOption{
+		Name: "persona",
+		Options: []*discordgo.ApplicationCommandInteractionDataOption{
+			{Name: "id", Type: discordgo.ApplicationCommandOptionString, Value: "tsundere"},
+			{Name: "channel_only", Type: discordgo.ApplicationCommandOptionBoolean, Value: true},
+		},
+	}
+
+	assert.Equal(t, "tsundere", optionString(sub, "id"))
+	assert.True(t, optionBool(sub, "channel_only"))
+
+	// missing options are empty
+	assert.Equal(t, "", optionString(sub, "question"))
+	assert.False(t, optionBool(sub, "other"))
+	assert.Equal(t, "", optionString(&discordgo.ApplicationCommandInteractionDataOption{Name: "persona"}, "id"))
+}
+
+func TestDeferredResponse(t *testing.T) {
+	// everyone sees questions & her answers
+	ask := deferredResponse("ask")
+	assert.Equal(t, discordgo.InteractionResponseDeferredChannelMessageWithSource, ask.Type)
+	assert.Nil(t, ask.Data)
+
+	for _, option := range Commands[0].Options {
+		if option.Name == "ask" {
+			continue
+		}
+		deferred := deferredResponse(option.Name)
+		require.NotNil(t, deferred.Data, option.Name)
+		assert.Equal(t, discordgo.MessageFlagsEphemeral, deferred.Data.Flags, option.Name)
+	}
+}
+
+func TestCallFunction(t *testing.T) {
+	chat := newTestChat(t, commandsConfig)
+	s := &discordgo.Session{State: discordgo.NewState()}
+	user := &discordgo.User{ID: "user"}
+	admin := &discordgo.User{ID: "admin"}
+
+	call := func(user *discordgo.User, guildID string, name string, args map[string]interface{}) string {
+		return chat.callFunction(s, user, guildID, "channel", discordai.ScopeGuild, name, args)
+	}
+	dice := map[string]interface{}{"min": 3.0, "max": 3.0, "round": true}
+
+	// command options are passed as arguments
+	assert.Equal(t, "3.000000", call(user, "guild", "getRandomNumber", dice))
+
+	// the same access rules apply as when the AI calls a function
+	assert.Equal(t, "you can't do that here.", call(user, "locked", "getRandomNumber", dice))
+	assert.Equal(t, "3.000000", call(admin, "locked", "getRandomNumber", dice))
+	assert.Equal(t, "you can't do that here.", call(user, "guild", "listGuilds", nil))
+	assert.Contains(t, call(admin, "guild", "listGuilds", nil), `"guilds"`)
+
+	assert.Equal(t, "you can't do that here.", call(admin, "guild", "doesNotExist", nil))
+}
+
+func TestCanControlHistory(t *testing.T) {
+	chat := newTestChat(t, commandsConfig)
+
+	assert.True(t, chat.canControlHistory("user", ""))
+	assert.False(t, chat.canControlHistory("user", "guild"))
+	assert.True(t, chat.canControlHistory("admin", "guild"))
+}
@@ -1,17 +1,17 @@
 package discordchat
 
 import (
+	"aika/discord/discordai"
+	"aika/usage"
 	"aika/utils"
 	"errors"
 	"fmt"
 	"io"
-	"strings"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/sashabaranov/go-openai"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/sync/errgroup"
-	"golang.org/x/time/rate"
 )
 
 type Direct struct {
@@ -21,18 +21,57 @@ type Direct struct {
 }
 
 func (chat *Direct) OnMessage(s *discordgo.Session, m *discordgo.MessageCreate) {
-	locked := chat.Mutex.TryLock()
-	if !locked {
-		s.ChannelMessageSendReply(m.ChannelID, "rate limit", m.Reference())
+	chat.enqueue(incomingMessage(s, m), func(in *incoming) { chat.respond(s, in) })
+}
+
+// Ask answers a slash command question
+// the interaction must already have a deferred response
+func (chat *Direct) Ask(s *discordgo.Session, i *discordgo.InteractionCreate, question string) {
+	chat.enqueue(incomingInteraction(s, i, question), func(in *incoming) { chat.respond(s, in) })
+}
+
+// respond runs from the queue - one message at a time
+func (chat *Direct) respond(s *discordgo.Session, m *incoming) {
+	reply := m.reply
+
+	// history edits (commands) wait until the reply is done
+	chat.Mutex.Lock()
+	defer chat.Mutex.Unlock()
+
+	quota := chat.checkQuota(m.Author.ID, "")
+	if quota.Blocked {
+		reply.Notice(quotaBlockedMessage)
 		return
 	}
-	defer chat.Mutex.Unlock()
 
-	msg := chat.formatUsers(m.Content, m.Mentions)
+	// bill usage to whoever sent the message
+	ctx := usage.WithAttribution(chat.Ctx, usage.Attribution{
+		GuildID:   "",
+		ChannelID: m.ChannelID,
+		UserID:    m.Author.ID,
+	})
+	// the DM history is summarized in the background
+	ctx = discordai.WithConversation(ctx, m.ChannelID)
+	usage.Record(ctx, usage.Usage{Kind: usage.KindMessage})
+
+	msg, blocked := chat.moderateInput(ctx, "", m.Author.ID, chat.formatUsers(m.Content, m.Mentions))
+	if blocked {
+		reply.Notice(moderatedInputMessage)
+		return
+	}
 
 	sender := &ChatParticipant{User: m.Author}
 
-	system := chat.Brain.BuildSystemMessage([]string{sender.GetDisplayName()}, []string{sender.GetMentionString()})
+	functions := chat.getAvailableFunctions(s, m.Author, "", discordai.ScopeDM)
+	model := chat.getLanguageModel(m.Author.ID, "")
+
+	system := chat.Brain.BuildSystemMessage(
+		chat.getPersona("", m.ChannelID),
+		chat.getPromptContext(s, "", m.ChannelID, []*ChatParticipant{sender}, model, functions),
+	)
+	if quota.Capped {
+		system.Content += "\n\n" + quotaCappedPrompt
+	}
 	history := chat.History
 	message := openai.ChatCompletionMessage{
 		Role:    openai.ChatMessageRoleUser,
@@ -50,6 +89,10 @@ func (chat *Direct) OnMessage(s *discordgo.Session, m *discordgo.MessageCreate)
 
 	//msgPipe := utils.NewStringPipe()
 
+	// reply after moderation
+	final := ""
+	moderated := false
+
 	group := errgroup.Group{}
 	group.SetLimit(2)
 
@@ -59,14 +102,15 @@ func (chat *Direct) OnMessage(s *discordgo.Session, m *discordgo.MessageCreate)
 		defer pipe.Close()
 
 		new_history, err := chat.Brain.ProcessChunked(
-			chat.Ctx,
+			ctx,
 			pipe,
 			system,
 			history,
 			message,
-			chat.getAvailableFunctions(s, m.Author),
-			chat.getLanguageModel(m.Author.ID, ""),
+			functions,
+			model,
 			chat.getInternalArgs(s, m.Author, m.GuildID, m.ChannelID),
+			reply.OnToolEvent,
 		)
 		if err != nil {
 			return fmt.Errorf("failed while processing in brain; %w", err)
@@ -84,15 +128,15 @@ func (chat *Direct) OnMessage(s *discordgo.Session, m *discordgo.MessageCreate)
 	// reader routine will create & continuously edit
 	// the discord message with content
 	// as it's streamed in
+	// redacted & blocked replies are only shown once they've been checked
+	stream := chat.streamOutput("")
+
 	group.Go(func() error {
 		// process chunks into a message
 		content := ""
-		msgId := ""
 
 		buffer := make([]byte, 255)
 
-		rl := rate.NewLimiter(1, 1)
-
 		for {
 			n, err := pipe.Read(buffer)
 			if errors.Is(err, io.EOF) {
@@ -109,63 +153,54 @@ func (chat *Direct) OnMessage(s *discordgo.Session, m *discordgo.MessageCreate)
 			content += line
 			content = chat.replaceMarkdownLinks(content)
 
-			// if content is too large we can't
-			// put it in a single message
-			// so just keep processing chunks
-			// we'll handle it elsewhere
-			if len(content) > 2000 {
-				continue
-			}
-
-			// discord throttles our requests if we make them too fast
-			// this will ensure we don't make them too fast
-			// writes are buffered so this won't slow down OpenAI response
-			if !rl.Allow() {
-				continue
+			// writes are throttled so this won't slow down OpenAI response
+			if stream {
+				reply.SetContent(content)
 			}
+		}
 
-			var msg *discordgo.Message
-			if msgId == "" {
-				msg, err = s.ChannelMessageSend(m.ChannelID, content)
-				if err != nil {
-					logrus.WithError(err).Errorln("failed to send message")
-					continue
-				}
-			} else {
-				msg, err = s.ChannelMessageEdit(m.ChannelID, msgId, content)
-				if err != nil {
-					logrus.WithError(err).Errorln("failed to update message")
-					continue
-				}
-			}
-			msgId = msg.ID
+		// the final text is checked - streamed edits are replaced by it
+		checked, blocked := chat.moderateOutput(ctx, "", m.Author.ID, content)
+		if blocked {
+			checked = moderatedOutputMessage
 		}
+		final = checked
+		moderated = checked != content
+		reply.SetContent(final)
 
-		// content exceeded buffer
-		// send full message as a file :)
-		if len(content) > 2000 {
-			s.ChannelFileSendWithMessage(m.ChannelID, "*response too long - sent as file*", "response.txt", strings.NewReader(content))
-		} else {
-			if msgId == "" {
-				s.ChannelMessageSend(m.ChannelID, content)
-			} else {
-				s.ChannelMessageEdit(m.ChannelID, msgId, content)
-			}
+		// failures are replaced with a notice below
+		err := reply.Finish()
+		if err != nil {
+			return fmt.Errorf("failed to send final message edit; %w", err)
 		}
 
 		return nil
 	})
 
 	if err := group.Wait(); err != nil {
+		// the error is for the logs - users get aika
 		logrus.WithError(err).Errorln("failed to send message")
-		s.ChannelMessageSend(m.ChannelID, err.Error())
+		reply.Notice(failureMessage)
 		return
 	}
 
+	// aika shouldn't remember saying something she wasn't allowed to
+	if moderated {
+		history[len(history)-1].Content = final
+	}
 	chat.History = history
 
 	res := history[len(history)-1]
 
+	chat.archiveExchange(ctx, discordai.Exchange{
+		ChannelID: m.ChannelID,
+		MessageID: m.messageID(),
+		AuthorID:  m.Author.ID,
+		Author:    sender.GetDisplayName(),
+		Message:   msg,
+		Response:  res.Content,
+	})
+
 	// TODO: improve this log
 	logrus.
 		WithField("sender", sender.GetDisplayName()).
@@ -173,3 +208,11 @@ func (chat *Direct) OnMessage(s *discordgo.Session, m *discordgo.MessageCreate)
 		WithField("response", res.Content).
 		Infoln("chat log")
 }
+
+// waits for any reply in progress so it can't overwrite the edit
+func (chat *Direct) editHistory(_ string, edit func([]openai.ChatCompletionMessage) []openai.ChatCompletionMessage) {
+	chat.Mutex.Lock()
+	defer chat.Mutex.Unlock()
+
+	chat.History = edit(chat.History)
+}
@@ -1,17 +1,17 @@
 package discordchat
 
 import (
+	"aika/discord/discordai"
+	"aika/usage"
 	"aika/utils"
 	"errors"
 	"fmt"
 	"io"
-	"strings"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/sashabaranov/go-openai"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/sync/errgroup"
-	"golang.org/x/time/rate"
 )
 
 type Guild struct {
@@ -21,43 +21,77 @@ type Guild struct {
 }
 
 func (chat *Guild) OnMessage(s *discordgo.Session, m *discordgo.MessageCreate) {
-	locked := chat.Mutex.TryLock()
-	if !locked {
-		s.ChannelMessageSendReply(m.ChannelID, "rate limit", m.Reference())
+	chat.enqueue(incomingMessage(s, m), func(in *incoming) { chat.respond(s, in) })
+}
+
+// Ask answers a slash command question
+// the interaction must already have a deferred response
+func (chat *Guild) Ask(s *discordgo.Session, i *discordgo.InteractionCreate, question string) {
+	chat.enqueue(incomingInteraction(s, i, question), func(in *incoming) { chat.respond(s, in) })
+}
+
+// respond runs from the queue - one message at a time
+func (chat *Guild) respond(s *discordgo.Session, m *incoming) {
+	reply := m.reply
+
+	// history edits (commands) wait until the reply is done
+	chat.Mutex.Lock()
+	defer chat.Mutex.Unlock()
+
+	quota := chat.checkQuota(m.Author.ID, m.GuildID)
+	if quota.Blocked {
+		reply.Notice(quotaBlockedMessage)
 		return
 	}
-	defer chat.Mutex.Unlock()
 
-	msg := chat.formatUsers(m.Content, m.Mentions)
+	// bill usage to whoever sent the message
+	ctx := usage.WithAttribution(chat.Ctx, usage.Attribution{
+		GuildID:   m.GuildID,
+		ChannelID: m.ChannelID,
+		UserID:    m.Author.ID,
+	})
+	// the channel history is summarized in the background
+	ctx = discordai.WithConversation(ctx, m.ChannelID)
+	usage.Record(ctx, usage.Usage{Kind: usage.KindMessage})
+
+	msg, blocked := chat.moderateInput(ctx, m.GuildID, m.Author.ID, chat.formatUsers(m.Content, m.Mentions))
+	if blocked {
+		reply.Notice(moderatedInputMessage)
+		return
+	}
 
 	members, err := chat.getChatMembers(s, m.ChannelID)
 	if err != nil {
 		logrus.WithError(err).Errorln("failed to get chat members")
-		s.ChannelMessageSend(m.ChannelID, err.Error())
+		reply.Notice(failureMessage)
 		return
 	}
 
-	sender := &ChatParticipant{User: m.Author}
+	sender := &ChatParticipant{User: m.Author, Member: m.Member}
 	foundSender := false
 
-	memberNames := []string{}
-	memberMentions := []string{}
 	for _, member := range members {
 		if member.User.ID == sender.User.ID {
 			foundSender = true
 		}
-		memberNames = append(memberNames, member.GetDisplayName())
-		memberMentions = append(memberMentions, member.GetMentionString())
 	}
 	// this appends the sender details to the list
 	// of known participants
 	// this will fix @ing the
 	if !foundSender {
-		memberNames = append(memberNames, sender.GetDisplayName())
-		memberMentions = append(memberMentions, sender.GetMentionString())
+		members = append(members, sender)
 	}
 
-	system := chat.Brain.BuildSystemMessage(memberNames, memberMentions)
+	functions := chat.getAvailableFunctions(s, m.Author, m.GuildID, discordai.ScopeGuild)
+	model := chat.getLanguageModel(m.Author.ID, m.GuildID)
+
+	system := chat.Brain.BuildSystemMessage(
+		chat.getPersona(m.GuildID, m.ChannelID),
+		chat.getPromptContext(s, m.GuildID, m.ChannelID, members, model, functions),
+	)
+	if quota.Capped {
+		system.Content += "\n\n" + quotaCappedPrompt
+	}
 	history := chat.getHistory(m.ChannelID)
 	message := openai.ChatCompletionMessage{
 		Role:    openai.ChatMessageRoleUser,
@@ -73,6 +107,10 @@ func (chat *Guild) OnMessage(s *discordgo.Session, m *discordgo.MessageCreate) {
 	// see "directchat.go" comment on this
 	pipe := utils.NewBytePipe()
 
+	// reply after moderation
+	final := ""
+	moderated := false
+
 	group := errgroup.Group{}
 	group.SetLimit(2)
 
@@ -82,14 +120,15 @@ func (chat *Guild) OnMessage(s *discordgo.Session, m *discordgo.MessageCreate) {
 		defer pipe.Close()
 
 		new_history, err := chat.Brain.ProcessChunked(
-			chat.Ctx,
+			ctx,
 			pipe,
 			system,
 			history,
 			message,
-			chat.getAvailableFunctions(s, m.Author),
-			chat.getLanguageModel(m.Author.ID, m.GuildID),
+			functions,
+			model,
 			chat.getInternalArgs(s, m.Author, m.GuildID, m.ChannelID),
+			reply.OnToolEvent,
 		)
 		if err != nil {
 			return fmt.Errorf("failed while processing in brain; %w", err)
@@ -106,15 +145,15 @@ func (chat *Guild) OnMessage(s *discordgo.Session, m *discordgo.MessageCreate) {
 	// reader routine will create & continuously edit
 	// the discord message with content
 	// as it's streamed in
+	// redacted & blocked replies are only shown once they've been checked
+	stream := chat.streamOutput(m.GuildID)
+
 	group.Go(func() error {
 		// process chunks into a message
 		content := ""
-		msgId := ""
 
 		buffer := make([]byte, 255)
 
-		rl := rate.NewLimiter(1, 1)
-
 		for {
 			n, err := pipe.Read(buffer)
 			if errors.Is(err, io.EOF) {
@@ -131,65 +170,55 @@ func (chat *Guild) OnMessage(s *discordgo.Session, m *discordgo.MessageCreate) {
 			content += line
 			content = chat.replaceMarkdownLinks(content)
 
-			// if content is too large we can't
-			// put it in a single message
-			// so just keep processing chunks
-			// we'll handle it elsewhere
-			if len(content) > 2000 {
-				continue
-			}
-
-			// discord throttles our requests if we make them too fast
-			// this will ensure we don't make them too fast
-			// writes are buffered so this won't slow down OpenAI response
-			if !rl.Allow() {
-				continue
-			}
-
-			var msg *discordgo.Message
-			if msgId == "" {
-				msg, err = s.ChannelMessageSend(m.ChannelID, content)
-				if err != nil {
-					logrus.WithError(err).Errorln("failed to send message")
-					continue
-				}
-			} else {
-				msg, err = s.ChannelMessageEdit(m.ChannelID, msgId, content)
-				if err != nil {
-					logrus.WithError(err).Errorln("failed to update message")
-					continue
-				}
+			// writes are throttled so this won't slow down OpenAI response
+			if stream {
+				reply.SetContent(content)
 			}
-			msgId = msg.ID
 		}
 
-		err = nil
-		if len(content) > 2000 {
-			_, err = s.ChannelFileSendWithMessage(m.ChannelID, "*response too long - sent as file*", "response.txt", strings.NewReader(content))
-		} else {
-			if msgId == "" {
-				_, err = s.ChannelMessageSend(m.ChannelID, content)
-			} else {
-				_, err = s.ChannelMessageEdit(m.ChannelID, msgId, content)
-			}
+		// the final text is checked - streamed edits are replaced by it
+		checked, blocked := chat.moderateOutput(ctx, m.GuildID, m.Author.ID, content)
+		if blocked {
+			checked = moderatedOutputMessage
 		}
+		final = checked
+		moderated = checked != content
+		reply.SetContent(final)
+
+		// failures are replaced with a notice below
+		err := reply.Finish()
 		if err != nil {
-			logrus.WithError(err).Errorln("failed to send final message edit")
+			return fmt.Errorf("failed to send final message edit; %w", err)
 		}
 
 		return nil
 	})
 
 	if err := group.Wait(); err != nil {
+		// the error is for the logs - users get aika
 		logrus.WithError(err).Errorln("failed to send message")
-		s.ChannelMessageSend(m.ChannelID, err.Error())
+		reply.Notice(failureMessage)
 		return
 	}
 
+	// aika shouldn't remember saying something she wasn't allowed to
+	if moderated {
+		history[len(history)-1].Content = final
+	}
 	chat.setHistory(m.ChannelID, history)
 
 	res := history[len(history)-1]
 
+	chat.archiveExchange(ctx, discordai.Exchange{
+		GuildID:   m.GuildID,
+		ChannelID: m.ChannelID,
+		MessageID: m.messageID(),
+		AuthorID:  m.Author.ID,
+		Author:    sender.GetDisplayName(),
+		Message:   msg,
+		Response:  res.Content,
+	})
+
 	// TODO: improve this log
 	logrus.
 		WithField("sender", sender.GetDisplayName()).
@@ -205,11 +234,24 @@ func (chat *Guild) setHistory(channel string, history []openai.ChatCompletionMes
 	chat.History[channel] = history
 }
 
+// waits for any reply in progress so it can't overwrite the edit
+func (chat *Guild) editHistory(channel string, edit func([]openai.ChatCompletionMessage) []openai.ChatCompletionMessage) {
+	chat.Mutex.Lock()
+	defer chat.Mutex.Unlock()
+
+	chat.History[channel] = edit(chat.History[channel])
+}
+
 func (chat *Guild) getChatMembers(s *discordgo.Session, channel string) ([]*ChatParticipant, error) {
 
 	participants := []*ChatParticipant{}
 	dedupID := make(map[string]bool)
 
+	// threads have the same audience as their channel
+	if thread, err := s.State.Channel(channel); err == nil && thread.IsThread() {
+		channel = thread.ParentID
+	}
+
 	gd, err := s.State.Guild(chat.ChatID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get guild details; %w", err)
@@ -260,7 +302,7 @@ func (chat *Guild) getChatMembers(s *discordgo.Session, channel string) ([]*Chat
 		}
 
 		dedupID[member.User.ID] = true
-		participants = append(participants, &ChatParticipant{User: member.User})
+		participants = append(participants, &ChatParticipant{User: member.User, Member: member})
 	}
 
 	return participants, nil
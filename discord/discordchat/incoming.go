@@ -0,0 +1,96 @@
+package discordchat
+
+import (
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// incoming is something said to aika - a message or a slash command
+type incoming struct {
+	Author *discordgo.User
+	// nil in DMs
+	Member    *discordgo.Member
+	GuildID   string
+	ChannelID string
+	// empty for slash commands - the reply is archived instead
+	MessageID string
+	Content   string
+	Mentions  []*discordgo.User
+
+	// where the response & any notices go
+	reply *reply
+
+	// answers the message when it's aika's turn (see queue)
+	respond func(*incoming)
+}
+
+func incomingMessage(s *discordgo.Session, m *discordgo.MessageCreate) *incoming {
+	reply := newReply(s, m.ChannelID)
+	reply.reference = m.Reference()
+
+	return &incoming{
+		Author:    m.Author,
+		Member:    m.Member,
+		GuildID:   m.GuildID,
+		ChannelID: m.ChannelID,
+		MessageID: m.ID,
+		Content:   m.Content,
+		Mentions:  m.Mentions,
+		reply:     reply,
+	}
+}
+
+// the interaction must already have a deferred response
+func incomingInteraction(s *discordgo.Session, i *discordgo.InteractionCreate, question string) *incoming {
+	// the question is quoted so the channel sees what was asked
+	header := "> " + strings.ReplaceAll(question, "\n", "\n> ")
+
+	return &incoming{
+		Author:    InteractionUser(i),
+		Member:    i.Member,
+		GuildID:   i.GuildID,
+		ChannelID: i.ChannelID,
+		Content:   question,
+		reply:     newInteractionReply(s, i.Interaction, header),
+	}
+}
+
+// InteractionUser is whoever used the command - guilds only set the member
+func InteractionUser(i *discordgo.InteractionCreate) *discordgo.User {
+	if i.Member != nil {
+		return i.Member.User
+	}
+	return i.User
+}
+
+func (in *incoming) sender() string {
+	return in.Author.ID
+}
+
+// follow-up messages are answered together.
+// slash commands have their own response to fill so they're never merged.
+func (in *incoming) merge(next queuedMessage) bool {
+	follow, ok := next.(*incoming)
+	if !ok || in.reply.interaction != nil || follow.reply.interaction != nil {
+		return false
+	}
+
+	in.Content += "\n" + follow.Content
+	in.Mentions = append(in.Mentions, follow.Mentions...)
+	in.reply.Include(follow.reply)
+	return true
+}
+
+func (in *incoming) run() {
+	in.reply.Started()
+	in.respond(in)
+}
+
+// messageID is the message archived with the exchange
+func (in *incoming) messageID() string {
+	if in.MessageID != "" {
+		return in.MessageID
+	}
+	return in.reply.MessageID()
+}
@@ -0,0 +1,99 @@
+package discordchat
+
+import (
+	"aika/moderation"
+	"context"
+
+	"github.com/sirupsen/logrus"
+)
+
+// sent instead of a reply when the sender's message is blocked
+const moderatedInputMessage = "Ew, no. I'm not touching that one. Ask me something else, baka. 😤"
+
+// replaces a reply which was blocked
+const moderatedOutputMessage = "*aika's reply was removed by moderation*"
+
+// moderateInput checks a message sent to aika.
+// returns the text to use & if the message was blocked.
+func (c *Chat) moderateInput(ctx context.Context, guildID string, userID string, text string) (string, bool) {
+	policy := c.getModerationPolicy(guildID)
+	return c.moderate(ctx, guildID, userID, "input", policy.Input, policy, text)
+}
+
+// moderateOutput checks one of aika's replies.
+// returns the text to use & if the reply was blocked.
+func (c *Chat) moderateOutput(ctx context.Context, guildID string, userID string, text string) (string, bool) {
+	policy := c.getModerationPolicy(guildID)
+	return c.moderate(ctx, guildID, userID, "output", policy.Output, policy, text)
+}
+
+// streamOutput returns false when replies must be checked before anyone sees them.
+// streamed text can't be taken back once discord has notified people.
+func (c *Chat) streamOutput(guildID string) bool {
+	if c.Brain.Moderator == nil {
+		return true
+	}
+	action := c.getModerationPolicy(guildID).Output
+	return action != moderation.ActionRedact && action != moderation.ActionBlock
+}
+
+func (c *Chat) moderate(
+	ctx context.Context,
+	guildID string,
+	userID string,
+	direction string,
+	action moderation.Action,
+	policy moderation.Policy,
+	text string,
+) (string, bool) {
+	if c.Brain.Moderator == nil || action == "" || action == moderation.ActionOff {
+		return text, false
+	}
+
+	keywords, err := moderation.NewKeywords(policy.Keywords)
+	if err != nil {
+		// validated on startup so this shouldn't happen
+		logrus.WithError(err).Warnln("invalid moderation keywords in config.yaml")
+	}
+
+	result := c.Brain.Moderator.Check(ctx, text, keywords)
+	if !result.Flagged {
+		return text, false
+	}
+
+	logrus.
+		WithField("guild", guildID).
+		WithField("user", userID).
+		WithField("direction", direction).
+		WithField("action", action).
+		WithField("categories", result.Categories).
+		WithField("text", text).
+		Warnln("moderation flagged message")
+
+	return moderation.Apply(action, text, result)
+}
+
+// getModerationPolicy reads "moderation" from the config file
+// and applies any overrides for the guild
+func (c *Chat) getModerationPolicy(guildID string) moderation.Policy {
+	policy := moderation.Policy{}
+	apply := func(data interface{}) {
+		override, err := moderation.ParsePolicy(data)
+		if err != nil {
+			logrus.WithError(err).Warnln("invalid moderation policy in config.yaml")
+			return
+		}
+		policy = policy.Override(override)
+	}
+
+	if data, ok := c.Cfg.Get("moderation"); ok {
+		apply(data)
+	}
+	if config := c.getGuildConfig(guildID); config != nil {
+		if data, ok := config["moderation"]; ok {
+			apply(data)
+		}
+	}
+
+	return policy
+}
@@ -0,0 +1,95 @@
+package discordchat
+
+import (
+	"aika/ai"
+	"aika/discord/discordai"
+	"time"
+	_ "time/tzdata" // the runtime image has no zoneinfo
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/sirupsen/logrus"
+)
+
+// getPromptContext collects everything the system message template knows about the chat
+func (c *Chat) getPromptContext(
+	s *discordgo.Session,
+	guildID string,
+	channelID string,
+	participants []*ChatParticipant,
+	model ai.LanguageModel,
+	functions []discordai.Function,
+) discordai.PromptContext {
+	prompt := discordai.PromptContext{
+		Now:   time.Now().In(c.getTimezone(guildID)),
+		Model: string(model),
+	}
+
+	memories := c.getMemories(participants)
+	for i, participant := range participants {
+		entry := discordai.PromptParticipant{
+			Name:       participant.GetDisplayName(),
+			Username:   participant.User.Username,
+			Mention:    participant.GetMentionString(),
+			Remembered: memories[i],
+		}
+		if participant.Member != nil {
+			entry.Nickname = participant.Member.Nick
+			for _, roleID := range participant.Member.Roles {
+				role, err := s.State.Role(guildID, roleID)
+				if err != nil {
+					continue // role isn't cached
+				}
+				entry.Roles = append(entry.Roles, role.Name)
+			}
+		}
+		prompt.Participants = append(prompt.Participants, entry)
+	}
+
+	for _, function := range functions {
+		prompt.Functions = append(prompt.Functions, function.Definition.Name)
+	}
+
+	if guildID == "" {
+		return prompt // DMs have no server or channel details
+	}
+
+	guild, err := s.State.Guild(guildID)
+	if err != nil {
+		logrus.WithError(err).WithField("guild", guildID).Warnln("failed to get guild for system message")
+	} else {
+		prompt.Guild = guild.Name
+	}
+
+	channel, err := s.State.Channel(channelID)
+	if err != nil {
+		logrus.WithError(err).WithField("channel", channelID).Warnln("failed to get channel for system message")
+	} else {
+		prompt.Channel = channel.Name
+		prompt.Topic = channel.Topic
+		prompt.NSFW = channel.NSFW
+	}
+
+	return prompt
+}
+
+// getTimezone reads "timezone" from the config file
+// and applies any override for the guild (default UTC)
+func (c *Chat) getTimezone(guildID string) *time.Location {
+	name, _ := c.Cfg.Get("timezone")
+	if guild := c.getGuildConfig(guildID); guild != nil {
+		if override, ok := guild["timezone"]; ok {
+			name = override
+		}
+	}
+	if name == nil {
+		return time.UTC
+	}
+
+	str, _ := name.(string)
+	location, err := time.LoadLocation(str)
+	if err != nil {
+		logrus.WithError(err).WithField("timezone", name).Warnln("invalid timezone in config.yaml")
+		return time.UTC
+	}
+	return location
+}
@@ -0,0 +1,113 @@
+package discordchat
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// messages waiting for aika in each chat - more are turned away
+	maxQueuedMessages = 5
+	// follow-ups sent this soon after a waiting message are merged into it
+	coalesceWindow = 10 * time.Second
+)
+
+// sent when too many messages are already waiting
+const queueFullMessage = "W-wait, one at a time! I can't keep up with all of you... ask me again once I've caught up, baka. 😤"
+
+// posted in the voice channel's chat when spoken messages are turned away
+const voiceBehindMessage = "%s one sec, I'm behind! I missed that... s-say it again once I've caught up. 😤"
+
+var ErrQueueFull = errors.New("message queue is full")
+
+// queuedMessage is something waiting for aika's turn
+type queuedMessage interface {
+	// who sent it - only their own follow-ups are merged in
+	sender() string
+	// merge a follow-up into the message - false if they can't be merged
+	merge(next queuedMessage) bool
+	// answer the message
+	run()
+}
+
+type queueEntry struct {
+	message queuedMessage
+	// when the message (or its last follow-up) arrived
+	updated time.Time
+}
+
+// queue answers a chat's messages one at a time, in the order they arrive.
+// the zero value is ready to use.
+type queue struct {
+	mutex   sync.Mutex
+	waiting []*queueEntry
+	// the worker is answering messages
+	busy bool
+	// a message has been taken from the queue & is being answered
+	running bool
+}
+
+// push adds the message to the queue & starts answering if aika is idle.
+// ahead is how many messages will be answered first.
+// merged is true if the message was added to the sender's waiting message.
+func (q *queue) push(message queuedMessage) (ahead int, merged bool, err error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	now := time.Now()
+
+	// rapid follow-ups join the sender's newest waiting message
+	for i := len(q.waiting) - 1; i >= 0; i-- {
+		entry := q.waiting[i]
+		if entry.message.sender() != message.sender() {
+			continue
+		}
+		if now.Sub(entry.updated) < coalesceWindow && entry.message.merge(message) {
+			entry.updated = now
+			return i + q.ahead(), true, nil
+		}
+		break
+	}
+
+	if len(q.waiting) >= maxQueuedMessages {
+		return 0, false, ErrQueueFull
+	}
+
+	ahead = len(q.waiting) + q.ahead()
+	q.waiting = append(q.waiting, &queueEntry{message: message, updated: now})
+
+	if !q.busy {
+		q.busy = true
+		go q.work()
+	}
+	return ahead, false, nil
+}
+
+// answer messages until the queue is empty
+func (q *queue) work() {
+	for {
+		q.mutex.Lock()
+		q.running = false
+		if len(q.waiting) == 0 {
+			q.busy = false
+			q.mutex.Unlock()
+			return
+		}
+		next := q.waiting[0]
+		q.waiting = q.waiting[1:]
+		q.running = true
+		q.mutex.Unlock()
+
+		next.message.run()
+	}
+}
+
+// the message being answered is ahead of everything waiting
+// caller must hold the mutex
+func (q *queue) ahead() int {
+	if q.running {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,111 @@
+package discordchat
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testMessage blocks in run until released so the queue can be inspected
+type testMessage struct {
+	from    string
+	text    string
+	release chan struct{}
+	done    *[]string
+	mutex   *sync.Mutex
+}
+
+func (m *testMessage) sender() string {
+	return m.from
+}
+
+func (m *testMessage) merge(next queuedMessage) bool {
+	m.text += " " + next.(*testMessage).text
+	return true
+}
+
+func (m *testMessage) run() {
+	<-m.release
+	m.mutex.Lock()
+	*m.done = append(*m.done, m.text)
+	m.mutex.Unlock()
+}
+
+func TestQueueOrderAndCoalescing(t *testing.T) {
+	q := &queue{}
+	release := make(chan struct{})
+	done := []string{}
+	mutex := &sync.Mutex{}
+	message := func(from string, text string) *testMessage {
+		return &testMessage{from: from, text: text, release: release, done: &done, mutex: mutex}
+	}
+
+	ahead, merged, err := q.push(message("a", "first"))
+	assert.NoError(t, err)
+	assert.False(t, merged)
+	assert.Equal(t, 0, ahead)
+	waitForWorker(t, q)
+
+	// the running message is ahead of everything
+	ahead, _, _ = q.push(message("b", "second"))
+	assert.Equal(t, 1, ahead)
+
+	// follow-ups join the sender's waiting message
+	_, merged, _ = q.push(message("b", "more"))
+	assert.True(t, merged)
+
+	ahead, merged, _ = q.push(message("c", "third"))
+	assert.False(t, merged)
+	assert.Equal(t, 2, ahead)
+
+	close(release)
+	assert.Eventually(t, func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return len(done) == 3
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"first", "second more", "third"}, done)
+
+	// the worker stops once the queue is empty
+	assert.Eventually(t, func() bool {
+		q.mutex.Lock()
+		defer q.mutex.Unlock()
+		return !q.busy
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestQueueFull(t *testing.T) {
+	q := &queue{}
+	release := make(chan struct{})
+	defer close(release)
+	done := []string{}
+	mutex := &sync.Mutex{}
+
+	// one running plus a full queue
+	for i := 0; i <= maxQueuedMessages; i++ {
+		_, _, err := q.push(&testMessage{from: string(rune('a' + i)), release: release, done: &done, mutex: mutex})
+		assert.NoError(t, err)
+		if i == 0 {
+			waitForWorker(t, q)
+		}
+	}
+
+	_, _, err := q.push(&testMessage{from: "z", release: release, done: &done, mutex: mutex})
+	assert.ErrorIs(t, err, ErrQueueFull)
+
+	// a follow-up can still join its waiting message
+	_, merged, err := q.push(&testMessage{from: "b", release: release, done: &done, mutex: mutex})
+	assert.NoError(t, err)
+	assert.True(t, merged)
+}
+
+// wait for the worker to take the first message so it isn't counted as waiting
+func waitForWorker(t *testing.T, q *queue) {
+	assert.Eventually(t, func() bool {
+		q.mutex.Lock()
+		defer q.mutex.Unlock()
+		return q.running
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,75 @@
+package discordchat
+
+import (
+	"aika/storage"
+	"aika/usage"
+
+	"github.com/sirupsen/logrus"
+)
+
+// sent instead of a reply when the sender is over their message quota
+const quotaBlockedMessage = "Hmph! You've been talking my ear off all day... I need a break from you. C-come back later, okay? 💢"
+
+// added to the system message when the sender is capped
+// so aika can explain why she won't do expensive things
+const quotaCappedPrompt = "The user has reached their usage limit. " +
+	"You can't generate images, download videos or join voice chat for them until it resets. " +
+	"If they ask for any of those, tell them in character that they've been rate-capped and to try again later."
+
+// checkQuota compares the guild's & sender's usage to their quotas.
+// admins are never limited.
+func (c *Chat) checkQuota(userID string, guildID string) usage.Status {
+	ledger := usage.LedgerFrom(c.Ctx)
+	if ledger == nil || c.isAdmin(userID) {
+		return usage.Status{}
+	}
+
+	guildQuota, userQuota := c.getQuotas(guildID)
+
+	status := userQuota.Check("user", ledger.UserPeriods(userID))
+	// DMs share a bucket - only users are limited there
+	if guildID != "" {
+		status = status.Merge(guildQuota.Check("guild", ledger.GuildPeriods(guildID)))
+	}
+
+	if status.Capped || status.Blocked {
+		logrus.
+			WithField("user", userID).
+			WithField("guild", guildID).
+			WithField("reason", status.Reason).
+			Debugln("quota reached")
+	}
+	return status
+}
+
+// getQuotas reads "quotas" from the config file
+// and applies any overrides for the guild
+func (c *Chat) getQuotas(guildID string) (guild usage.Quota, user usage.Quota) {
+	apply := func(data interface{}) {
+		quotas, _ := storage.StringMap(data)
+		for name, value := range quotas {
+			quota, err := usage.ParseQuota(value)
+			if err != nil {
+				logrus.WithField("quota", name).WithError(err).Warnln("invalid quota in config.yaml")
+				continue
+			}
+			switch name {
+			case "guild":
+				guild = quota
+			case "user":
+				user = quota
+			default:
+				logrus.WithField("quota", name).Warnln("unknown quota in config.yaml")
+			}
+		}
+	}
+
+	if data, ok := c.Cfg.Get("quotas"); ok {
+		apply(data)
+	}
+	if config := c.getGuildConfig(guildID); config != nil {
+		apply(config["quotas"])
+	}
+
+	return guild, user
+}
@@ -0,0 +1,371 @@
+package discordchat
+
+import (
+	"aika/discord/discordai"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+// discord's message length limit
+const maxMessageLength = 2000
+
+// shown on messages waiting for aika's turn
+const queuedReaction = "⏳"
+
+// discord rejects messages without content
+var ErrEmptyReply = errors.New("reply has no content")
+
+// reply streams an AI response into a single discord message.
+// while functions run a status line is shown under the
+// content so users know aika is still working on it.
+//
+// changes only record state - a flusher goroutine sends them
+// so the AI & functions never wait on discord.
+type reply struct {
+	session   *discordgo.Session
+	channelID string
+	// message being answered - notices reply to it
+	reference *discordgo.MessageReference
+	// slash commands edit their deferred response instead of sending messages
+	interaction *discordgo.Interaction
+	// shown above the content - slash commands quote the question
+	header string
+
+	// discord throttles our requests if we make them too fast
+	limiter *rate.Limiter
+
+	// serializes requests to discord so edits land in order
+	// & guards msgID
+	sending sync.Mutex
+	msgID   string
+
+	mutex   sync.Mutex
+	content string
+	// call ID -> status of running functions
+	running map[string]string
+	// call IDs in the order they started
+	order []string
+	// last failure - shown until something else happens
+	failure string
+	// changed since it was last sent
+	dirty bool
+	// the flusher is running
+	flushing bool
+	done     bool
+
+	// messages showing the queued reaction
+	queued []*discordgo.MessageReference
+	// aika started answering - nothing is queued anymore
+	started bool
+}
+
+func newReply(s *discordgo.Session, channelID string) *reply {
+	return &reply{
+		session:   s,
+		channelID: channelID,
+		limiter:   rate.NewLimiter(1, 1),
+		running:   make(map[string]string),
+	}
+}
+
+// newInteractionReply streams into a slash command's deferred response
+func newInteractionReply(s *discordgo.Session, interaction *discordgo.Interaction, header string) *reply {
+	r := newReply(s, interaction.ChannelID)
+	r.interaction = interaction
+	r.header = header
+	return r
+}
+
+// Queued shows the message is waiting behind others
+func (r *reply) Queued(ahead int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.started {
+		return
+	}
+	if r.interaction != nil {
+		text := r.withHeader(fmt.Sprintf("%s *waiting for my turn (%d ahead)*", queuedReaction, ahead))
+		_, err := r.session.InteractionResponseEdit(r.interaction, &discordgo.WebhookEdit{Content: &text})
+		if err != nil {
+			logrus.WithError(err).Errorln("failed to show queued status")
+		}
+		return
+	}
+	r.markQueued(r.reference)
+}
+
+// Include shows a follow-up merged into this reply is waiting too
+func (r *reply) Include(follow *reply) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if !r.started {
+		r.markQueued(follow.reference)
+	}
+}
+
+// caller must hold the mutex
+func (r *reply) markQueued(ref *discordgo.MessageReference) {
+	if ref == nil {
+		return
+	}
+	err := r.session.MessageReactionAdd(ref.ChannelID, ref.MessageID, queuedReaction)
+	if err != nil {
+		logrus.WithError(err).Warnln("failed to show queued reaction")
+		return
+	}
+	r.queued = append(r.queued, ref)
+}
+
+// Started removes the queued indicators once aika gets to the message
+func (r *reply) Started() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.started = true
+	for _, ref := range r.queued {
+		err := r.session.MessageReactionRemove(ref.ChannelID, ref.MessageID, queuedReaction, "@me")
+		if err != nil {
+			logrus.WithError(err).Warnln("failed to remove queued reaction")
+		}
+	}
+	r.queued = nil
+}
+
+// Notice ends the reply with a one-off message (full queue, failures).
+// anything already sent (function status) is replaced by it
+func (r *reply) Notice(text string) {
+	r.mutex.Lock()
+	r.done = true
+	r.mutex.Unlock()
+
+	r.sending.Lock()
+	defer r.sending.Unlock()
+
+	var err error
+	switch {
+	case r.interaction != nil:
+		_, err = r.session.InteractionResponseEdit(r.interaction, &discordgo.WebhookEdit{Content: &text})
+	case r.msgID != "":
+		_, err = r.session.ChannelMessageEdit(r.channelID, r.msgID, text)
+	case r.reference != nil:
+		_, err = r.session.ChannelMessageSendReply(r.channelID, text, r.reference)
+	default:
+		_, err = r.session.ChannelMessageSend(r.channelID, text)
+	}
+	if err != nil {
+		logrus.WithError(err).Errorln("failed to send notice")
+	}
+}
+
+// MessageID is the message the reply was sent as (empty until it's sent)
+func (r *reply) MessageID() string {
+	r.sending.Lock()
+	defer r.sending.Unlock()
+
+	return r.msgID
+}
+
+// SetContent replaces the streamed content
+func (r *reply) SetContent(content string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.content = content
+	r.update()
+}
+
+// OnToolEvent shows function progress - use as the brain's ToolObserver
+func (r *reply) OnToolEvent(event discordai.ToolEvent) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.failure = ""
+	switch event.Type {
+	case discordai.ToolStarted:
+		r.running[event.CallID] = event.Status
+		r.order = append(r.order, event.CallID)
+	case discordai.ToolFinished:
+		r.stopped(event.CallID)
+	case discordai.ToolFailed:
+		r.stopped(event.CallID)
+		r.failure = "⚠️ " + event.Status + " failed"
+	}
+	r.update()
+}
+
+// Finish sends the final content without any status
+// content too long for a message is sent as a file.
+// empty replies aren't sent - the status stays until a Notice replaces it
+func (r *reply) Finish() error {
+	r.mutex.Lock()
+	r.done = true
+	raw := r.content
+	content := r.withHeader(raw)
+	r.mutex.Unlock()
+
+	if strings.TrimSpace(raw) == "" {
+		return ErrEmptyReply
+	}
+
+	// waits for an edit in flight - later ones see done & stop
+	r.sending.Lock()
+	defer r.sending.Unlock()
+
+	if len(content) > maxMessageLength {
+		return r.sendFile(raw)
+	}
+	_, err := r.send(content)
+	return err
+}
+
+// content too long for a message is sent as a file
+// caller must hold the sending mutex
+func (r *reply) sendFile(content string) error {
+	notice := r.withHeader("*response too long - sent as file*")
+	if r.interaction != nil {
+		msg, err := r.session.InteractionResponseEdit(r.interaction, &discordgo.WebhookEdit{
+			Content: &notice,
+			Files:   []*discordgo.File{{Name: "response.txt", Reader: strings.NewReader(content)}},
+		})
+		if err == nil {
+			r.msgID = msg.ID
+		}
+		return err
+	}
+	file := &discordgo.File{Name: "response.txt", Reader: strings.NewReader(content)}
+	if r.msgID != "" {
+		// the status message becomes the file
+		_, err := r.session.ChannelMessageEditComplex(&discordgo.MessageEdit{
+			ID:      r.msgID,
+			Channel: r.channelID,
+			Content: &notice,
+			Files:   []*discordgo.File{file},
+		})
+		return err
+	}
+	msg, err := r.session.ChannelMessageSendComplex(r.channelID, &discordgo.MessageSend{
+		Content: notice,
+		Files:   []*discordgo.File{file},
+	})
+	if err == nil {
+		r.msgID = msg.ID
+	}
+	return err
+}
+
+// send the text as a new message or edit the one already sent
+// caller must hold the sending mutex
+func (r *reply) send(text string) (*discordgo.Message, error) {
+	var msg *discordgo.Message
+	var err error
+	switch {
+	case r.interaction != nil:
+		msg, err = r.session.InteractionResponseEdit(r.interaction, &discordgo.WebhookEdit{Content: &text})
+	case r.msgID == "":
+		msg, err = r.session.ChannelMessageSend(r.channelID, text)
+	default:
+		msg, err = r.session.ChannelMessageEdit(r.channelID, r.msgID, text)
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.msgID = msg.ID
+	return msg, nil
+}
+
+func (r *reply) withHeader(text string) string {
+	if r.header == "" {
+		return text
+	}
+	return r.header + "\n\n" + text
+}
+
+func (r *reply) stopped(callID string) {
+	delete(r.running, callID)
+	for i, id := range r.order {
+		if id == callID {
+			r.order = append(r.order[:i:i], r.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// content followed by the status line
+// caller must hold the mutex
+func (r *reply) render() string {
+	status := []string{}
+	for _, id := range r.order {
+		status = append(status, r.running[id]+"…")
+	}
+	if r.failure != "" {
+		status = append(status, r.failure)
+	}
+	if len(status) == 0 {
+		return r.withHeader(r.content)
+	}
+
+	text := strings.Join(status, "\n")
+	if r.content != "" {
+		text = r.content + "\n\n" + text
+	}
+	return r.withHeader(text)
+}
+
+// mark the reply as changed & start the flusher if it isn't running
+// caller must hold the mutex
+func (r *reply) update() {
+	if r.done {
+		return
+	}
+	r.dirty = true
+	if !r.flushing {
+		r.flushing = true
+		go r.flush()
+	}
+}
+
+// send the latest state to discord until nothing has changed.
+// changes made while throttled are sent together.
+func (r *reply) flush() {
+	for {
+		// discord throttles our requests if we make them too fast
+		_ = r.limiter.Wait(context.Background())
+
+		r.mutex.Lock()
+		if !r.dirty || r.done {
+			r.flushing = false
+			r.mutex.Unlock()
+			return
+		}
+		r.dirty = false
+		text := r.render()
+		r.mutex.Unlock()
+
+		// content is too large for a single message
+		// so just keep processing chunks - Finish handles it
+		if text == "" || len(text) > maxMessageLength {
+			continue
+		}
+
+		r.sending.Lock()
+		r.mutex.Lock()
+		done := r.done
+		r.mutex.Unlock()
+		if !done {
+			_, err := r.send(text)
+			if err != nil {
+				logrus.WithError(err).Errorln("failed to update message")
+			}
+		}
+		r.sending.Unlock()
+	}
+}
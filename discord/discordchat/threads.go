@@ -0,0 +1,131 @@
+package discordchat
+
+import (
+	"aika/storage"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// minutes without messages before discord archives a thread
+	defaultThreadArchive = 60
+	maxThreadNameLength  = 80
+	defaultThreadName    = "Chat with Aika"
+)
+
+// ThreadArchiveDurations are the inactivity timeouts discord allows (minutes)
+var ThreadArchiveDurations = []int{60, 1440, 4320, 10080}
+
+// threadConfig is how aika uses threads in a guild
+type threadConfig struct {
+	Enabled bool
+	// minutes of inactivity before the thread is archived
+	AutoArchive int
+}
+
+// IsOwnThread returns true if the channel is a thread aika started.
+// everything said in her threads is for her.
+// it's checked for every guild message so only the state cache is used,
+// unless fetch is set for a thread she's chatting in that isn't cached
+func IsOwnThread(s *discordgo.Session, channelID string, fetch bool) bool {
+	channel, err := s.State.Channel(channelID)
+	if err != nil {
+		if !fetch {
+			return false
+		}
+		channel, err = s.Channel(channelID)
+		if err != nil {
+			logrus.WithError(err).WithField("channel", channelID).Warnln("failed to get channel")
+			return false
+		}
+		// so the next message doesn't fetch it again
+		_ = s.State.ChannelAdd(channel)
+	}
+	return isOwnThread(channel, s.State.User.ID)
+}
+
+// IsThread returns true if the channel is a cached thread
+func IsThread(s *discordgo.Session, channelID string) bool {
+	channel, err := s.State.Channel(channelID)
+	return err == nil && channel.IsThread()
+}
+
+func isOwnThread(channel *discordgo.Channel, botID string) bool {
+	return channel.IsThread() && channel.OwnerID == botID
+}
+
+// StartThread opens a thread on the message if the guild uses threads.
+// returns the thread ID, or "" to reply in the channel.
+func (chat *Guild) StartThread(s *discordgo.Session, m *discordgo.MessageCreate) string {
+	config := chat.getThreadConfig(m.GuildID)
+	channel, err := s.State.Channel(m.ChannelID)
+	if err != nil || !startsThread(config, channel) {
+		return ""
+	}
+
+	thread, err := s.MessageThreadStartComplex(m.ChannelID, m.ID, &discordgo.ThreadStart{
+		// "@Aika what's up" is named "what's up"
+		Name:                threadName(chat.formatUsers(strings.ReplaceAll(m.Content, s.State.User.Mention(), ""), m.Mentions)),
+		AutoArchiveDuration: config.AutoArchive,
+	})
+	if err != nil {
+		// probably missing permissions - just reply in the channel
+		logrus.WithError(err).WithField("channel", m.ChannelID).Warnln("failed to start thread")
+		return ""
+	}
+	return thread.ID
+}
+
+// replies get their own thread if the guild uses threads.
+// threads can't be nested & other channels (forums, voice text) reply in place
+func startsThread(config threadConfig, channel *discordgo.Channel) bool {
+	return config.Enabled && channel.Type == discordgo.ChannelTypeGuildText
+}
+
+// OnThreadStart replies in the thread opened on the message.
+// the thread is a new chat so it starts with its own history.
+func (chat *Guild) OnThreadStart(s *discordgo.Session, m *discordgo.MessageCreate, threadID string) {
+	in := incomingMessage(s, m)
+	in.ChannelID = threadID
+	// the message is in the parent channel - the reply is archived instead
+	in.MessageID = ""
+	in.reply = newReply(s, threadID)
+
+	chat.enqueue(in, func(in *incoming) { chat.respond(s, in) })
+}
+
+// getThreadConfig reads "threads" from the config file
+// and applies any override for the guild
+func (c *Chat) getThreadConfig(guildID string) threadConfig {
+	config := threadConfig{AutoArchive: defaultThreadArchive}
+
+	apply := func(data interface{}) {
+		fields, _ := storage.StringMap(data)
+		if enabled, ok := fields["enabled"].(bool); ok {
+			config.Enabled = enabled
+		}
+		if minutes, ok := fields["auto_archive"].(int); ok {
+			config.AutoArchive = minutes
+		}
+	}
+
+	if data, ok := c.Cfg.Get("threads"); ok {
+		apply(data)
+	}
+	if guild := c.getGuildConfig(guildID); guild != nil {
+		apply(guild["threads"])
+	}
+
+	return config
+}
+
+// thread names come from the message that started them
+func threadName(content string) string {
+	name := strings.Join(strings.Fields(content), " ")
+	if name == "" {
+		return defaultThreadName
+	}
+	return truncate(name, maxThreadNameLength)
+}
@@ -0,0 +1,93 @@
+package discordchat
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsOwnThread(t *testing.T) {
+	tests := []struct {
+		name    string
+		channel *discordgo.Channel
+		want    bool
+	}{
+		{"aika's thread", &discordgo.Channel{Type: discordgo.ChannelTypeGuildPublicThread, OwnerID: "aika"}, true},
+		{"aika's private thread", &discordgo.Channel{Type: discordgo.ChannelTypeGuildPrivateThread, OwnerID: "aika"}, true},
+		{"someone else's thread", &discordgo.Channel{Type: discordgo.ChannelTypeGuildPublicThread, OwnerID: "bob"}, false},
+		// channels have owners too
+		{"text channel", &discordgo.Channel{Type: discordgo.ChannelTypeGuildText, OwnerID: "aika"}, false},
+		{"dm", &discordgo.Channel{Type: discordgo.ChannelTypeDM}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, isOwnThread(test.channel, "aika"))
+		})
+	}
+}
+
+func TestStartsThread(t *testing.T) {
+	enabled := threadConfig{Enabled: true, AutoArchive: 60}
+
+	tests := []struct {
+		name    string
+		config  threadConfig
+		channel discordgo.ChannelType
+		want    bool
+	}{
+		{"text channel", enabled, discordgo.ChannelTypeGuildText, true},
+		{"threads disabled", threadConfig{AutoArchive: 60}, discordgo.ChannelTypeGuildText, false},
+		// threads can't be nested
+		{"thread", enabled, discordgo.ChannelTypeGuildPublicThread, false},
+		{"news channel", enabled, discordgo.ChannelTypeGuildNews, false},
+		{"voice channel", enabled, discordgo.ChannelTypeGuildVoice, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, startsThread(test.config, &discordgo.Channel{Type: test.channel}))
+		})
+	}
+}
+
+func TestGetThreadConfig(t *testing.T) {
+	chat := newTestChat(t, `
+threads:
+  enabled: true
+  auto_archive: 1440
+guilds:
+  "quiet":
+    threads:
+      enabled: false
+  "slow":
+    threads:
+      auto_archive: 10080
+`)
+
+	assert.Equal(t, threadConfig{Enabled: true, AutoArchive: 1440}, chat.getThreadConfig("guild"))
+	assert.Equal(t, threadConfig{Enabled: false, AutoArchive: 1440}, chat.getThreadConfig("quiet"))
+	assert.Equal(t, threadConfig{Enabled: true, AutoArchive: 10080}, chat.getThreadConfig("slow"))
+
+	// off by default
+	chat = newTestChat(t, "admins: []\n")
+	assert.Equal(t, threadConfig{AutoArchive: defaultThreadArchive}, chat.getThreadConfig("guild"))
+}
+
+func TestThreadName(t *testing.T) {
+	tests := []struct {
+		content string
+		want    string
+	}{
+		{"  what's   up\n today ", "what's up today"},
+		{"", defaultThreadName},
+		{" \n ", defaultThreadName},
+		{strings.Repeat("a", 100), strings.Repeat("a", maxThreadNameLength-3) + "..."},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.want, threadName(test.content), test.content)
+	}
+}
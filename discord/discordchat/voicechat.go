@@ -3,9 +3,11 @@ package discordchat
 import (
 	"aika/ai"
 	"aika/discord/discordai"
+	"aika/usage"
 	"aika/utils"
 	"aika/voice"
 	"aika/voice/transcoding"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -18,7 +20,6 @@ import (
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/sashabaranov/go-openai"
-	"github.com/sashabaranov/go-openai/jsonschema"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/sync/errgroup"
 )
@@ -53,9 +54,22 @@ type Voice struct {
 	// so the last speaker can carry on the conversation
 	lastSpeaker string
 	aiSpeakStop time.Time
+
+	// when speakers were last told she's behind
+	behindMutex  sync.Mutex
+	behindNotice time.Time
+}
+
+// voice must use a fast model. anyone over quota is downgraded
+// to the default model, as in text chats
+func (chat *Voice) getVoiceModel(quota usage.Status) ai.LanguageModel {
+	if quota.Capped {
+		return chat.Brain.Models.Default
+	}
+	return chat.Brain.Models.Voice
 }
 
-func (chat *Voice) streamResponse(speaker *discordgo.User, msg string, output chan string) error {
+func (chat *Voice) streamResponse(ctx context.Context, speaker *discordgo.User, msg string, output chan string) error {
 
 	// convert sender to "chat participant"
 	sender := &ChatParticipant{User: speaker}
@@ -72,14 +86,22 @@ func (chat *Voice) streamResponse(speaker *discordgo.User, msg string, output ch
 		return fmt.Errorf("failed to get chat participants; %w", err)
 	}
 
-	// convert members to Display names
-	memberNames := []string{}
-	for _, member := range members {
-		memberNames = append(memberNames, member.GetDisplayName())
-	}
+	funcs := chat.getAvailableFunctions(chat.Session, speaker, chat.ChatID, discordai.ScopeVoice)
+	quota := chat.checkQuota(speaker.ID, chat.ChatID)
+	model := chat.getVoiceModel(quota)
 
 	// system message constructor
-	system := chat.Brain.BuildVoiceSystemMessage(memberNames)
+	channelID := ""
+	if chat.Connection != nil {
+		channelID = chat.Connection.ChannelID
+	}
+	system := chat.Brain.BuildVoiceSystemMessage(
+		chat.getPersona(chat.ChatID, channelID),
+		chat.getPromptContext(chat.Session, chat.ChatID, channelID, members, model, funcs),
+	)
+	if quota.Capped {
+		system.Content += "\n\n" + quotaCappedPrompt
+	}
 	history := chat.History
 	message := openai.ChatCompletionMessage{
 		Role:    openai.ChatMessageRoleUser,
@@ -89,8 +111,6 @@ func (chat *Voice) streamResponse(speaker *discordgo.User, msg string, output ch
 
 	logrus.WithField("system", system).Debugln("system voice message")
 
-	funcs := chat.getAvailableFunctions(chat.Session, speaker)
-
 	pipe := utils.NewStringPipe('|')
 
 	group := errgroup.Group{}
@@ -102,14 +122,15 @@ func (chat *Voice) streamResponse(speaker *discordgo.User, msg string, output ch
 		defer pipe.Close()
 
 		new_history, err := chat.Brain.ProcessChunked(
-			chat.Ctx,
+			ctx,
 			pipe,
 			system,
 			history,
 			message,
 			funcs,
-			ai.LanguageModel_GPT4o, // voice must use turbo model
+			model,
 			chat.getInternalArgs(chat.Session, speaker, chat.ChatID, chat.Connection.ChannelID),
+			nil,
 		)
 		if err != nil {
 			logrus.
@@ -227,7 +248,7 @@ func (chat *Voice) getChatMembers() ([]*ChatParticipant, error) {
 		}
 
 		dedupeID[member.User.ID] = true
-		participants = append(participants, &ChatParticipant{User: member.User})
+		participants = append(participants, &ChatParticipant{User: member.User, Member: member})
 	}
 
 	return participants, nil
@@ -255,6 +276,14 @@ func (vc *Voice) JoinVoice(guild string, channel string) error {
 	}
 	vc.Connection = conn
 
+	// speak with the persona's voice
+	if voiceID := vc.getPersona(guild, channel).Voice; voiceID != "" {
+		err = vc.Speaker.SetVoice(voiceID)
+		if err != nil {
+			logrus.WithError(err).WithField("voice", voiceID).Warnln("failed to set persona voice")
+		}
+	}
+
 	// TODO: clean up the mixer proxy
 
 	if vc.Mixer != nil {
@@ -364,11 +393,29 @@ func (vc *Voice) onSpeakingStop(speakerID string, packets []*discordgo.Packet) {
 		return
 	}
 
-	text, err := vc.Brain.SpeechToText(vc.Ctx, waveFile)
+	// bill usage to the speaker
+	channelID := ""
+	if vc.Connection != nil {
+		channelID = vc.Connection.ChannelID
+	}
+	ctx := usage.WithAttribution(vc.Ctx, usage.Attribution{
+		GuildID:   vc.ChatID,
+		ChannelID: channelID,
+		UserID:    speakerID,
+	})
+	// the voice chat history is summarized in the background
+	ctx = discordai.WithConversation(ctx, "voice/"+vc.ChatID)
+
+	text, err := vc.Brain.SpeechToText(ctx, waveFile)
 	if err != nil {
 		logrus.WithError(err).Errorln("failed whisper transcription")
 		return
 	}
+	usage.Record(ctx, usage.Usage{
+		Kind:         usage.KindTranscription,
+		Model:        vc.Brain.Models.Transcription,
+		AudioSeconds: duration.Seconds(),
+	})
 
 	// clean wave file from disk so i don't leak
 	defer os.Remove(waveFile)
@@ -391,19 +438,100 @@ func (vc *Voice) onSpeakingStop(speakerID string, packets []*discordgo.Packet) {
 		}()
 	}
 
-	// lock here since aika is processing an existing message
-	locked := vc.Mutex.TryLock()
-	if !locked {
-		// she's processing some other spoken message
+	// she answers one spoken message at a time
+	_, _, err = vc.queue.push(&utterance{
+		speakerID:  speakerID,
+		member:     member,
+		text:       text,
+		ctx:        ctx,
+		channelID:  channelID,
+		duration:   duration,
+		clipURL:    clip_url,
+		start:      full_start,
+		sttLatency: time.Since(stt_start),
+		respond:    vc.respond,
+	})
+	if err != nil {
 		logrus.
+			WithError(err).
 			WithField("speaker", speakerID).
 			Warnln("missed spoken message due to processing")
+		vc.noticeBehind(channelID, speakerID)
+	}
+}
+
+// noticeBehind tells the speaker in the voice channel's text chat that
+// aika skipped what they said. talking over her own answers would be worse,
+// & once is enough while she catches up
+func (vc *Voice) noticeBehind(channelID string, speakerID string) {
+	if channelID == "" {
+		return
+	}
 
+	vc.behindMutex.Lock()
+	if time.Since(vc.behindNotice) < coalesceWindow {
+		vc.behindMutex.Unlock()
 		return
 	}
+	vc.behindNotice = time.Now()
+	vc.behindMutex.Unlock()
+
+	_, err := vc.Session.ChannelMessageSend(channelID, fmt.Sprintf(voiceBehindMessage, "<@"+speakerID+">"))
+	if err != nil {
+		logrus.WithError(err).WithField("channel", channelID).Warnln("failed to send voice queue notice")
+	}
+}
+
+// utterance is a transcribed voice message waiting for aika's turn
+type utterance struct {
+	speakerID string
+	member    *discordgo.Member
+	text      string
+	// billed to the speaker
+	ctx       context.Context
+	channelID string
+
+	duration   time.Duration
+	clipURL    string
+	start      time.Time
+	sttLatency time.Duration
+
+	respond func(*utterance)
+}
+
+func (u *utterance) sender() string {
+	return u.speakerID
+}
+
+// people pause mid sentence - the halves are answered together.
+// timing stays with the first half since that's when they started talking.
+func (u *utterance) merge(next queuedMessage) bool {
+	follow, ok := next.(*utterance)
+	if !ok {
+		return false
+	}
+	u.text += " " + follow.text
+	return true
+}
+
+func (u *utterance) run() {
+	u.respond(u)
+}
+
+// respond runs from the queue - one spoken message at a time
+func (vc *Voice) respond(u *utterance) {
+	vc.Mutex.Lock()
 	defer vc.Mutex.Unlock()
 
-	stt_latency := time.Since(stt_start)
+	speakerID := u.speakerID
+	member := u.member
+	text := u.text
+	ctx := u.ctx
+	channelID := u.channelID
+	duration := u.duration
+	clip_url := u.clipURL
+	full_start := u.start
+	stt_latency := u.sttLatency
 
 	// if she cannot talk (for leaving chat) exit early
 	if vc.Connection == nil {
@@ -416,6 +544,19 @@ func (vc *Voice) onSpeakingStop(speakerID string, packets []*discordgo.Packet) {
 		return
 	}
 
+	// nobody to send the quota message to - just ignore them
+	if vc.checkQuota(speakerID, vc.ChatID).Blocked {
+		logrus.WithField("speaker", speakerID).Debugln("dropped voice message over quota")
+		return
+	}
+	usage.Record(ctx, usage.Usage{Kind: usage.KindMessage})
+
+	text, blocked := vc.moderateInput(ctx, vc.ChatID, speakerID, text)
+	if blocked {
+		// aika won't repeat it - or answer it
+		return
+	}
+
 	logrus.
 		WithField("clip", duration.String()).
 		WithField("input", text).
@@ -443,7 +584,7 @@ func (vc *Voice) onSpeakingStop(speakerID string, packets []*discordgo.Packet) {
 
 		ai_start := time.Now()
 
-		err := vc.streamResponse(member.User, text, speakChan)
+		err := vc.streamResponse(ctx, member.User, text, speakChan)
 		if err != nil {
 			return fmt.Errorf("failed to stream response; %w", err)
 		}
@@ -456,6 +597,7 @@ func (vc *Voice) onSpeakingStop(speakerID string, packets []*discordgo.Packet) {
 	// Text To Speech
 	//
 	full_response := ""
+	moderated := false
 	group.Go(func() error {
 		chat_start := time.Now()
 		var once sync.Once
@@ -467,20 +609,27 @@ func (vc *Voice) onSpeakingStop(speakerID string, packets []*discordgo.Packet) {
 
 			once.Do(func() { chat_first_latency = time.Since(chat_start) })
 
-			full_response += response + "|"
+			// every line is checked before it's spoken
+			checked, blocked := vc.moderateOutput(ctx, vc.ChatID, speakerID, response)
+			if blocked {
+				checked = ""
+			}
+			moderated = moderated || checked != response
+
+			full_response += checked + "|"
 
 			if vc.Connection == nil {
 				continue // can't talk but need to drain speakChan
 			}
 
-			clean_response := strings.TrimSpace(response)
+			clean_response := strings.TrimSpace(checked)
 			if clean_response == "" {
 				logrus.Warnln("blank text cannot stream speach for")
 				continue // literally nothing to say
 			}
 
 			logrus.WithField("line", clean_response).Debug("speaking message")
-			err = vc.streamSpeech(clean_response)
+			err := vc.streamSpeech(ctx, clean_response)
 			if err != nil {
 				return fmt.Errorf("failed to stream tts; %w", err)
 			}
@@ -490,12 +639,27 @@ func (vc *Voice) onSpeakingStop(speakerID string, packets []*discordgo.Packet) {
 		return nil
 	})
 
-	err = group.Wait()
+	err := group.Wait()
 	if err != nil {
 		logrus.WithError(err).Errorln("failed to talk in chat")
 		return
 	}
 
+	// aika shouldn't remember saying something she wasn't allowed to
+	if moderated && len(vc.History) > 0 {
+		vc.History[len(vc.History)-1].Content = strings.TrimSuffix(full_response, "|")
+	}
+	if len(vc.History) > 0 {
+		vc.archiveExchange(ctx, discordai.Exchange{
+			GuildID:   vc.ChatID,
+			ChannelID: channelID,
+			AuthorID:  speakerID,
+			Author:    (&ChatParticipant{User: member.User}).GetDisplayName(),
+			Message:   text,
+			Response:  vc.History[len(vc.History)-1].Content,
+		})
+	}
+
 	vc.lastSpeaker = speakerID
 	vc.aiSpeakStop = time.Now()
 
@@ -549,7 +713,7 @@ func (vc *Voice) isValidVoiceMessage(speakerID string, text string, speakingStar
 }
 
 // stream the content to voice via TTS
-func (vc *Voice) streamSpeech(content string) error {
+func (vc *Voice) streamSpeech(ctx context.Context, content string) error {
 	pr, pw := io.Pipe()
 
 	group := errgroup.Group{}
@@ -564,6 +728,15 @@ func (vc *Voice) streamSpeech(content string) error {
 			return fmt.Errorf("failed to stream tts; %w", err)
 		}
 
+		// google tts is free
+		if _, ok := vc.Speaker.(*voice.ElevenLabs); ok {
+			usage.Record(ctx, usage.Usage{
+				Kind:       usage.KindSpeech,
+				Model:      "elevenlabs",
+				Characters: len(content),
+			})
+		}
+
 		return nil
 	})
 
@@ -607,85 +780,64 @@ func (vc *Voice) streamSpeech(content string) error {
 
 // ------------- FUNCTIONS for AI to call which can call CONNECT and DISCONNECT
 
+// voice functions need the "voice" feature, which is
+// only enabled for chats with a voice connection
+
 // SCUFFED - put these somewhere else lmfao
 
 func (vc *Voice) GetFunction_GetVoices() discordai.Function {
-	return discordai.Function{
-		Definition: definition_getVoices,
-		Handler:    vc.handle_getVoices,
-	}
+	return discordai.NewFunction(
+		"getVoices",
+		"Get all support speech voice names and IDs.",
+		vc.handle_getVoices,
+	).
+		WithTier(discordai.TierSubscriber).
+		WithFlags("voice").
+		WithStatus("🎙️ checking voices")
 }
 
 func (vc *Voice) GetFunction_SetVoice() discordai.Function {
-	return discordai.Function{
-		Definition: definition_setVoice,
-		Handler:    vc.handle_setVoice,
-	}
+	return discordai.NewFunction(
+		"setVoice",
+		"Set the speech voice by name or ID",
+		vc.handle_setVoice,
+	).
+		WithTier(discordai.TierSubscriber).
+		WithFlags("voice").
+		WithStatus("🎙️ changing voice")
 }
 
 func (vc *Voice) GetFunction_JoinChannel() discordai.Function {
-	return discordai.Function{
-		Definition: definition_joinChannel,
-		Handler:    vc.handle_joinChannel,
-	}
+	return discordai.NewFunction(
+		"joinVoiceChat",
+		"Connect to the sender's voice chat.",
+		vc.handle_joinChannel,
+	).
+		WithTier(discordai.TierSubscriber).
+		WithFlags("voice", discordai.FlagExpensive).
+		WithStatus("🔊 joining voice chat")
 }
 func (vc *Voice) GetFunction_LeaveChannel() discordai.Function {
-	return discordai.Function{
-		Definition: definition_leaveChannel,
-		Handler:    vc.handle_leaveChannel,
-	}
-}
-
-var definition_setVoice = openai.FunctionDefinition{
-	Name:        "setVoice",
-	Description: "Set the speech voice by name or ID",
-	Parameters: jsonschema.Definition{
-		Type: jsonschema.Object,
-		Properties: map[string]jsonschema.Definition{
-			"nameOrID": {
-				Type:        jsonschema.String,
-				Description: "desired voice name OR ID.",
-				Properties:  map[string]jsonschema.Definition{},
-			},
-		},
-		Required: []string{"nameOrID"},
-	},
+	return discordai.NewFunction(
+		"leaveVoiceChat",
+		"Disconnect from the voice chat.",
+		vc.handle_leaveChannel,
+	).
+		WithTier(discordai.TierSubscriber).
+		WithFlags("voice").
+		WithStatus("🔇 leaving voice chat")
 }
 
-var definition_getVoices = openai.FunctionDefinition{
-	Name:        "getVoices",
-	Description: "Get all support speech voice names and IDs.",
-
-	Parameters: jsonschema.Definition{
-		Type:       jsonschema.Object,
-		Properties: map[string]jsonschema.Definition{},
-		Required:   []string{},
-	},
+type args_setVoice struct {
+	NameOrID string `json:"nameOrID" description:"desired voice name OR ID."`
 }
 
-var definition_leaveChannel = openai.FunctionDefinition{
-	Name:        "leaveVoiceChat",
-	Description: "Disconnect from the voice chat.",
-
-	Parameters: jsonschema.Definition{
-		Type:       jsonschema.Object,
-		Properties: map[string]jsonschema.Definition{},
-		Required:   []string{},
-	},
-}
-var definition_joinChannel = openai.FunctionDefinition{
-	Name:        "joinVoiceChat",
-	Description: "Connect to the sender's voice chat.",
-
-	Parameters: jsonschema.Definition{
-		Type:       jsonschema.Object,
-		Properties: map[string]jsonschema.Definition{},
-		Required:   []string{},
-	},
+type args_joinChannel struct {
+	discordai.Sender
 }
 
-func (v *Voice) handle_setVoice(msgMap map[string]interface{}) (string, error) {
-	err := v.Speaker.SetVoice(msgMap["nameOrID"].(string))
+func (v *Voice) handle_setVoice(_ context.Context, args args_setVoice) (string, error) {
+	err := v.Speaker.SetVoice(args.NameOrID)
 	if err != nil {
 		return "", err
 	}
@@ -693,7 +845,7 @@ func (v *Voice) handle_setVoice(msgMap map[string]interface{}) (string, error) {
 	return "voice set", nil
 }
 
-func (v *Voice) handle_getVoices(msgMap map[string]interface{}) (string, error) {
+func (v *Voice) handle_getVoices(_ context.Context, _ struct{}) (string, error) {
 	voices, err := v.Speaker.GetVoices()
 	if err != nil {
 		return "", err
@@ -706,13 +858,13 @@ func (v *Voice) handle_getVoices(msgMap map[string]interface{}) (string, error)
 	return string(data), nil
 }
 
-func (v *Voice) handle_joinChannel(msgMap map[string]interface{}) (string, error) {
+func (v *Voice) handle_joinChannel(_ context.Context, args args_joinChannel) (string, error) {
 
-	guild := msgMap["internal_sender_guildid"].(string)
-	channel := msgMap["internal_sender_author_vc"].(string)
+	guild := args.GuildID
+	channel := args.AuthorVC
 
 	// if user is not in a voice channel & no channelID was provided we can't make it work
-	if msgMap["internal_sender_author_vc"].(string) == "" {
+	if channel == "" {
 		return "user is not in a voice chat.", nil
 	}
 
@@ -725,7 +877,7 @@ func (v *Voice) handle_joinChannel(msgMap map[string]interface{}) (string, error
 	return "connected successfully", nil
 }
 
-func (v *Voice) handle_leaveChannel(msgMap map[string]interface{}) (string, error) {
+func (v *Voice) handle_leaveChannel(_ context.Context, _ struct{}) (string, error) {
 	// call function
 	err := v.LeaveVoice()
 	if err != nil {
@@ -0,0 +1,148 @@
+package discord
+
+import (
+	"aika/discord/discordchat"
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// quoted messages are context, not the question - keep them short
+	maxQuotedLength      = 1500
+	maxQuotedEmbedLength = 300
+)
+
+// mentionsBot returns true if the message pings aika or replies to one of her messages.
+// every message is checked, so replied-to messages are never fetched here -
+// discord usually sends them with the reply, otherwise they may be cached.
+func mentionsBot(state *discordgo.State, m *discordgo.MessageCreate) bool {
+	for _, mention := range m.Mentions {
+		//TODO: this doesn't work if a user "copies" thei @aika from another message
+		// why trhe fuck is discord like this?
+		// what the fuck when I copy @Aika i get a MENTIONROLES but not a Mention ?!
+		// NOTE: if you copy an @ in discord
+		//		you can't click the @ and see their profile
+		//		this is a discord fuckery
+		if mention.ID == state.User.ID {
+			return true
+		}
+	}
+
+	ref := cachedReference(state, m)
+	return ref != nil && ref.Author != nil && ref.Author.ID == state.User.ID
+}
+
+// quoteReference adds the message m replies to to its content.
+// replies are about the message they reply to
+func quoteReference(s *discordgo.Session, m *discordgo.MessageCreate) {
+	ref := referencedMessage(s, m)
+	if ref == nil {
+		return
+	}
+	if quote := quoteMessage(ref, s.State.User.ID); quote != "" {
+		m.Content += "\n\n" + quote
+	}
+}
+
+// the message m replies to if discord sent it or it's cached (nil if it isn't a reply)
+func cachedReference(state *discordgo.State, m *discordgo.MessageCreate) *discordgo.Message {
+	ref := m.MessageReference
+	if ref == nil || m.Type != discordgo.MessageTypeReply {
+		return nil
+	}
+	// discord usually sends it with the reply
+	if m.ReferencedMessage != nil {
+		return m.ReferencedMessage
+	}
+
+	msg, err := state.Message(ref.ChannelID, ref.MessageID)
+	if err != nil {
+		return nil
+	}
+	return msg
+}
+
+// referencedMessage returns the message m replies to (nil if it isn't a reply)
+// it's fetched from discord if it isn't cached
+func referencedMessage(s *discordgo.Session, m *discordgo.MessageCreate) *discordgo.Message {
+	if msg := cachedReference(s.State, m); msg != nil {
+		return msg
+	}
+	ref := m.MessageReference
+	if ref == nil || m.Type != discordgo.MessageTypeReply {
+		return nil
+	}
+
+	msg, err := s.ChannelMessage(ref.ChannelID, ref.MessageID)
+	if err != nil {
+		// deleted or aika can't see the channel
+		logrus.WithError(err).WithField("message", ref.MessageID).Warnln("failed to get replied-to message")
+		return nil
+	}
+	return msg
+}
+
+// quoteMessage formats a replied-to message as context for aika
+//
+//	*replying to Username*:
+//	> what the message said
+//	> - https://cdn.discordapp.com/attachments/.../cat.png
+func quoteMessage(msg *discordgo.Message, botID string) string {
+	author := "Aika"
+	if msg.Author != nil && msg.Author.ID != botID {
+		author = (&discordchat.ChatParticipant{User: msg.Author}).GetDisplayName()
+	}
+
+	content := msg.Content
+	for _, mention := range msg.Mentions {
+		participant := &discordchat.ChatParticipant{User: mention}
+		content = strings.ReplaceAll(content, participant.GetMentionString(), participant.GetDisplayName())
+	}
+	lines := []string{}
+	if content != "" {
+		lines = append(lines, strings.Split(truncateQuote(content, maxQuotedLength), "\n")...)
+	}
+
+	for _, att := range msg.Attachments {
+		lines = append(lines, "- "+att.URL)
+	}
+	for _, embed := range msg.Embeds {
+		if line := embedSummary(embed); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("*replying to %s*:\n> %s", author, strings.Join(lines, "\n> "))
+}
+
+// "[embed] title - description (url)"
+func embedSummary(embed *discordgo.MessageEmbed) string {
+	parts := []string{}
+	if embed.Title != "" {
+		parts = append(parts, embed.Title)
+	}
+	if embed.Description != "" {
+		parts = append(parts, strings.Join(strings.Fields(embed.Description), " "))
+	}
+	summary := truncateQuote(strings.Join(parts, " - "), maxQuotedEmbedLength)
+	if embed.URL != "" {
+		summary = strings.TrimSpace(summary + " (" + embed.URL + ")")
+	}
+	if summary == "" {
+		return ""
+	}
+	return "[embed] " + summary
+}
+
+func truncateQuote(text string, length int) string {
+	if len(text) <= length {
+		return text
+	}
+	return strings.ToValidUTF8(text[:length], "") + "..."
+}
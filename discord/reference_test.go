@@ -0,0 +1,129 @@
+package discord
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuoteMessage(t *testing.T) {
+	alice := &discordgo.User{ID: "alice", Username: "alice_99!"}
+	bob := &discordgo.User{ID: "bob", Username: "bob"}
+
+	tests := []struct {
+		name string
+		msg  *discordgo.Message
+		want string
+	}{
+		{
+			name: "mentions are display names",
+			msg:  &discordgo.Message{Author: alice, Content: "hi <@bob>\nhow are you", Mentions: []*discordgo.User{bob}},
+			want: "*replying to alice99*:\n> hi bob\n> how are you",
+		},
+		{
+			name: "aika's own messages",
+			msg:  &discordgo.Message{Author: &discordgo.User{ID: "aika"}, Content: "hmph"},
+			want: "*replying to Aika*:\n> hmph",
+		},
+		{
+			name: "attachments & embeds",
+			msg: &discordgo.Message{
+				Author:      bob,
+				Attachments: []*discordgo.MessageAttachment{{URL: "https://cdn.example/cat.png"}},
+				Embeds:      []*discordgo.MessageEmbed{{Title: "Cats", URL: "https://example.com"}},
+			},
+			want: "*replying to bob*:\n> - https://cdn.example/cat.png\n> [embed] Cats (https://example.com)",
+		},
+		{
+			name: "nothing to quote",
+			msg:  &discordgo.Message{Author: bob},
+			want: "",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, quoteMessage(test.msg, "aika"))
+		})
+	}
+}
+
+func TestQuoteMessageTruncates(t *testing.T) {
+	msg := &discordgo.Message{Author: &discordgo.User{ID: "bob", Username: "bob"}, Content: strings.Repeat("a", maxQuotedLength+100)}
+
+	quote := quoteMessage(msg, "aika")
+	assert.True(t, strings.HasSuffix(quote, "..."))
+	assert.Less(t, len(quote), maxQuotedLength+50)
+}
+
+func TestEmbedSummary(t *testing.T) {
+	tests := []struct {
+		name  string
+		embed *discordgo.MessageEmbed
+		want  string
+	}{
+		{"everything", &discordgo.MessageEmbed{Title: "Title", Description: "some\n  text", URL: "https://example.com"}, "[embed] Title - some text (https://example.com)"},
+		{"url only", &discordgo.MessageEmbed{URL: "https://example.com"}, "[embed] (https://example.com)"},
+		{"empty", &discordgo.MessageEmbed{}, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, embedSummary(test.embed))
+		})
+	}
+
+	long := embedSummary(&discordgo.MessageEmbed{Description: strings.Repeat("a", maxQuotedEmbedLength*2)})
+	assert.Equal(t, "[embed] "+strings.Repeat("a", maxQuotedEmbedLength)+"...", long)
+}
+
+func TestMentionsBot(t *testing.T) {
+	state := discordgo.NewState()
+	state.User = &discordgo.User{ID: "aika"}
+	state.MaxMessageCount = 10
+	require.NoError(t, state.ChannelAdd(&discordgo.Channel{ID: "channel", Type: discordgo.ChannelTypeDM}))
+	require.NoError(t, state.MessageAdd(&discordgo.Message{ID: "cached", ChannelID: "channel", Author: &discordgo.User{ID: "aika"}}))
+
+	aika := &discordgo.Message{ID: "aika-message", Author: &discordgo.User{ID: "aika"}}
+	bob := &discordgo.Message{ID: "bob-message", Author: &discordgo.User{ID: "bob"}}
+
+	reply := func(to *discordgo.Message, messageID string) *discordgo.MessageCreate {
+		return &discordgo.MessageCreate{Message: &discordgo.Message{
+			Type:              discordgo.MessageTypeReply,
+			MessageReference:  &discordgo.MessageReference{ChannelID: "channel", MessageID: messageID},
+			ReferencedMessage: to,
+		}}
+	}
+
+	tests := []struct {
+		name string
+		m    *discordgo.MessageCreate
+		want bool
+	}{
+		{"mention", &discordgo.MessageCreate{Message: &discordgo.Message{Mentions: []*discordgo.User{{ID: "bob"}, {ID: "aika"}}}}, true},
+		{"someone else", &discordgo.MessageCreate{Message: &discordgo.Message{Mentions: []*discordgo.User{{ID: "bob"}}}}, false},
+		{"reply to aika", reply(aika, aika.ID), true},
+		{"reply to someone else", reply(bob, bob.ID), false},
+		{"reply to cached message", reply(nil, "cached"), true},
+		// uncached messages aren't fetched just to check
+		{"reply to unknown message", reply(nil, "unknown"), false},
+		{
+			"forwarded message",
+			&discordgo.MessageCreate{Message: &discordgo.Message{
+				Type:              discordgo.MessageTypeDefault,
+				MessageReference:  &discordgo.MessageReference{ChannelID: "channel", MessageID: aika.ID},
+				ReferencedMessage: aika,
+			}},
+			false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, mentionsBot(state, test.m))
+		})
+	}
+}
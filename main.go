@@ -6,9 +6,13 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"aika/ai"
 	"aika/discord"
+	"aika/discord/discordai"
 	"aika/storage"
+	"aika/usage"
 
 	"github.com/sashabaranov/go-openai"
 	"github.com/sirupsen/logrus"
@@ -68,17 +72,58 @@ func main() {
 		logrus.WithError(err).Fatalln("error reading config.yaml")
 	}
 
+	memory, err := discordai.NewMemory("./data/memory.json")
+	if err != nil {
+		logrus.WithError(err).Fatalln("error reading memory.json")
+	}
+
+	personas, err := discordai.LoadPersonas("./data/personas", "./data/personas.json")
+	if err != nil {
+		logrus.WithError(err).Fatalln("error loading personas")
+	}
+
+	lorebook, err := discordai.NewLorebook("./data/lorebook.json")
+	if err != nil {
+		logrus.WithError(err).Fatalln("error reading lorebook.json")
+	}
+
+	knowledge := discordai.NewKnowledgeBase("./data/knowledge")
+	archive := discordai.NewArchive("./data/conversations")
+
+	ledger, err := usage.NewLedger("./data/usage.json")
+	if err != nil {
+		logrus.WithError(err).Fatalln("error reading usage.json")
+	}
+	go ledger.FlushEvery(ctx, time.Minute)
+
+	// LLM provider can be any OpenAI compatible API
+	llmKey, exists := os.LookupEnv("LLM_API_KEY")
+	if !exists {
+		llmKey = openaiKey
+	}
+	llmURL, _ := cfg.GetString("llm_base_url")
+	provider := ai.NewOpenAICompatible(llmURL, llmKey)
+
 	config := openai.DefaultConfig(openaiKey)
 	// default: https://api.openai.com/v1
-	config.BaseURL = "https://gateway.ai.cloudflare.com/v1/10c870e2abe3417ea2697fd5a080e634/open-ai/openai"
+	if openaiURL, ok := cfg.GetString("openai_base_url"); ok {
+		config.BaseURL = openaiURL
+	}
 
 	logrus.WithField("discord_key", discordKey[0:3]).Debugln("starting chatbot...")
 	_, err = discord.StartChatbot(
 		ctx,
 		discordKey,
 		openai.NewClientWithConfig(config),
+		provider,
 		s3,
 		cfg,
+		memory,
+		personas,
+		lorebook,
+		knowledge,
+		archive,
+		ledger,
 	)
 	if err != nil {
 		logrus.WithError(err).Fatalln("failed to init discord bot")
@@ -87,4 +132,8 @@ func main() {
 	<-ctx.Done()
 	logrus.Infoln("shutdown")
 	// do things that exit w/ ctx cancellation
+	err = ledger.Flush()
+	if err != nil {
+		logrus.WithError(err).Errorln("failed to save usage")
+	}
 }
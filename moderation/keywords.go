@@ -0,0 +1,59 @@
+package moderation
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// category of keyword matches
+const CategoryKeyword = "keyword"
+
+// Keywords flags text containing words or regular expressions
+type Keywords struct {
+	patterns []*regexp.Regexp
+}
+
+var _ Classifier = &Keywords{}
+
+// NewKeywords compiles a keyword list.
+// Entries are matched as whole words ignoring case.
+// Entries wrapped in slashes are regular expressions - "/sl[u]r+/"
+func NewKeywords(keywords []string) (*Keywords, error) {
+	k := &Keywords{}
+	for _, keyword := range keywords {
+		keyword = strings.TrimSpace(keyword)
+		if keyword == "" {
+			continue
+		}
+
+		expr := `(?i)\b` + regexp.QuoteMeta(keyword) + `\b`
+		if len(keyword) > 2 && strings.HasPrefix(keyword, "/") && strings.HasSuffix(keyword, "/") {
+			expr = "(?i)" + keyword[1:len(keyword)-1]
+		}
+
+		pattern, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid keyword '%s'; %w", keyword, err)
+		}
+		k.patterns = append(k.patterns, pattern)
+	}
+	return k, nil
+}
+
+func (k *Keywords) Classify(_ context.Context, text string) (Result, error) {
+	result := Result{}
+	for _, pattern := range k.patterns {
+		for _, match := range pattern.FindAllStringIndex(text, -1) {
+			if match[1] > match[0] {
+				result.Matches = append(result.Matches, [2]int{match[0], match[1]})
+			}
+		}
+	}
+	if len(result.Matches) > 0 {
+		result.Flagged = true
+		result.Categories = []string{CategoryKeyword}
+	}
+	return result, nil
+}
@@ -0,0 +1,126 @@
+package moderation
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// shown in place of redacted text
+const Redacted = "[redacted]"
+
+// Classifier flags harmful text
+type Classifier interface {
+	Classify(ctx context.Context, text string) (Result, error)
+}
+
+// Result is what a classifier thought of some text
+type Result struct {
+	Flagged bool
+	// why it was flagged - "hate", "keyword"
+	Categories []string
+	// byte ranges of the text which were flagged - [start, end)
+	// empty when the whole text was flagged
+	Matches [][2]int
+}
+
+// Merge combines the results of two classifiers
+func (r Result) Merge(other Result) Result {
+	r.Flagged = r.Flagged || other.Flagged
+	for _, category := range other.Categories {
+		if !contains(r.Categories, category) {
+			r.Categories = append(r.Categories, category)
+		}
+	}
+	r.Matches = append(r.Matches, other.Matches...)
+	return r
+}
+
+// Moderator checks text with a classifier & keyword lists
+type Moderator struct {
+	// optional - keywords are used alone when missing or failing
+	Classifier Classifier
+}
+
+// Check classifies the text.
+// Keywords are always checked - they're free & catch words the classifier doesn't.
+func (m *Moderator) Check(ctx context.Context, text string, keywords *Keywords) Result {
+	result := Result{}
+	if keywords != nil {
+		result, _ = keywords.Classify(ctx, text)
+	}
+
+	if m.Classifier != nil && strings.TrimSpace(text) != "" {
+		classified, err := m.Classifier.Classify(ctx, text)
+		if err != nil {
+			// better to let a message through than to stop aika replying
+			logrus.WithError(err).Warnln("moderation classifier failed - using keywords only")
+		} else {
+			result = result.Merge(classified)
+		}
+	}
+
+	return result
+}
+
+// Apply returns the text to use after moderation & if it was blocked
+func Apply(action Action, text string, result Result) (string, bool) {
+	if !result.Flagged {
+		return text, false
+	}
+
+	switch action {
+	case ActionBlock:
+		return "", true
+	case ActionRedact:
+		return redact(text, result), false
+	default:
+		return text, false
+	}
+}
+
+// replace the flagged ranges - or everything if the classifier
+// flagged the text as a whole
+func redact(text string, result Result) string {
+	for _, category := range result.Categories {
+		if category != CategoryKeyword {
+			return Redacted
+		}
+	}
+	if len(result.Matches) == 0 {
+		return Redacted
+	}
+
+	matches := append([][2]int{}, result.Matches...)
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i][0] < matches[j][0]
+	})
+
+	redacted := strings.Builder{}
+	last := 0
+	for _, match := range matches {
+		if match[0] < last {
+			// overlapping matches
+			match[0] = last
+		}
+		if match[1] <= match[0] {
+			continue
+		}
+		redacted.WriteString(text[last:match[0]])
+		redacted.WriteString(Redacted)
+		last = match[1]
+	}
+	redacted.WriteString(text[last:])
+	return redacted.String()
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
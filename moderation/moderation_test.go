@@ -0,0 +1,120 @@
+package moderation
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+type fakeClassifier struct {
+	result Result
+	err    error
+}
+
+func (f *fakeClassifier) Classify(_ context.Context, _ string) (Result, error) {
+	return f.result, f.err
+}
+
+func TestKeywords(t *testing.T) {
+	keywords, err := NewKeywords([]string{"frick", "/d+a+r+n+/", " "})
+	require.NoError(t, err)
+
+	result, err := keywords.Classify(context.Background(), "Frick this, daaarn it. fricking fine")
+	require.NoError(t, err)
+	assert.True(t, result.Flagged)
+	assert.Equal(t, []string{CategoryKeyword}, result.Categories)
+	// whole words only - "fricking" isn't matched
+	assert.Len(t, result.Matches, 2)
+
+	result, _ = keywords.Classify(context.Background(), "all good here")
+	assert.False(t, result.Flagged)
+
+	_, err = NewKeywords([]string{"/(unclosed/"})
+	assert.Error(t, err)
+}
+
+func TestApply(t *testing.T) {
+	keywords, _ := NewKeywords([]string{"frick", "darn"})
+	text := "frick that darn thing"
+	result, _ := keywords.Classify(context.Background(), text)
+
+	moderated, blocked := Apply(ActionRedact, text, result)
+	assert.False(t, blocked)
+	assert.Equal(t, "[redacted] that [redacted] thing", moderated)
+
+	moderated, blocked = Apply(ActionBlock, text, result)
+	assert.True(t, blocked)
+	assert.Empty(t, moderated)
+
+	moderated, blocked = Apply(ActionLog, text, result)
+	assert.False(t, blocked)
+	assert.Equal(t, text, moderated)
+
+	// unflagged text is untouched whatever the action
+	moderated, blocked = Apply(ActionBlock, "hello", Result{})
+	assert.False(t, blocked)
+	assert.Equal(t, "hello", moderated)
+
+	// classifiers flag the whole text
+	moderated, _ = Apply(ActionRedact, text, result.Merge(Result{Flagged: true, Categories: []string{"hate"}}))
+	assert.Equal(t, Redacted, moderated)
+}
+
+func TestModeratorCheck(t *testing.T) {
+	keywords, _ := NewKeywords([]string{"frick"})
+	ctx := context.Background()
+
+	moderator := &Moderator{Classifier: &fakeClassifier{result: Result{Flagged: true, Categories: []string{"harassment"}}}}
+	result := moderator.Check(ctx, "you frick", keywords)
+	assert.True(t, result.Flagged)
+	assert.ElementsMatch(t, []string{CategoryKeyword, "harassment"}, result.Categories)
+
+	// keywords still work when the classifier fails
+	moderator = &Moderator{Classifier: &fakeClassifier{err: errors.New("down")}}
+	assert.True(t, moderator.Check(ctx, "you frick", keywords).Flagged)
+	assert.False(t, moderator.Check(ctx, "hello", keywords).Flagged)
+
+	// no classifier - keywords only
+	moderator = &Moderator{}
+	assert.True(t, moderator.Check(ctx, "you frick", keywords).Flagged)
+	assert.False(t, moderator.Check(ctx, "you frick", nil).Flagged)
+}
+
+func TestFlaggedCategories(t *testing.T) {
+	categories := flaggedCategories(openai.ResultCategories{Hate: true, SelfHarmIntent: true})
+	assert.Equal(t, []string{"hate", "self-harm/intent"}, categories)
+}
+
+func TestParsePolicy(t *testing.T) {
+	parse := func(text string) (Policy, error) {
+		var value interface{}
+		require.NoError(t, yaml.Unmarshal([]byte(text), &value))
+		return ParsePolicy(value)
+	}
+
+	policy, err := parse("{input: log, output: redact, keywords: [frick]}")
+	require.NoError(t, err)
+	assert.Equal(t, Policy{Input: ActionLog, Output: ActionRedact, Keywords: []string{"frick"}}, policy)
+
+	guild, err := parse("{output: block, keywords: [darn]}")
+	require.NoError(t, err)
+	merged := policy.Override(guild)
+	assert.Equal(t, Policy{Input: ActionLog, Output: ActionBlock, Keywords: []string{"frick", "darn"}}, merged)
+
+	for _, invalid := range []string{
+		"{input: delete}",
+		"{output: 5}",
+		"{keywords: frick}",
+		"{keywords: ['/(/']}",
+		"{mystery: true}",
+		"[log]",
+	} {
+		_, err := parse(invalid)
+		assert.ErrorIs(t, err, ErrInvalidPolicy, invalid)
+	}
+}
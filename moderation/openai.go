@@ -0,0 +1,51 @@
+package moderation
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// OpenAI classifies text with the OpenAI moderation endpoint (free to use)
+type OpenAI struct {
+	Client *openai.Client
+	// empty uses the latest moderation model
+	Model string
+}
+
+var _ Classifier = &OpenAI{}
+
+func (o *OpenAI) Classify(ctx context.Context, text string) (Result, error) {
+	resp, err := o.Client.Moderations(ctx, openai.ModerationRequest{
+		Input: text,
+		Model: o.Model,
+	})
+	if err != nil {
+		return Result{}, err
+	}
+	if len(resp.Results) == 0 {
+		return Result{}, errors.New("moderation returned no results")
+	}
+
+	result := Result{Flagged: resp.Results[0].Flagged}
+	if result.Flagged {
+		result.Categories = flaggedCategories(resp.Results[0].Categories)
+	}
+	return result, nil
+}
+
+// names of the true fields - "hate", "self-harm/intent"
+func flaggedCategories(categories openai.ResultCategories) []string {
+	flagged := []string{}
+	value := reflect.ValueOf(categories)
+	for i := 0; i < value.NumField(); i++ {
+		if value.Field(i).Bool() {
+			name, _, _ := strings.Cut(value.Type().Field(i).Tag.Get("json"), ",")
+			flagged = append(flagged, name)
+		}
+	}
+	return flagged
+}
@@ -0,0 +1,103 @@
+package moderation
+
+import (
+	"aika/storage"
+	"errors"
+	"fmt"
+)
+
+var ErrInvalidPolicy = errors.New("invalid moderation policy")
+
+// Action is what happens to flagged text
+type Action string
+
+const (
+	ActionOff    Action = "off"    // not checked
+	ActionLog    Action = "log"    // checked & logged
+	ActionRedact Action = "redact" // flagged words (or everything) replaced
+	ActionBlock  Action = "block"  // dropped entirely
+)
+
+func ParseAction(value string) (Action, error) {
+	switch action := Action(value); action {
+	case ActionOff, ActionLog, ActionRedact, ActionBlock:
+		return action, nil
+	}
+	return ActionOff, fmt.Errorf("unknown action '%s'; %w", value, ErrInvalidPolicy)
+}
+
+// Policy is how messages to & from aika are moderated
+type Policy struct {
+	// messages users send aika
+	Input Action
+	// aika's replies (text & speech)
+	Output Action
+	// flagged on top of the classifier
+	Keywords []string
+}
+
+// Override applies a more specific policy (a guild's) on top of this one.
+// Keywords are added to the list rather than replacing it.
+func (p Policy) Override(other Policy) Policy {
+	if other.Input != "" {
+		p.Input = other.Input
+	}
+	if other.Output != "" {
+		p.Output = other.Output
+	}
+	p.Keywords = append(append([]string{}, p.Keywords...), other.Keywords...)
+	return p
+}
+
+// ParsePolicy reads a policy from config
+//
+//	input: log
+//	output: redact
+//	keywords: ["word", "/regex/"]
+func ParsePolicy(value interface{}) (Policy, error) {
+	policy := Policy{}
+	fields, ok := storage.StringMap(value)
+	if !ok {
+		return policy, ErrInvalidPolicy
+	}
+
+	for key, value := range fields {
+		switch key {
+		case "input", "output":
+			str, ok := value.(string)
+			if !ok {
+				return policy, fmt.Errorf("%s is not an action; %w", key, ErrInvalidPolicy)
+			}
+			action, err := ParseAction(str)
+			if err != nil {
+				return policy, err
+			}
+			if key == "input" {
+				policy.Input = action
+			} else {
+				policy.Output = action
+			}
+		case "keywords":
+			list, ok := value.([]interface{})
+			if !ok {
+				return policy, fmt.Errorf("keywords is not a list; %w", ErrInvalidPolicy)
+			}
+			for _, v := range list {
+				keyword, ok := v.(string)
+				if !ok {
+					return policy, fmt.Errorf("keyword is not a string; %w", ErrInvalidPolicy)
+				}
+				policy.Keywords = append(policy.Keywords, keyword)
+			}
+			if _, err := NewKeywords(policy.Keywords); err != nil {
+				return policy, fmt.Errorf("%w; %w", ErrInvalidPolicy, err)
+			}
+		case "classifier":
+			// read by the bot at startup
+		default:
+			return policy, fmt.Errorf("unknown setting '%s'; %w", key, ErrInvalidPolicy)
+		}
+	}
+
+	return policy, nil
+}
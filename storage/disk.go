@@ -53,6 +53,46 @@ func (d *Disk) Get(key string) (interface{}, bool) {
 	return value, ok
 }
 
+// GetString returns the value for key if it is a string
+func (d *Disk) GetString(key string) (string, bool) {
+	value, ok := d.Get(key)
+	if !ok {
+		return "", false
+	}
+	str, ok := value.(string)
+	return str, ok
+}
+
+// GetMap returns the value for key if it is a map
+func (d *Disk) GetMap(key string) (map[string]interface{}, bool) {
+	value, ok := d.Get(key)
+	if !ok {
+		return nil, false
+	}
+	return StringMap(value)
+}
+
+// StringMap converts a decoded yaml map to a map with string keys
+// yaml decodes maps with interface keys, so keys are converted to strings
+func StringMap(value interface{}) (map[string]interface{}, bool) {
+	switch m := value.(type) {
+	case map[string]interface{}:
+		return m, true
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{})
+		for k, v := range m {
+			str, ok := k.(string)
+			if !ok {
+				return nil, false
+			}
+			result[str] = v
+		}
+		return result, true
+	default:
+		return nil, false
+	}
+}
+
 func (d *Disk) Set(key string, value interface{}) error {
 	d.mutex.Lock()
 	defer d.mutex.Unlock()
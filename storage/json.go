@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// JSON is a typed document persisted to a single JSON file.
+// Every update is flushed to disk so data survives restarts.
+type JSON[T any] struct {
+	data     T
+	filename string
+	mutex    sync.RWMutex
+	// changed since the last flush
+	dirty bool
+}
+
+// NewJSON loads the document from filename.
+// If the file doesn't exist yet the document starts as the zero value of T.
+func NewJSON[T any](filename string) (*JSON[T], error) {
+	j := &JSON[T]{
+		filename: filename,
+	}
+
+	err := j.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return j, nil
+}
+
+func (j *JSON[T]) load() error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	file, err := os.ReadFile(j.filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s; %w", j.filename, err)
+	}
+
+	err = json.Unmarshal(file, &j.data)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s; %w", j.filename, err)
+	}
+
+	return nil
+}
+
+// Read calls fn with the document under a read lock.
+// fn must not modify or retain the document.
+func (j *JSON[T]) Read(fn func(data *T)) {
+	j.mutex.RLock()
+	defer j.mutex.RUnlock()
+
+	fn(&j.data)
+}
+
+// Update calls fn with the document under a write lock
+// and flushes the document to disk if fn succeeds.
+func (j *JSON[T]) Update(fn func(data *T) error) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	err := fn(&j.data)
+	if err != nil {
+		return err
+	}
+
+	return j.flush()
+}
+
+// Change calls fn with the document under a write lock like Update,
+// but the document isn't written to disk until Flush is called.
+// for documents which change too often to write every time.
+func (j *JSON[T]) Change(fn func(data *T) error) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	err := fn(&j.data)
+	if err != nil {
+		return err
+	}
+
+	j.dirty = true
+	return nil
+}
+
+// Flush writes the document to disk if it has unsaved changes
+func (j *JSON[T]) Flush() error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if !j.dirty {
+		return nil
+	}
+	return j.flush()
+}
+
+// write to a temp file & rename so a crash can't leave a half written file
+func (j *JSON[T]) flush() error {
+	data, err := json.MarshalIndent(&j.data, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(j.filename), 0755)
+	if err != nil {
+		return err
+	}
+
+	tmp := j.filename + ".tmp"
+	err = os.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmp, j.filename)
+	if err != nil {
+		return err
+	}
+
+	j.dirty = false
+	return nil
+}
@@ -0,0 +1,343 @@
+package usage
+
+import (
+	"aika/storage"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// day buckets are keyed by UTC date
+const (
+	dayFormat   = "2006-01-02"
+	monthFormat = "2006-01"
+)
+
+// daily totals are kept long enough for monthly quotas & reports
+const retainedDays = 31
+
+// DMs aren't in a guild - their usage is billed here
+const DirectMessages = "dm"
+
+// Totals is accumulated usage
+type Totals struct {
+	Messages         int     `json:"messages,omitempty"`
+	Requests         int     `json:"requests"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	Images           int     `json:"images,omitempty"`
+	AudioSeconds     float64 `json:"audio_seconds,omitempty"`
+	Characters       int     `json:"characters,omitempty"`
+	Cost             float64 `json:"cost_usd"`
+}
+
+func (t *Totals) add(usage Usage, cost float64) {
+	if usage.Kind == KindMessage {
+		t.Messages++
+		return
+	}
+	t.Requests++
+	t.PromptTokens += usage.PromptTokens
+	t.CompletionTokens += usage.CompletionTokens
+	t.Images += usage.Images
+	t.AudioSeconds += usage.AudioSeconds
+	t.Characters += usage.Characters
+	t.Cost += cost
+}
+
+func (t *Totals) merge(other *Totals) {
+	t.Messages += other.Messages
+	t.Requests += other.Requests
+	t.PromptTokens += other.PromptTokens
+	t.CompletionTokens += other.CompletionTokens
+	t.Images += other.Images
+	t.AudioSeconds += other.AudioSeconds
+	t.Characters += other.Characters
+	t.Cost += other.Cost
+}
+
+type ledgerData struct {
+	// day -> guild ID -> totals
+	Days map[string]map[string]*Totals `json:"days"`
+	// day -> user ID -> totals
+	UserDays map[string]map[string]*Totals `json:"user_days"`
+	// running totals
+	Guilds   map[string]*Totals `json:"guilds"`
+	Channels map[string]*Totals `json:"channels"`
+	Users    map[string]*Totals `json:"users"`
+	Models   map[string]*Totals `json:"models"`
+}
+
+// Ledger keeps running usage & cost totals by guild, channel, user and model.
+// Totals are kept in memory & flushed to disk so they survive restarts.
+type Ledger struct {
+	store *storage.JSON[ledgerData]
+	// the day old totals were last pruned - guarded by the store's lock
+	prunedDay string
+
+	pricesMutex sync.RWMutex
+	prices      Prices
+
+	// for tests
+	now func() time.Time
+}
+
+func NewLedger(filename string) (*Ledger, error) {
+	store, err := storage.NewJSON[ledgerData](filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load usage; %w", err)
+	}
+	return &Ledger{
+		store:  store,
+		prices: DefaultPrices(),
+		now:    time.Now,
+	}, nil
+}
+
+// SetPrice overrides the price of a model
+func (l *Ledger) SetPrice(model string, price Price) {
+	l.pricesMutex.Lock()
+	defer l.pricesMutex.Unlock()
+
+	l.prices[model] = price
+}
+
+func (l *Ledger) cost(usage Usage) float64 {
+	l.pricesMutex.RLock()
+	defer l.pricesMutex.RUnlock()
+
+	return l.prices.Cost(usage)
+}
+
+// Record adds the usage to the totals it's attributed to
+func (l *Ledger) Record(attribution Attribution, usage Usage) error {
+	cost := l.cost(usage)
+	day := l.now().UTC().Format(dayFormat)
+
+	guild := attribution.GuildID
+	if guild == "" {
+		guild = DirectMessages
+	}
+
+	return l.store.Change(func(data *ledgerData) error {
+		if l.prunedDay != day {
+			cutoff := l.now().UTC().AddDate(0, 0, -retainedDays).Format(dayFormat)
+			pruneDays(data.Days, cutoff)
+			pruneDays(data.UserDays, cutoff)
+			l.prunedDay = day
+		}
+
+		data.Days = addToDay(data.Days, day, guild, usage, cost)
+
+		data.Guilds = addTo(data.Guilds, guild, usage, cost)
+		if attribution.ChannelID != "" {
+			data.Channels = addTo(data.Channels, attribution.ChannelID, usage, cost)
+		}
+		if attribution.UserID != "" {
+			data.Users = addTo(data.Users, attribution.UserID, usage, cost)
+			data.UserDays = addToDay(data.UserDays, day, attribution.UserID, usage, cost)
+		}
+		if usage.Kind != KindMessage {
+			data.Models = addTo(data.Models, usage.Model, usage, cost)
+		}
+		return nil
+	})
+}
+
+// Flush writes the totals to disk
+func (l *Ledger) Flush() error {
+	return l.store.Flush()
+}
+
+// FlushEvery flushes the totals every interval until ctx is done
+func (l *Ledger) FlushEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := l.Flush()
+			if err != nil {
+				logrus.WithError(err).Warnln("failed to save usage")
+			}
+		}
+	}
+}
+
+// drop days before the cutoff (dayFormat sorts by date)
+func pruneDays(days map[string]map[string]*Totals, cutoff string) {
+	for day := range days {
+		if day < cutoff {
+			delete(days, day)
+		}
+	}
+}
+
+func addTo(totals map[string]*Totals, key string, usage Usage, cost float64) map[string]*Totals {
+	if totals == nil {
+		totals = make(map[string]*Totals)
+	}
+	if totals[key] == nil {
+		totals[key] = &Totals{}
+	}
+	totals[key].add(usage, cost)
+	return totals
+}
+
+func addToDay(days map[string]map[string]*Totals, day string, key string, usage Usage, cost float64) map[string]map[string]*Totals {
+	if days == nil {
+		days = make(map[string]map[string]*Totals)
+	}
+	days[day] = addTo(days[day], key, usage, cost)
+	return days
+}
+
+// --- quotas
+
+// Periods is usage today & this month (UTC)
+type Periods struct {
+	Day   Totals
+	Month Totals
+}
+
+// GuildPeriods returns the guild's usage today & this month
+func (l *Ledger) GuildPeriods(guildID string) Periods {
+	if guildID == "" {
+		guildID = DirectMessages
+	}
+	var periods Periods
+	l.store.Read(func(data *ledgerData) {
+		periods = l.periods(data.Days, guildID)
+	})
+	return periods
+}
+
+// UserPeriods returns the user's usage today & this month
+func (l *Ledger) UserPeriods(userID string) Periods {
+	var periods Periods
+	l.store.Read(func(data *ledgerData) {
+		periods = l.periods(data.UserDays, userID)
+	})
+	return periods
+}
+
+func (l *Ledger) periods(days map[string]map[string]*Totals, key string) Periods {
+	now := l.now().UTC()
+	today := now.Format(dayFormat)
+	month := now.Format(monthFormat)
+
+	periods := Periods{}
+	for day, totals := range days {
+		if !strings.HasPrefix(day, month) || totals[key] == nil {
+			continue
+		}
+		periods.Month.merge(totals[key])
+		if day == today {
+			periods.Day.merge(totals[key])
+		}
+	}
+	return periods
+}
+
+// --- reports
+
+// DaySpend is the cost of each guild on a single day
+type DaySpend struct {
+	Day    string             `json:"day"`
+	Guilds map[string]float64 `json:"guilds_usd"`
+	Total  float64            `json:"total_usd"`
+}
+
+// Spend is the totals for a guild, user or model
+type Spend struct {
+	ID string `json:"id"`
+	Totals
+}
+
+// Report is spend by guild over time
+type Report struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// oldest first
+	Days []DaySpend `json:"days"`
+	// totals within the period - most expensive first
+	Guilds []Spend `json:"guilds"`
+	Total  float64 `json:"total_usd"`
+
+	// running totals since records began - most expensive first
+	TopUsers []Spend `json:"top_users"`
+	Models   []Spend `json:"models"`
+}
+
+// maximum users included in a report
+const reportUsers = 10
+
+// Report summarizes spend over the last number of days (including today)
+// older days aren't kept, so at most retainedDays are reported
+func (l *Ledger) Report(days int) Report {
+	if days <= 0 {
+		days = 1
+	}
+	if days > retainedDays {
+		days = retainedDays
+	}
+	today := l.now().UTC()
+	from := today.AddDate(0, 0, -(days - 1))
+
+	report := Report{
+		From: from.Format(dayFormat),
+		To:   today.Format(dayFormat),
+		Days: []DaySpend{},
+	}
+
+	l.store.Read(func(data *ledgerData) {
+		guilds := make(map[string]*Totals)
+		for d := 0; d < days; d++ {
+			day := from.AddDate(0, 0, d).Format(dayFormat)
+			spend := DaySpend{Day: day, Guilds: make(map[string]float64)}
+			for guild, totals := range data.Days[day] {
+				spend.Guilds[guild] = totals.Cost
+				spend.Total += totals.Cost
+
+				if guilds[guild] == nil {
+					guilds[guild] = &Totals{}
+				}
+				guilds[guild].merge(totals)
+			}
+			report.Days = append(report.Days, spend)
+			report.Total += spend.Total
+		}
+
+		report.Guilds = ranked(guilds, 0)
+		report.TopUsers = ranked(data.Users, reportUsers)
+		report.Models = ranked(data.Models, 0)
+	})
+
+	return report
+}
+
+// sort by cost, most expensive first - limit 0 returns everything
+func ranked(totals map[string]*Totals, limit int) []Spend {
+	spend := []Spend{}
+	for id, t := range totals {
+		spend = append(spend, Spend{ID: id, Totals: *t})
+	}
+	sort.Slice(spend, func(i, j int) bool {
+		if spend[i].Cost == spend[j].Cost {
+			return spend[i].ID < spend[j].ID
+		}
+		return spend[i].Cost > spend[j].Cost
+	})
+	if limit > 0 && len(spend) > limit {
+		spend = spend[:limit]
+	}
+	return spend
+}
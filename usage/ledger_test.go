@@ -0,0 +1,176 @@
+package usage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestLedger(t *testing.T) *Ledger {
+	ledger, err := NewLedger(filepath.Join(t.TempDir(), "usage.json"))
+	require.NoError(t, err)
+	return ledger
+}
+
+func TestLedgerCost(t *testing.T) {
+	ledger := newTestLedger(t)
+
+	err := ledger.Record(
+		Attribution{GuildID: "guild", ChannelID: "channel", UserID: "user"},
+		Usage{Kind: KindChat, Model: "gpt-4o", PromptTokens: 1_000_000, CompletionTokens: 100_000},
+	)
+	require.NoError(t, err)
+	err = ledger.Record(
+		Attribution{GuildID: "guild", UserID: "user"},
+		Usage{Kind: KindImage, Model: "dall-e-3", Images: 2},
+	)
+	require.NoError(t, err)
+
+	report := ledger.Report(1)
+	assert.InDelta(t, 5+1.5+0.16, report.Total, 0.0001)
+	require.Len(t, report.Guilds, 1)
+	assert.Equal(t, "guild", report.Guilds[0].ID)
+	assert.Equal(t, 2, report.Guilds[0].Requests)
+	require.Len(t, report.TopUsers, 1)
+	assert.Equal(t, 2, report.TopUsers[0].Images)
+}
+
+func TestLedgerUnknownModelIsFree(t *testing.T) {
+	ledger := newTestLedger(t)
+
+	err := ledger.Record(Attribution{}, Usage{Kind: KindChat, Model: "llama3", PromptTokens: 500})
+	require.NoError(t, err)
+
+	report := ledger.Report(1)
+	assert.Zero(t, report.Total)
+	require.Len(t, report.Guilds, 1)
+	assert.Equal(t, DirectMessages, report.Guilds[0].ID)
+	assert.Equal(t, 500, report.Guilds[0].PromptTokens)
+
+	// overridden prices apply to later records
+	ledger.SetPrice("llama3", Price{Prompt: 1})
+	err = ledger.Record(Attribution{}, Usage{Kind: KindChat, Model: "llama3", PromptTokens: 1_000_000})
+	require.NoError(t, err)
+	assert.InDelta(t, 1, ledger.Report(1).Total, 0.0001)
+}
+
+func TestLedgerReportDays(t *testing.T) {
+	ledger := newTestLedger(t)
+
+	day := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	ledger.now = func() time.Time { return day }
+	require.NoError(t, ledger.Record(Attribution{GuildID: "a"}, Usage{Model: "dall-e-3", Images: 1}))
+
+	day = day.AddDate(0, 0, 2)
+	require.NoError(t, ledger.Record(Attribution{GuildID: "b"}, Usage{Model: "dall-e-3", Images: 2}))
+
+	report := ledger.Report(3)
+	assert.Equal(t, "2024-05-10", report.From)
+	assert.Equal(t, "2024-05-12", report.To)
+	require.Len(t, report.Days, 3)
+	assert.InDelta(t, 0.08, report.Days[0].Total, 0.0001)
+	assert.Zero(t, report.Days[1].Total)
+	assert.InDelta(t, 0.16, report.Days[2].Total, 0.0001)
+
+	// most expensive first
+	require.Len(t, report.Guilds, 2)
+	assert.Equal(t, "b", report.Guilds[0].ID)
+
+	// older days fall out of the period
+	report = ledger.Report(1)
+	require.Len(t, report.Guilds, 1)
+	assert.Equal(t, "b", report.Guilds[0].ID)
+}
+
+func TestLedgerPersists(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "usage.json")
+	ledger, err := NewLedger(filename)
+	require.NoError(t, err)
+
+	ctx := WithAttribution(WithLedger(context.Background(), ledger), Attribution{GuildID: "guild", UserID: "user"})
+	Record(ctx, Usage{Model: "whisper-1", AudioSeconds: 120})
+
+	// nothing is written until the ledger is flushed
+	reloaded, err := NewLedger(filename)
+	require.NoError(t, err)
+	assert.Zero(t, reloaded.Report(1).Total)
+
+	require.NoError(t, ledger.Flush())
+	reloaded, err = NewLedger(filename)
+	require.NoError(t, err)
+	report := reloaded.Report(1)
+	assert.InDelta(t, 0.012, report.Total, 0.0001)
+	require.Len(t, report.TopUsers, 1)
+	assert.Equal(t, "user", report.TopUsers[0].ID)
+}
+
+func TestRecordWithoutLedger(t *testing.T) {
+	// must not panic
+	Record(context.Background(), Usage{Model: "gpt-4o", PromptTokens: 1})
+}
+
+func TestLedgerPeriods(t *testing.T) {
+	ledger := newTestLedger(t)
+
+	day := time.Date(2024, 4, 30, 12, 0, 0, 0, time.UTC)
+	ledger.now = func() time.Time { return day }
+	require.NoError(t, ledger.Record(Attribution{GuildID: "guild", UserID: "user"}, Usage{Kind: KindMessage}))
+
+	day = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, ledger.Record(Attribution{GuildID: "guild", UserID: "user"}, Usage{Kind: KindMessage}))
+
+	day = time.Date(2024, 5, 2, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, ledger.Record(Attribution{GuildID: "guild", UserID: "user"}, Usage{Kind: KindMessage}))
+	require.NoError(t, ledger.Record(Attribution{GuildID: "guild", UserID: "user"}, Usage{Model: "dall-e-3", Images: 1}))
+
+	// last month isn't counted
+	periods := ledger.UserPeriods("user")
+	assert.Equal(t, 1, periods.Day.Messages)
+	assert.Equal(t, 2, periods.Month.Messages)
+	assert.Equal(t, 1, periods.Day.Images)
+	assert.Equal(t, 1, periods.Day.Requests)
+
+	periods = ledger.GuildPeriods("guild")
+	assert.Equal(t, 2, periods.Month.Messages)
+	assert.InDelta(t, 0.08, periods.Month.Cost, 0.0001)
+
+	assert.Equal(t, Periods{}, ledger.GuildPeriods("other"))
+
+	// messages aren't a model
+	report := ledger.Report(1)
+	require.Len(t, report.Models, 1)
+	assert.Equal(t, "dall-e-3", report.Models[0].ID)
+}
+
+func TestLedgerPrunesOldDays(t *testing.T) {
+	ledger := newTestLedger(t)
+
+	day := time.Date(2024, 4, 1, 12, 0, 0, 0, time.UTC)
+	ledger.now = func() time.Time { return day }
+	require.NoError(t, ledger.Record(Attribution{GuildID: "guild", UserID: "user"}, Usage{Kind: KindMessage}))
+
+	day = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, ledger.Record(Attribution{GuildID: "guild", UserID: "user"}, Usage{Kind: KindMessage}))
+
+	// still within the window
+	ledger.store.Read(func(data *ledgerData) {
+		assert.Len(t, data.Days, 2)
+		assert.Len(t, data.UserDays, 2)
+	})
+
+	day = time.Date(2024, 5, 3, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, ledger.Record(Attribution{GuildID: "guild", UserID: "user"}, Usage{Kind: KindMessage}))
+
+	ledger.store.Read(func(data *ledgerData) {
+		assert.NotContains(t, data.Days, "2024-04-01")
+		assert.NotContains(t, data.UserDays, "2024-04-01")
+		assert.Len(t, data.Days, 2)
+		// running totals are kept
+		assert.Equal(t, 3, data.Users["user"].Messages)
+	})
+	assert.Equal(t, 2, ledger.UserPeriods("user").Month.Messages)
+}
@@ -0,0 +1,37 @@
+package usage
+
+// Price is what a model costs in USD
+type Price struct {
+	Prompt     float64 // per 1M prompt tokens
+	Completion float64 // per 1M completion tokens
+	Image      float64 // per image
+	Minute     float64 // per minute of audio
+	Characters float64 // per 1K characters
+}
+
+// Prices maps model names to prices
+type Prices map[string]Price
+
+// DefaultPrices are list prices for the models aika uses by default.
+// Models missing from the table are free (self hosted).
+func DefaultPrices() Prices {
+	return Prices{
+		"gpt-3.5-turbo":          {Prompt: 0.5, Completion: 1.5},
+		"gpt-4-turbo-preview":    {Prompt: 10, Completion: 30},
+		"gpt-4o":                 {Prompt: 5, Completion: 15},
+		"whisper-1":              {Minute: 0.006},
+		"text-embedding-3-small": {Prompt: 0.02},
+		"dall-e-3":               {Image: 0.08}, // HD 1024x1024
+		"elevenlabs":             {Characters: 0.3},
+	}
+}
+
+// Cost returns the USD cost of the usage
+func (prices Prices) Cost(usage Usage) float64 {
+	price := prices[usage.Model]
+	return float64(usage.PromptTokens)/1_000_000*price.Prompt +
+		float64(usage.CompletionTokens)/1_000_000*price.Completion +
+		float64(usage.Images)*price.Image +
+		usage.AudioSeconds/60*price.Minute +
+		float64(usage.Characters)/1_000*price.Characters
+}
@@ -0,0 +1,149 @@
+package usage
+
+import (
+	"aika/storage"
+	"errors"
+	"fmt"
+)
+
+var ErrInvalidQuota = errors.New("invalid quota")
+
+// Limits caps usage within a period.
+// Zero means unlimited.
+type Limits struct {
+	Messages int
+	Tokens   int
+	Images   int
+	Cost     float64 // USD
+}
+
+// Quota caps usage per day & per month
+type Quota struct {
+	Daily   Limits
+	Monthly Limits
+}
+
+// Status is what happens to a message when quotas are checked
+type Status struct {
+	// over a usage limit - only cheap models & functions
+	Capped bool
+	// over the message limit - don't reply at all
+	Blocked bool
+	// first limit reached - "user daily images"
+	Reason string
+}
+
+// Check compares usage against the quota.
+// name prefixes the reason - "guild" or "user"
+func (q Quota) Check(name string, periods Periods) Status {
+	status := Status{}
+	check := func(period string, limits Limits, totals Totals) {
+		blocked, capped := limits.reached(totals)
+		if blocked != "" && !status.Blocked {
+			status.Blocked = true
+			status.Reason = fmt.Sprintf("%s %s %s", name, period, blocked)
+		}
+		if capped != "" && !status.Capped {
+			status.Capped = true
+			if status.Reason == "" {
+				status.Reason = fmt.Sprintf("%s %s %s", name, period, capped)
+			}
+		}
+	}
+	check("daily", q.Daily, periods.Day)
+	check("monthly", q.Monthly, periods.Month)
+	return status
+}
+
+// Merge combines the status of several quotas - the strictest wins
+func (s Status) Merge(other Status) Status {
+	if other.Blocked && !s.Blocked {
+		s.Reason = other.Reason
+	} else if s.Reason == "" {
+		s.Reason = other.Reason
+	}
+	s.Blocked = s.Blocked || other.Blocked
+	s.Capped = s.Capped || other.Capped
+	return s
+}
+
+// names of the limits the totals reached
+// messages block replies, everything else caps them
+func (l Limits) reached(t Totals) (blocked string, capped string) {
+	if l.Messages > 0 && t.Messages >= l.Messages {
+		blocked = "messages"
+	}
+	switch {
+	case l.Cost > 0 && t.Cost >= l.Cost:
+		capped = "cost"
+	case l.Tokens > 0 && t.PromptTokens+t.CompletionTokens >= l.Tokens:
+		capped = "tokens"
+	case l.Images > 0 && t.Images >= l.Images:
+		capped = "images"
+	}
+	return blocked, capped
+}
+
+// ParseQuota reads a quota from config
+//
+//	daily: {messages: 100, tokens: 200000, images: 5, cost: 1.5}
+//	monthly: {cost: 20}
+func ParseQuota(value interface{}) (Quota, error) {
+	quota := Quota{}
+	periods, ok := storage.StringMap(value)
+	if !ok {
+		return quota, ErrInvalidQuota
+	}
+
+	for period, value := range periods {
+		limits, err := parseLimits(value)
+		if err != nil {
+			return quota, fmt.Errorf("%s; %w", period, err)
+		}
+		switch period {
+		case "daily":
+			quota.Daily = limits
+		case "monthly":
+			quota.Monthly = limits
+		default:
+			return quota, fmt.Errorf("unknown period '%s'; %w", period, ErrInvalidQuota)
+		}
+	}
+
+	return quota, nil
+}
+
+func parseLimits(value interface{}) (Limits, error) {
+	limits := Limits{}
+	fields, ok := storage.StringMap(value)
+	if !ok {
+		return limits, ErrInvalidQuota
+	}
+
+	for key, value := range fields {
+		var amount float64
+		switch v := value.(type) {
+		case int:
+			amount = float64(v)
+		case float64:
+			amount = v
+		default:
+			return limits, fmt.Errorf("%s is not a number; %w", key, ErrInvalidQuota)
+		}
+
+		switch key {
+		case "messages":
+			limits.Messages = int(amount)
+		case "tokens":
+			limits.Tokens = int(amount)
+		case "images":
+			limits.Images = int(amount)
+		case "cost":
+			limits.Cost = amount
+		default:
+			return limits, fmt.Errorf("unknown limit '%s'; %w", key, ErrInvalidQuota)
+		}
+	}
+
+	return limits, nil
+}
@@ -0,0 +1,80 @@
+package usage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestQuotaCheck(t *testing.T) {
+	quota := Quota{
+		Daily:   Limits{Messages: 10, Images: 2},
+		Monthly: Limits{Cost: 5},
+	}
+
+	status := quota.Check("user", Periods{Day: Totals{Messages: 3, Images: 1}})
+	assert.False(t, status.Capped)
+	assert.False(t, status.Blocked)
+	assert.Empty(t, status.Reason)
+
+	status = quota.Check("user", Periods{Day: Totals{Images: 2}})
+	assert.True(t, status.Capped)
+	assert.False(t, status.Blocked)
+	assert.Equal(t, "user daily images", status.Reason)
+
+	status = quota.Check("user", Periods{Month: Totals{Cost: 5.01}})
+	assert.True(t, status.Capped)
+	assert.Equal(t, "user monthly cost", status.Reason)
+
+	status = quota.Check("user", Periods{Day: Totals{Messages: 10}})
+	assert.True(t, status.Blocked)
+	assert.False(t, status.Capped)
+	assert.Equal(t, "user daily messages", status.Reason)
+}
+
+func TestQuotaUnlimited(t *testing.T) {
+	status := Quota{}.Check("guild", Periods{
+		Day:   Totals{Messages: 1000, PromptTokens: 1_000_000, Images: 100, Cost: 100},
+		Month: Totals{Messages: 1000, PromptTokens: 1_000_000, Images: 100, Cost: 100},
+	})
+	assert.Equal(t, Status{}, status)
+}
+
+func TestStatusMerge(t *testing.T) {
+	capped := Status{Capped: true, Reason: "user daily cost"}
+	blocked := Status{Blocked: true, Reason: "guild daily messages"}
+
+	merged := capped.Merge(blocked)
+	assert.True(t, merged.Capped)
+	assert.True(t, merged.Blocked)
+	// blocking is the reason nothing happened
+	assert.Equal(t, "guild daily messages", merged.Reason)
+
+	merged = Status{}.Merge(capped)
+	assert.Equal(t, capped, merged)
+}
+
+func TestParseQuota(t *testing.T) {
+	var config interface{}
+	err := yaml.Unmarshal([]byte("daily: {messages: 100, tokens: 50000, images: 5, cost: 1.5}\nmonthly: {cost: 20}"), &config)
+	require.NoError(t, err)
+
+	quota, err := ParseQuota(config)
+	require.NoError(t, err)
+	assert.Equal(t, Limits{Messages: 100, Tokens: 50000, Images: 5, Cost: 1.5}, quota.Daily)
+	assert.Equal(t, Limits{Cost: 20}, quota.Monthly)
+
+	for _, invalid := range []string{
+		"weekly: {cost: 1}",
+		"daily: {dollars: 1}",
+		"daily: {cost: lots}",
+		"daily: 5",
+	} {
+		err := yaml.Unmarshal([]byte(invalid), &config)
+		require.NoError(t, err)
+		_, err = ParseQuota(config)
+		assert.ErrorIs(t, err, ErrInvalidQuota, invalid)
+	}
+}
@@ -0,0 +1,77 @@
+package usage
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+)
+
+type Kind string
+
+const (
+	KindChat          Kind = "chat"
+	KindTranscription Kind = "transcription"
+	KindImage         Kind = "image"
+	KindSpeech        Kind = "speech"
+	KindEmbedding     Kind = "embedding"
+	// a message aika replied to - counted for quotas, costs nothing
+	KindMessage Kind = "message"
+)
+
+// Usage is a single billable call to a paid API
+type Usage struct {
+	Kind  Kind
+	Model string
+
+	PromptTokens     int
+	CompletionTokens int
+	// tokens were estimated because the provider didn't report them
+	// (streams from providers without stream usage)
+	Estimated bool
+
+	Images       int
+	AudioSeconds float64
+	Characters   int
+}
+
+// Attribution is who a call is billed to
+type Attribution struct {
+	GuildID   string // empty for DMs
+	ChannelID string
+	UserID    string
+}
+
+type ledgerKey struct{}
+type attributionKey struct{}
+
+// WithLedger returns a context which records usage to the ledger
+func WithLedger(ctx context.Context, ledger *Ledger) context.Context {
+	return context.WithValue(ctx, ledgerKey{}, ledger)
+}
+
+// LedgerFrom returns the ledger usage is recorded to (or nil)
+func LedgerFrom(ctx context.Context) *Ledger {
+	ledger, _ := ctx.Value(ledgerKey{}).(*Ledger)
+	return ledger
+}
+
+// WithAttribution returns a context which bills usage to the guild, channel & user
+func WithAttribution(ctx context.Context, attribution Attribution) context.Context {
+	return context.WithValue(ctx, attributionKey{}, attribution)
+}
+
+// Record adds the usage to the context's ledger.
+// Does nothing if the context has no ledger.
+func Record(ctx context.Context, usage Usage) {
+	ledger := LedgerFrom(ctx)
+	if ledger == nil {
+		return
+	}
+
+	attribution, _ := ctx.Value(attributionKey{}).(Attribution)
+	err := ledger.Record(attribution, usage)
+	if err != nil {
+		// losing a record isn't worth failing a chat over
+		logrus.WithError(err).WithField("usage", usage).Warnln("failed to record usage")
+	}
+}